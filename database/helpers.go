@@ -2,18 +2,19 @@ package database
 
 import (
 	"database/sql"
+	"time"
 
 	"watgbridge/state"
 
 	"go.mau.fi/whatsmeow/types"
 )
 
-func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId, tgThreadId int64) error {
+func MsgIdAddNewPair(account, waMsgId, participantId, waChatId string, tgChatId, tgMsgId, tgThreadId int64) error {
 
 	db := state.State.Database
 
 	var bridgePair MsgIdPair
-	res := db.Where("id = ? AND wa_chat_id = ?", waMsgId, waChatId).Find(&bridgePair)
+	res := db.Where("account = ? AND id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).Find(&bridgePair)
 	if res.Error != nil {
 		return res.Error
 	}
@@ -31,6 +32,7 @@ func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId,
 	// else
 	res = db.Create(&MsgIdPair{
 		ID:            waMsgId,
+		Account:       account,
 		ParticipantId: participantId,
 		WaChatId:      waChatId,
 		TgChatId:      tgChatId,
@@ -41,12 +43,12 @@ func MsgIdAddNewPair(waMsgId, participantId, waChatId string, tgChatId, tgMsgId,
 	return res.Error
 }
 
-func MsgIdGetTgFromWa(waMsgId, waChatId string) (int64, int64, int64, error) {
+func MsgIdGetTgFromWa(account, waMsgId, waChatId string) (int64, int64, int64, error) {
 
 	db := state.State.Database
 
 	var bridgePair MsgIdPair
-	res := db.Where("id = ? AND wa_chat_id = ?", waMsgId, waChatId).Find(&bridgePair)
+	res := db.Where("account = ? AND id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).Find(&bridgePair)
 
 	return bridgePair.TgChatId, bridgePair.TgThreadId, bridgePair.TgMsgId, res.Error
 }
@@ -61,12 +63,22 @@ func MsgIdGetWaFromTg(tgChatId, tgMsgId, tgThreadId int64) (msgId, participantId
 	return bridgePair.ID, bridgePair.ParticipantId, bridgePair.WaChatId, res.Error
 }
 
-func MsgIdGetUnread(waChatId string) (map[string]([]string), error) {
+func MsgIdGetPairFromTg(tgChatId, tgMsgId int64) (MsgIdPair, error) {
+
+	db := state.State.Database
+
+	var bridgePair MsgIdPair
+	res := db.Where("tg_chat_id = ? AND tg_msg_id = ?", tgChatId, tgMsgId).Find(&bridgePair)
+
+	return bridgePair, res.Error
+}
+
+func MsgIdGetUnread(account, waChatId string) (map[string]([]string), error) {
 
 	db := state.State.Database
 
 	var bridgePairs []MsgIdPair
-	res := db.Where("wa_chat_id = ? AND mark_read = false", waChatId).Find(&bridgePairs)
+	res := db.Where("account = ? AND wa_chat_id = ? AND mark_read = false", account, waChatId).Find(&bridgePairs)
 
 	var msgIds = make(map[string]([]string))
 
@@ -80,12 +92,12 @@ func MsgIdGetUnread(waChatId string) (map[string]([]string), error) {
 	return msgIds, res.Error
 }
 
-func MsgIdMarkRead(waChatId, waMsgId string) error {
+func MsgIdMarkRead(account, waChatId, waMsgId string) error {
 
 	db := state.State.Database
 
 	var bridgePair MsgIdPair
-	res := db.Where("id = ? AND wa_chat_id = ?", waMsgId, waChatId).Find(&bridgePair)
+	res := db.Where("account = ? AND id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).Find(&bridgePair)
 	if res.Error != nil {
 		return res.Error
 	}
@@ -107,6 +119,16 @@ func MsgIdDeletePair(tgChatId, tgMsgId int64) error {
 	return res.Error
 }
 
+func MsgIdGetPairsFromTg(tgChatId, tgMsgId int64) ([]MsgIdPair, error) {
+
+	db := state.State.Database
+
+	var pairs []MsgIdPair
+	res := db.Where("tg_chat_id = ? AND tg_msg_id = ?", tgChatId, tgMsgId).Find(&pairs)
+
+	return pairs, res.Error
+}
+
 func MsgIdDropAllPairs() error {
 
 	db := state.State.Database
@@ -115,12 +137,12 @@ func MsgIdDropAllPairs() error {
 	return res.Error
 }
 
-func ChatThreadAddNewPair(waChatId string, tgChatId, tgThreadId int64) error {
+func ChatThreadAddNewPair(account, waChatId string, tgChatId, tgThreadId int64) error {
 
 	db := state.State.Database
 
 	var chatPair ChatThreadPair
-	res := db.Where("id = ? AND tg_chat_id = ?", waChatId, tgChatId).Find(&chatPair)
+	res := db.Where("account = ? AND id = ? AND tg_chat_id = ?", account, waChatId, tgChatId).Find(&chatPair)
 	if res.Error != nil {
 		return res.Error
 	}
@@ -135,18 +157,19 @@ func ChatThreadAddNewPair(waChatId string, tgChatId, tgThreadId int64) error {
 	// else
 	res = db.Create(&ChatThreadPair{
 		ID:         waChatId,
+		Account:    account,
 		TgChatId:   tgChatId,
 		TgThreadId: tgThreadId,
 	})
 	return res.Error
 }
 
-func ChatThreadGetTgFromWa(waChatId string, tgChatId int64) (int64, bool, error) {
+func ChatThreadGetTgFromWa(account, waChatId string, tgChatId int64) (int64, bool, error) {
 
 	db := state.State.Database
 
 	var chatPair ChatThreadPair
-	res := db.Where("id = ? AND tg_chat_id = ?", waChatId, tgChatId).Find(&chatPair)
+	res := db.Where("account = ? AND id = ? AND tg_chat_id = ?", account, waChatId, tgChatId).Find(&chatPair)
 
 	found := (chatPair.ID == waChatId && chatPair.TgChatId == tgChatId)
 	return chatPair.TgThreadId, found, res.Error
@@ -171,6 +194,16 @@ func ChatThreadGetWaFromTg(tgChatId, tgThreadId int64) (string, error) {
 	return chatPair.ID, res.Error
 }
 
+func ChatThreadGetAccountFromTg(tgChatId, tgThreadId int64) (string, error) {
+
+	db := state.State.Database
+
+	var chatPair ChatThreadPair
+	res := db.Where("tg_chat_id = ? AND tg_thread_id = ?", tgChatId, tgThreadId).Find(&chatPair)
+
+	return chatPair.Account, res.Error
+}
+
 func ChatThreadGetAllPairs(tgChatId int64) ([]ChatThreadPair, error) {
 
 	db := state.State.Database
@@ -385,3 +418,427 @@ func GetEphemeralSettings(waChatId string) (bool, uint32, bool, error) {
 
 	return settings.IsEphemeral, settings.EphemeralTimer, true, nil
 }
+
+func WaLabelAddOrUpdate(account, labelId, name string) error {
+
+	db := state.State.Database
+	res := db.Save(&WaLabel{
+		Account: account,
+		ID:      labelId,
+		Name:    name,
+	})
+
+	return res.Error
+}
+
+func WaLabelDelete(account, labelId string) error {
+
+	db := state.State.Database
+
+	res := db.Where("account = ? AND label_id = ?", account, labelId).Delete(&WaChatLabel{})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	res = db.Where("account = ? AND id = ?", account, labelId).Delete(&WaLabel{})
+	return res.Error
+}
+
+func WaChatLabelAdd(account, waChatId, labelId string) error {
+
+	db := state.State.Database
+	res := db.Save(&WaChatLabel{
+		Account:  account,
+		WaChatId: waChatId,
+		LabelId:  labelId,
+	})
+
+	return res.Error
+}
+
+func WaChatLabelDelete(account, waChatId, labelId string) error {
+
+	db := state.State.Database
+	res := db.Where("account = ? AND wa_chat_id = ? AND label_id = ?", account, waChatId, labelId).Delete(&WaChatLabel{})
+
+	return res.Error
+}
+
+func WaChatLabelGetNames(account, waChatId string) ([]string, error) {
+
+	db := state.State.Database
+
+	var chatLabels []WaChatLabel
+	res := db.Where("account = ? AND wa_chat_id = ?", account, waChatId).Find(&chatLabels)
+	if res.Error != nil || len(chatLabels) == 0 {
+		return nil, res.Error
+	}
+
+	var labelIds []string
+	for _, chatLabel := range chatLabels {
+		labelIds = append(labelIds, chatLabel.LabelId)
+	}
+
+	var labels []WaLabel
+	res = db.Where("account = ? AND id IN ?", account, labelIds).Find(&labels)
+
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	return names, res.Error
+}
+
+func OutboundMsgAddNew(msg *OutboundMsg) error {
+
+	db := state.State.Database
+
+	msg.State = "pending"
+	res := db.Create(msg)
+
+	return res.Error
+}
+
+func OutboundMsgGetPending() ([]OutboundMsg, error) {
+
+	db := state.State.Database
+
+	var msgs []OutboundMsg
+	res := db.Where("state = ?", "pending").Order("id").Find(&msgs)
+
+	return msgs, res.Error
+}
+
+func OutboundMsgGetPendingByChat(tgChatId int64, waChatId string) ([]OutboundMsg, error) {
+
+	db := state.State.Database
+
+	var msgs []OutboundMsg
+	res := db.Where("tg_chat_id = ? AND wa_chat_id = ? AND state = ?", tgChatId, waChatId, "pending").Order("id").Find(&msgs)
+
+	return msgs, res.Error
+}
+
+func OutboundMsgGet(id uint) (OutboundMsg, bool, error) {
+
+	db := state.State.Database
+
+	var msgs []OutboundMsg
+	res := db.Where("id = ? AND state = ?", id, "pending").Limit(1).Find(&msgs)
+	if res.Error != nil || len(msgs) == 0 {
+		return OutboundMsg{}, false, res.Error
+	}
+
+	return msgs[0], true, nil
+}
+
+func OutboundMsgGetFailed() ([]OutboundMsg, error) {
+
+	db := state.State.Database
+
+	var msgs []OutboundMsg
+	res := db.Where("state = ?", "failed").Order("id").Find(&msgs)
+
+	return msgs, res.Error
+}
+
+func OutboundMsgGetFailedById(id uint) (OutboundMsg, bool, error) {
+
+	db := state.State.Database
+
+	var msgs []OutboundMsg
+	res := db.Where("id = ? AND state = ?", id, "failed").Limit(1).Find(&msgs)
+	if res.Error != nil || len(msgs) == 0 {
+		return OutboundMsg{}, false, res.Error
+	}
+
+	return msgs[0], true, nil
+}
+
+func OutboundMsgGetPendingByTg(tgChatId, tgMsgId int64) (OutboundMsg, bool, error) {
+
+	db := state.State.Database
+
+	var msgs []OutboundMsg
+	res := db.Where("tg_chat_id = ? AND tg_msg_id = ? AND state = ?", tgChatId, tgMsgId, "pending").Limit(1).Find(&msgs)
+	if res.Error != nil || len(msgs) == 0 {
+		return OutboundMsg{}, false, res.Error
+	}
+
+	return msgs[0], true, nil
+}
+
+func OutboundMsgHoldAlbum(tgChatId int64, tgAlbumId string, until time.Time) error {
+
+	db := state.State.Database
+	res := db.Model(&OutboundMsg{}).Where("tg_chat_id = ? AND tg_album_id = ? AND state = ?", tgChatId, tgAlbumId, "pending").
+		Update("next_attempt_at", until)
+
+	return res.Error
+}
+
+func OutboundMsgSave(msg *OutboundMsg) error {
+
+	db := state.State.Database
+	res := db.Save(msg)
+
+	return res.Error
+}
+
+func OutboundMsgDelete(id uint) error {
+
+	db := state.State.Database
+	res := db.Where("id = ?", id).Delete(&OutboundMsg{})
+
+	return res.Error
+}
+
+func MsgVersionAddNew(version *MsgVersion) error {
+
+	db := state.State.Database
+	res := db.Create(version)
+
+	return res.Error
+}
+
+func MsgVersionGetAll(account, waMsgId, waChatId string) ([]MsgVersion, error) {
+
+	db := state.State.Database
+
+	var versions []MsgVersion
+	res := db.Where("account = ? AND wa_msg_id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).
+		Order("id").Find(&versions)
+
+	return versions, res.Error
+}
+
+func WaReactionSet(account, waMsgId, waChatId, senderId, emoji string) error {
+
+	db := state.State.Database
+
+	if emoji == "" {
+		res := db.Where("account = ? AND wa_msg_id = ? AND wa_chat_id = ? AND sender_id = ?",
+			account, waMsgId, waChatId, senderId).Delete(&WaReaction{})
+		return res.Error
+	}
+
+	res := db.Save(&WaReaction{
+		Account:  account,
+		WaMsgId:  waMsgId,
+		WaChatId: waChatId,
+		SenderId: senderId,
+		Emoji:    emoji,
+	})
+
+	return res.Error
+}
+
+func WaReactionGetAll(account, waMsgId, waChatId string) ([]WaReaction, error) {
+
+	db := state.State.Database
+
+	var reactions []WaReaction
+	res := db.Where("account = ? AND wa_msg_id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).
+		Order("updated_at").Find(&reactions)
+
+	return reactions, res.Error
+}
+
+func WaPollAddNew(poll *WaPoll) error {
+
+	db := state.State.Database
+	res := db.Save(poll)
+
+	return res.Error
+}
+
+func WaPollGet(account, waMsgId, waChatId string) (WaPoll, bool, error) {
+
+	db := state.State.Database
+
+	var polls []WaPoll
+	res := db.Where("account = ? AND wa_msg_id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).Limit(1).Find(&polls)
+	if res.Error != nil || len(polls) == 0 {
+		return WaPoll{}, false, res.Error
+	}
+
+	return polls[0], true, nil
+}
+
+func WaPollVoteSet(account, waMsgId, waChatId, voterId, options string) error {
+
+	db := state.State.Database
+	res := db.Save(&WaPollVote{
+		Account:  account,
+		WaMsgId:  waMsgId,
+		WaChatId: waChatId,
+		VoterId:  voterId,
+		Options:  options,
+	})
+
+	return res.Error
+}
+
+func WaPollVoteGetAll(account, waMsgId, waChatId string) ([]WaPollVote, error) {
+
+	db := state.State.Database
+
+	var votes []WaPollVote
+	res := db.Where("account = ? AND wa_msg_id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).
+		Order("updated_at").Find(&votes)
+
+	return votes, res.Error
+}
+
+func WaLiveLocationSet(liveLocation *WaLiveLocation) error {
+
+	db := state.State.Database
+	res := db.Save(liveLocation)
+
+	return res.Error
+}
+
+func WaLiveLocationGet(account, waChatId, senderId string) (WaLiveLocation, bool, error) {
+
+	db := state.State.Database
+
+	var liveLocations []WaLiveLocation
+	res := db.Where("account = ? AND wa_chat_id = ? AND sender_id = ?", account, waChatId, senderId).Limit(1).Find(&liveLocations)
+	if res.Error != nil || len(liveLocations) == 0 {
+		return WaLiveLocation{}, false, res.Error
+	}
+
+	return liveLocations[0], true, nil
+}
+
+func WaUndecryptableAddNew(undecryptable *WaUndecryptable) error {
+
+	db := state.State.Database
+
+	undecryptable.State = "waiting"
+	res := db.Save(undecryptable)
+
+	return res.Error
+}
+
+func WaUndecryptableGet(account, waMsgId, waChatId string) (WaUndecryptable, bool, error) {
+
+	db := state.State.Database
+
+	var undecryptables []WaUndecryptable
+	res := db.Where("account = ? AND wa_msg_id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).Limit(1).Find(&undecryptables)
+	if res.Error != nil || len(undecryptables) == 0 {
+		return WaUndecryptable{}, false, res.Error
+	}
+
+	return undecryptables[0], true, nil
+}
+
+func WaUndecryptableGetWaitingBefore(before time.Time) ([]WaUndecryptable, error) {
+
+	db := state.State.Database
+
+	var undecryptables []WaUndecryptable
+	res := db.Where("state = ? AND created_at < ?", "waiting", before).Find(&undecryptables)
+
+	return undecryptables, res.Error
+}
+
+func WaUndecryptableSetState(account, waMsgId, waChatId, newState string) error {
+
+	db := state.State.Database
+	res := db.Model(&WaUndecryptable{}).Where("account = ? AND wa_msg_id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).
+		Update("state", newState)
+
+	return res.Error
+}
+
+func WaUndecryptableDelete(account, waMsgId, waChatId string) error {
+
+	db := state.State.Database
+	res := db.Where("account = ? AND wa_msg_id = ? AND wa_chat_id = ?", account, waMsgId, waChatId).Delete(&WaUndecryptable{})
+
+	return res.Error
+}
+
+// WaUndecryptableCountFailed returns the number of messages which were never decrypted, keyed
+// by the account and chat as "account|chat"
+func WaUndecryptableCountFailed() (map[string]int64, error) {
+
+	db := state.State.Database
+
+	var rows []struct {
+		Account  string
+		WaChatId string
+		Count    int64
+	}
+	res := db.Model(&WaUndecryptable{}).Select("account, wa_chat_id, count(*) as count").
+		Where("state = ?", "failed").Group("account, wa_chat_id").Scan(&rows)
+
+	counts := make(map[string]int64)
+	for _, row := range rows {
+		counts[row.Account+"|"+row.WaChatId] = row.Count
+	}
+	return counts, res.Error
+}
+
+func InboundMsgAddNew(msg *InboundMsg) error {
+
+	db := state.State.Database
+
+	if msg.State == "" {
+		msg.State = "pending"
+	}
+	res := db.Create(msg)
+
+	return res.Error
+}
+
+func InboundMsgGetDeadById(id uint) (InboundMsg, bool, error) {
+
+	db := state.State.Database
+
+	var msgs []InboundMsg
+	res := db.Where("id = ? AND state = ?", id, "dead").Limit(1).Find(&msgs)
+	if res.Error != nil || len(msgs) == 0 {
+		return InboundMsg{}, false, res.Error
+	}
+
+	return msgs[0], true, nil
+}
+
+func InboundMsgGetByState(msgState string) ([]InboundMsg, error) {
+
+	db := state.State.Database
+
+	var msgs []InboundMsg
+	res := db.Where("state = ?", msgState).Order("id").Find(&msgs)
+
+	return msgs, res.Error
+}
+
+func InboundMsgHasPendingInThread(tgChatId, tgThreadId int64) (bool, error) {
+
+	db := state.State.Database
+
+	var count int64
+	res := db.Model(&InboundMsg{}).Where("tg_chat_id = ? AND tg_thread_id = ? AND state = ?", tgChatId, tgThreadId, "pending").Count(&count)
+
+	return count > 0, res.Error
+}
+
+func InboundMsgSave(msg *InboundMsg) error {
+
+	db := state.State.Database
+	res := db.Save(msg)
+
+	return res.Error
+}
+
+func InboundMsgDelete(id uint) error {
+
+	db := state.State.Database
+	res := db.Where("id = ?", id).Delete(&InboundMsg{})
+
+	return res.Error
+}
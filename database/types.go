@@ -2,13 +2,19 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
 	"watgbridge/state"
+
+	"gorm.io/gorm"
 )
 
 type MsgIdPair struct {
 	// WhatsApp
 	ID            string `gorm:"primaryKey;"` // Message ID
+	Account       string `gorm:"primaryKey;"` // Name of the bridged WhatsApp account
 	ParticipantId string // Sender JID
 	WaChatId      string // Chat JID
 
@@ -17,11 +23,13 @@ type MsgIdPair struct {
 	TgThreadId int64
 	TgMsgId    int64
 
-	MarkRead sql.NullBool
+	MarkRead  sql.NullBool
+	CreatedAt time.Time `gorm:"index"`
 }
 
 type ChatThreadPair struct {
 	ID         string `gorm:"primaryKey;"` // WhatsApp Chat ID
+	Account    string `gorm:"primaryKey;"` // Name of the bridged WhatsApp account
 	TgChatId   int64  // Telegram Chat ID
 	TgThreadId int64  // Telegram Thread ID (Topics)
 }
@@ -41,12 +49,227 @@ type ChatEphemeralSettings struct {
 	EphemeralTimer uint32
 }
 
+type WaLabel struct {
+	Account string `gorm:"primaryKey;"` // Name of the bridged WhatsApp account
+	ID      string `gorm:"primaryKey;"` // Label ID
+	Name    string
+}
+
+type WaChatLabel struct {
+	Account  string `gorm:"primaryKey;"` // Name of the bridged WhatsApp account
+	WaChatId string `gorm:"primaryKey;"` // Chat JID
+	LabelId  string `gorm:"primaryKey;"`
+}
+
+type OutboundMsg struct {
+	ID uint `gorm:"primaryKey;autoIncrement"`
+
+	// Telegram
+	TgChatId      int64
+	TgThreadId    int64
+	TgMsgId       int64  // Message that has to be bridged
+	TgStatusMsgId int64  // Reply showing the state of the queued message
+	TgUpdate      string // JSON encoded update which triggered the send
+	TgMessage     string // JSON encoded message to forward
+	TgReplyTo     string // JSON encoded message being replied to, if any
+	TgAlbumId     string // Media group of the message, while the rest of the album is awaited
+
+	// WhatsApp
+	WaChatId      string
+	ParticipantId string
+	StanzaId      string
+	IsReply       bool
+	WaAlbumId     string // Album the message is sent as an item of, if any
+	LinkPreview   string // JSON encoded preview of the first link, made once when the message is queued
+
+	// @Name mentions
+	Mentions        string // JSON encoded JIDs picked for ambiguous names, keyed by the name
+	AwaitingMention string // Name the user has to pick a member for before the message is sent
+
+	State         string // pending, failed
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+}
+
+// MsgVersion is one version of the text or caption of a message bridged from WhatsApp, kept to
+// show the edit history of the message
+type MsgVersion struct {
+	ID uint `gorm:"primaryKey;autoIncrement"`
+
+	Account  string `gorm:"index:idx_msg_version;"` // Name of the bridged WhatsApp account
+	WaMsgId  string `gorm:"index:idx_msg_version;"`
+	WaChatId string `gorm:"index:idx_msg_version;"`
+
+	Text      string    // Plain text or caption of this version
+	Rendered  string    // HTML the Telegram message showed for this version
+	IsCaption bool      // Whether the Telegram message is a media with a caption
+	IsEdit    bool      // False for the original message
+	CreatedAt time.Time // When this version was sent or edited on WhatsApp
+}
+
+// WaReaction is the current reaction of one WhatsApp user to a bridged message, kept to set the
+// most used reaction on the Telegram message
+type WaReaction struct {
+	Account   string `gorm:"primaryKey;"` // Name of the bridged WhatsApp account
+	WaMsgId   string `gorm:"primaryKey;"` // ID of the message reacted to
+	WaChatId  string `gorm:"primaryKey;"`
+	SenderId  string `gorm:"primaryKey;"` // JID of the user who reacted
+	Emoji     string
+	UpdatedAt time.Time
+}
+
+// WaPoll is a WhatsApp poll bridged as a message with a button for each option, kept to count
+// the votes and to vote from Telegram
+type WaPoll struct {
+	Account         string `gorm:"primaryKey;"` // Name of the bridged WhatsApp account
+	WaMsgId         string `gorm:"primaryKey;"`
+	WaChatId        string `gorm:"primaryKey;"`
+	SenderId        string // JID of the user who created the poll
+	Question        string
+	Options         string // JSON encoded option names
+	SelectableCount uint32 // How many options can be selected, 0 for any number
+	Header          string // HTML the Telegram message shows above the poll
+	FromTelegram    bool   // Created on Telegram, where the bot cannot show the votes on the poll itself
+}
+
+// WaPollVote is the current vote of one user in a bridged poll
+type WaPollVote struct {
+	Account   string `gorm:"primaryKey;"` // Name of the bridged WhatsApp account
+	WaMsgId   string `gorm:"primaryKey;"` // ID of the poll
+	WaChatId  string `gorm:"primaryKey;"`
+	VoterId   string `gorm:"primaryKey;"` // JID of the user who voted
+	Options   string // JSON encoded names of the selected options
+	UpdatedAt time.Time
+}
+
+// WaLiveLocation is a live location being shared on WhatsApp, whose updates move the live
+// location bridged to Telegram
+type WaLiveLocation struct {
+	Account        string `gorm:"primaryKey;"` // Name of the bridged WhatsApp account
+	WaChatId       string `gorm:"primaryKey;"`
+	SenderId       string `gorm:"primaryKey;"` // JID of the user sharing the location
+	TgChatId       int64
+	TgMsgId        int64
+	SequenceNumber int64     // Of the latest update, older updates arriving late are dropped
+	ExpiresAt      time.Time // When the Telegram live location stops, a new one is sent after that
+}
+
+// WaUndecryptable is a WhatsApp message which could not be decrypted, shown on Telegram by a
+// placeholder until the sender's phone sends it again
+type WaUndecryptable struct {
+	Account    string `gorm:"primaryKey;"` // Name of the bridged WhatsApp account
+	WaMsgId    string `gorm:"primaryKey;"`
+	WaChatId   string `gorm:"primaryKey;"`
+	TgChatId   int64
+	TgThreadId int64
+	TgMsgId    int64  // Placeholder
+	Header     string // Of the placeholder, kept when it is edited
+	State      string // waiting, failed
+	CreatedAt  time.Time
+}
+
+type InboundMsg struct {
+	ID uint `gorm:"primaryKey;autoIncrement"`
+
+	// WhatsApp
+	Account       string // Name of the bridged WhatsApp account
+	WaMsgId       string // Message ID, empty if the request is not paired to a message
+	ParticipantId string // Sender JID
+	WaChatId      string // Chat JID
+
+	// Telegram
+	TgChatId   int64
+	TgThreadId int64
+	Method     string // Bot API method of the failed request
+	Params     string // JSON encoded request parameters
+	Files      string // JSON encoded files attached to the request
+
+	State         string // pending, dead
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+}
+
 func AutoMigrate() error {
 	db := state.State.Database
+
+	err := migrateToAccountScope(&MsgIdPair{}, &ChatThreadPair{})
+	if err != nil {
+		return err
+	}
+
 	return db.AutoMigrate(
 		&MsgIdPair{},
 		&ChatThreadPair{},
 		&ContactName{},
 		&ChatEphemeralSettings{},
+		&WaLabel{},
+		&MsgVersion{},
+		&WaChatLabel{},
+		&WaReaction{},
+		&WaPoll{},
+		&WaPollVote{},
+		&WaLiveLocation{},
+		&OutboundMsg{},
+		&InboundMsg{},
+		&WaUndecryptable{},
+	)
+}
+
+// migrateToAccountScope adds the account column to the primary key of tables created before
+// multiple WhatsApp accounts were supported. The existing rows are given to the first account.
+func migrateToAccountScope(models ...interface{}) error {
+	var (
+		db          = state.State.Database
+		accountName = state.State.Config.WhatsApp.Accounts[0].Name
 	)
+
+	for _, model := range models {
+		migrator := db.Migrator()
+		if !migrator.HasTable(model) || migrator.HasColumn(model, "Account") {
+			continue
+		}
+
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		var (
+			table    = stmt.Schema.Table
+			newTable = table + "_with_account"
+		)
+
+		columnTypes, err := migrator.ColumnTypes(model)
+		if err != nil {
+			return err
+		}
+		var columns []string
+		for _, columnType := range columnTypes {
+			columns = append(columns, columnType.Name())
+		}
+		columnList := strings.Join(columns, ", ")
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Table(newTable).Migrator().CreateTable(model); err != nil {
+				return err
+			}
+			res := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s, account) SELECT %s, ? FROM %s",
+				newTable, columnList, columnList, table), accountName)
+			if res.Error != nil {
+				return res.Error
+			}
+			if err := tx.Migrator().DropTable(table); err != nil {
+				return err
+			}
+			return tx.Migrator().RenameTable(newTable, table)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add account column to %s : %s", table, err)
+		}
+	}
+
+	return nil
 }
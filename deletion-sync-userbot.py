@@ -0,0 +1,79 @@
+#!/usr/bin/env python3
+"""
+Companion userbot for the deletion_sync option of watgbridge.
+
+Telegram does not tell bots about deleted messages, so this logs in as a user who is a member of
+the target chats, listens for deletions (updateDeleteChannelMessages) and reports them to the
+bridge, which revokes the bridged messages on WhatsApp.
+
+    pip install telethon
+    export TG_API_ID=... TG_API_HASH=...                 # from https://my.telegram.org
+    export WATG_DELETIONS_URL=http://127.0.0.1:8091/deleted
+    export WATG_DELETIONS_SECRET=...                     # deletion_sync.secret of the bridge
+    export WATG_CHAT_IDS=-100xxxxxxxxxx                  # target chats, separated by commas
+    python3 deletion-sync-userbot.py
+
+The first run asks for the phone number and login code of the user, the session is kept in
+deletion-sync.session (or WATG_SESSION) in the working directory for the later runs.
+"""
+
+import asyncio
+import json
+import logging
+import os
+import sys
+import urllib.request
+
+from telethon import TelegramClient, events
+
+logging.basicConfig(format="%(asctime)s %(levelname)s %(message)s", level=logging.INFO)
+logger = logging.getLogger("deletion-sync")
+
+
+def env(name):
+    value = os.environ.get(name, "").strip()
+    if not value:
+        sys.exit(f"{name} is not set, see the top of this file")
+    return value
+
+
+def report(url, secret, chat_id, message_ids):
+    request = urllib.request.Request(
+        url,
+        data=json.dumps({"chat_id": chat_id, "message_ids": message_ids}).encode(),
+        headers={"Authorization": f"Bearer {secret}", "Content-Type": "application/json"},
+        method="POST",
+    )
+    with urllib.request.urlopen(request, timeout=30) as response:
+        return json.load(response).get("revoked", 0)
+
+
+def main():
+    api_id = int(env("TG_API_ID"))
+    api_hash = env("TG_API_HASH")
+    url = env("WATG_DELETIONS_URL")
+    secret = env("WATG_DELETIONS_SECRET")
+    chat_ids = {int(chat_id) for chat_id in env("WATG_CHAT_IDS").split(",") if chat_id.strip()}
+
+    client = TelegramClient(os.environ.get("WATG_SESSION", "deletion-sync"), api_id, api_hash)
+
+    @client.on(events.MessageDeleted())
+    async def on_deleted(event):
+        # Deletions outside of supergroups do not say which chat they happened in
+        if event.chat_id not in chat_ids:
+            return
+
+        try:
+            revoked = await asyncio.to_thread(report, url, secret, event.chat_id, event.deleted_ids)
+        except Exception as e:
+            logger.error("failed to report deletion of %s in %s: %s", event.deleted_ids, event.chat_id, e)
+            return
+        logger.info("reported deletion of %s in %s, %d revoked", event.deleted_ids, event.chat_id, revoked)
+
+    client.start()
+    logger.info("listening for deletions in %s", ", ".join(map(str, sorted(chat_ids))))
+    client.run_until_disconnected()
+
+
+if __name__ == "__main__":
+    main()
@@ -118,13 +118,18 @@ func main() {
 	logger.Sync()
 
 	// setup database
-	err = whatsapp.NewWhatsAppClient()
+	err = whatsapp.NewWhatsAppClients()
 	if err != nil {
 		panic(err)
 	}
 	logger.Sync()
 
-	state.State.WhatsAppClient.AddEventHandler(whatsapp.WhatsAppEventHandler)
+	for _, account := range state.State.WhatsAppAccounts {
+		account.Client.AddEventHandler(func(evt interface{}) {
+			whatsapp.WhatsAppEventHandler(account, evt)
+		})
+		modules.LoadModuleWhatsAppHandlers(account)
+	}
 
 	// manage recurring tasks
 	state.State.StartTime = time.Now().UTC()
@@ -132,14 +137,42 @@ func main() {
 	s := gocron.NewScheduler(time.UTC)
 	s.TagsUnique()
 	_, scheduleErr := s.Every(1).Hour().Tag("contact_update").Do(func() {
-		contacts, err := state.State.WhatsAppClient.Store.Contacts.GetAllContacts(context.Background())
-		if err == nil {
-			_ = database.ContactNameBulkAddOrUpdate(contacts)
+		for _, account := range state.State.WhatsAppAccounts {
+			contacts, err := account.Client.Store.Contacts.GetAllContacts(context.Background())
+			if err == nil {
+				_ = database.ContactNameBulkAddOrUpdate(contacts)
+			}
 		}
 	})
 	if scheduleErr != nil {
 		fmt.Printf("Failed to schedule contact update %v\n\n", scheduleErr)
 	}
+	_, scheduleErr = s.Every(10).Seconds().Tag("outbound_queue").Do(utils.OutboundProcessQueue)
+	if scheduleErr != nil {
+		fmt.Printf("Failed to schedule outbound queue processing %v\n\n", scheduleErr)
+	}
+
+	_, scheduleErr = s.Every(10).Seconds().Tag("inbound_queue").Do(utils.InboundProcessQueue)
+	if scheduleErr != nil {
+		fmt.Printf("Failed to schedule inbound queue processing %v\n\n", scheduleErr)
+	}
+
+	_, scheduleErr = s.Every(1).Minute().SingletonMode().Tag("undecryptable_expiry").Do(utils.WaUndecryptableExpire)
+	if scheduleErr != nil {
+		fmt.Printf("Failed to schedule expiry of undecryptable messages %v\n\n", scheduleErr)
+	}
+	if state.State.Config.Telegram.DeletionSync.Enabled {
+		go utils.TgServeDeletions()
+	}
+	if state.State.Config.MediaStore.Enabled {
+		go utils.MediaStoreServe()
+
+		_, scheduleErr = s.Every(1).Hour().Tag("media_store_cleanup").Do(utils.MediaStoreCleanup)
+		if scheduleErr != nil {
+			fmt.Printf("Failed to schedule media store cleanup %v\n\n", scheduleErr)
+		}
+	}
+	s.StartAsync()
 
 	// keep the application running
 	state.State.TelegramUpdater.Idle()
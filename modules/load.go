@@ -38,10 +38,6 @@ func LoadModuleHandlers() {
 		}
 	}
 
-	for _, handler := range WhatsAppHandlers {
-		state.State.WhatsAppClient.AddEventHandler(handler)
-	}
-
 	if len(state.State.Modules) > 0 {
 		logger.Info("loaded some modules",
 			zap.Int("count", len(state.State.Modules)),
@@ -52,6 +48,13 @@ func LoadModuleHandlers() {
 	}
 }
 
+// LoadModuleWhatsAppHandlers adds the WhatsApp handlers of the modules to the client of an account
+func LoadModuleWhatsAppHandlers(account *state.WhatsAppAccount) {
+	for _, handler := range WhatsAppHandlers {
+		account.Client.AddEventHandler(handler)
+	}
+}
+
 func init() {
 	lock = &sync.Mutex{}
 	startingValue = telegram.ModulesStartingHandlerGroup
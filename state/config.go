@@ -4,11 +4,62 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"gopkg.in/yaml.v3"
 )
 
+// Ways of handling a WhatsApp message being revoked: reply to the bridged message saying so,
+// strike through its text, or move it to an archive topic
+const (
+	RevokePolicyAnnotate = "annotate"
+	RevokePolicyStrike   = "strike"
+	RevokePolicyDelete   = "delete"
+)
+
+var RevokePolicies = []string{RevokePolicyAnnotate, RevokePolicyStrike, RevokePolicyDelete}
+
+// Media types which the size limits for sending to Telegram can be set for
+var SizeLimitMediaTypes = []string{"image", "video", "audio", "document"}
+
+// Secrets signing links or guarding endpoints have to be at least this long, and cannot be left
+// as they are in the sample config
+const MinSecretLength = 32
+
+var SampleSecrets = []string{"change-me"}
+
+type LoginDatabaseConfig struct {
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+}
+
+type WhatsAppAccountConfig struct {
+	Name          string              `yaml:"name"`
+	LoginDatabase LoginDatabaseConfig `yaml:"login_database"`
+	PhoneNumber   string              `yaml:"phone_number"`
+	TargetChatID  int64               `yaml:"target_chat_id"`
+
+	// The target chat is shared with other accounts on purpose, their chats are told apart by
+	// their topics and commands sent outside of topics act on the first of them
+	SharedTargetChat bool `yaml:"shared_target_chat"`
+}
+
+// TelegramRouteConfig sends the WhatsApp chats matching all of its conditions to another
+// Telegram supergroup. Conditions which are not set match every chat.
+type TelegramRouteConfig struct {
+	TargetChatID int64    `yaml:"target_chat_id"`
+	Accounts     []string `yaml:"accounts"`
+	ChatType     string   `yaml:"chat_type"` // group, private
+	JIDs         []string `yaml:"jids"`
+	Labels       []string `yaml:"labels"`
+	NameRegex    string   `yaml:"name_regex"`
+
+	NameRegexp *regexp.Regexp `yaml:"-"`
+}
+
 type Config struct {
 	Path             string `yaml:"-"`
 	TimeZone         string `yaml:"time_zone"`
@@ -18,6 +69,29 @@ type Config struct {
 	DebugMode        bool   `yaml:"debug_mode"`
 	SilentDbLogs     bool   `yaml:"silent_db_logs"`
 
+	MediaTransfers struct {
+		TempDir       string `yaml:"temp_dir"`
+		MaxConcurrent int    `yaml:"max_concurrent"`
+		MaxInFlightMB int64  `yaml:"max_in_flight_mb"`
+	} `yaml:"media_transfers"`
+
+	MediaStore struct {
+		Enabled         bool   `yaml:"enabled"`
+		Directory       string `yaml:"directory"`
+		ListenAddress   string `yaml:"listen_address"`
+		PublicURL       string `yaml:"public_url"`
+		Secret          string `yaml:"secret"`
+		LinkExpiryHours int    `yaml:"link_expiry_hours"`
+	} `yaml:"media_store"`
+
+	VideoTranscoding struct {
+		Enabled         bool   `yaml:"enabled"`
+		WhatsAppLimitMB int64  `yaml:"whatsapp_limit_mb"`
+		Preset          string `yaml:"preset"`
+		MaxHeight       int    `yaml:"max_height"`
+		TimeoutMin      int    `yaml:"timeout_min"`
+	} `yaml:"video_transcoding"`
+
 	Telegram struct {
 		BotToken                string  `yaml:"bot_token"`
 		ApiUrl                  string  `yaml:"api_url"`
@@ -34,13 +108,34 @@ type Config struct {
 		SkipStartupMessage      bool    `yaml:"skip_startup_message"`
 		SpoilerViewOnce         bool    `yaml:"spoiler_as_viewonce"`
 		Reactions               bool    `yaml:"reactions"`
+
+		SizeLimitsMB map[string]int64 `yaml:"size_limits_mb"`
+
+		Routes []TelegramRouteConfig `yaml:"routes"`
+
+		OutboundQueue struct {
+			MaxAttempts       int `yaml:"max_attempts"`
+			RetryBaseDelaySec int `yaml:"retry_base_delay_sec"`
+			RetryMaxDelaySec  int `yaml:"retry_max_delay_sec"`
+		} `yaml:"outbound_queue"`
+
+		LinkPreviews struct {
+			Enabled       bool     `yaml:"enabled"`
+			DisabledChats []string `yaml:"disabled_chats"`
+			TimeoutSec    int      `yaml:"timeout_sec"`
+		} `yaml:"link_previews"`
+
+		DeletionSync struct {
+			Enabled       bool   `yaml:"enabled"`
+			ListenAddress string `yaml:"listen_address"`
+			Secret        string `yaml:"secret"`
+			LookbackHours int    `yaml:"lookback_hours"`
+		} `yaml:"deletion_sync"`
 	} `yaml:"telegram"`
 
 	WhatsApp struct {
-		LoginDatabase struct {
-			Type string `yaml:"type"`
-			URL  string `yaml:"url"`
-		} `yaml:"login_database"`
+		LoginDatabase   LoginDatabaseConfig     `yaml:"login_database"`
+		Accounts        []WhatsAppAccountConfig `yaml:"accounts"`
 		StickerMetadata struct {
 			PackName   string `yaml:"pack_name"`
 			AuthorName string `yaml:"author_name"`
@@ -65,6 +160,8 @@ type Config struct {
 		SkipUserAboutUpdates           bool     `yaml:"skip_user_about_updates"`
 		SkipChatDetails                bool     `yaml:"skip_chat_details"`
 		SkipRevokedMessage             bool     `yaml:"skip_revoked_message"`
+		SkipEditHistory                bool     `yaml:"skip_edit_history"`
+		RevokePolicy                   string   `yaml:"revoke_policy"`
 		WhatsmeowDebugMode             bool     `yaml:"whatsmeow_debug_mode"`
 		SendMyMessagesFromOtherDevices bool     `yaml:"send_my_messages_from_other_devices"`
 		CreateThreadForInfoUpdates     bool     `yaml:"create_thread_for_info_updates"`
@@ -73,6 +170,15 @@ type Config struct {
 		SkipQrCodeSend                 bool     `yaml:"skip_qr_code"`
 		SkipInitialPhotoSend           bool     `yaml:"skip_initial_photo_send"`
 		SkipInitialSync                bool     `yaml:"skip_initial_sync"`
+		UndecryptableTimeoutMin        int      `yaml:"undecryptable_timeout_min"`
+
+		RevokePolicyChats map[string]string `yaml:"revoke_policy_chats"`
+
+		InboundQueue struct {
+			MaxAttempts       int `yaml:"max_attempts"`
+			RetryBaseDelaySec int `yaml:"retry_base_delay_sec"`
+			RetryMaxDelaySec  int `yaml:"retry_max_delay_sec"`
+		} `yaml:"inbound_queue"`
 	} `yaml:"whatsapp"`
 
 	Database map[string]string `yaml:"database"`
@@ -101,6 +207,47 @@ func (cfg *Config) LoadConfig() error {
 		return fmt.Errorf("could not parse config file : %s", err)
 	}
 
+	err = cfg.setupWhatsAppAccounts()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.setupTelegramRoutes()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.checkRevokePolicies()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Telegram.DeletionSync.Enabled {
+		if err = checkSecret("telegram deletion_sync", cfg.Telegram.DeletionSync.Secret); err != nil {
+			return err
+		}
+	}
+
+	for mediaType := range cfg.Telegram.SizeLimitsMB {
+		if !slices.Contains(SizeLimitMediaTypes, mediaType) {
+			return fmt.Errorf("invalid media type '%s' in size_limits_mb, it should be one of %s", mediaType,
+				strings.Join(SizeLimitMediaTypes, ", "))
+		}
+	}
+
+	if cfg.MediaStore.Enabled {
+		if cfg.MediaStore.PublicURL == "" {
+			return fmt.Errorf("media_store needs a public_url")
+		}
+		if err = checkSecret("media_store", cfg.MediaStore.Secret); err != nil {
+			return err
+		}
+	}
+
+	if cfg.VideoTranscoding.Enabled && cfg.FfmpegExecutable == "" {
+		return fmt.Errorf("video_transcoding needs ffmpeg_executable to be set")
+	}
+
 	deprecatedOptions := GetDeprecatedConfigOptions(cfg)
 	if deprecatedOptions != nil {
 		fmt.Println("The following options have been deprecated/removed:")
@@ -112,8 +259,166 @@ func (cfg *Config) LoadConfig() error {
 	return nil
 }
 
+// checkSecret makes sure the secret is not the example from the sample config, nor short enough
+// to be guessed
+func checkSecret(option, secret string) error {
+	if secret == "" || slices.Contains(SampleSecrets, secret) {
+		return fmt.Errorf("%s needs a secret of its own, e.g. made with `openssl rand -hex 32`", option)
+	} else if len(secret) < MinSecretLength {
+		return fmt.Errorf("the secret of %s is too short, it should be at least %d characters long", option, MinSecretLength)
+	}
+	return nil
+}
+
+// setupWhatsAppAccounts fills in the accounts list from the top level options when it is
+// not set, so that a single account setup keeps working with older config files
+func (cfg *Config) setupWhatsAppAccounts() error {
+	if len(cfg.WhatsApp.Accounts) == 0 {
+		cfg.WhatsApp.Accounts = []WhatsAppAccountConfig{{Name: "default"}}
+	}
+
+	var (
+		names          = make(map[string]bool)
+		phoneNumbers   = make(map[string]string)
+		loginDatabases = make(map[LoginDatabaseConfig][]string)
+		targetChats    = make(map[int64][]*WhatsAppAccountConfig)
+	)
+	for i := range cfg.WhatsApp.Accounts {
+		account := &cfg.WhatsApp.Accounts[i]
+
+		if account.Name == "" {
+			return fmt.Errorf("whatsapp account number %d does not have a name", i+1)
+		} else if names[account.Name] {
+			return fmt.Errorf("whatsapp account name '%s' is used more than once", account.Name)
+		}
+		names[account.Name] = true
+
+		account.PhoneNumber = strings.TrimPrefix(account.PhoneNumber, "+")
+		if account.PhoneNumber != "" {
+			if otherName, found := phoneNumbers[account.PhoneNumber]; found {
+				return fmt.Errorf("whatsapp accounts '%s' and '%s' have the same phone_number", otherName, account.Name)
+			}
+			phoneNumbers[account.PhoneNumber] = account.Name
+		}
+
+		if account.LoginDatabase.Type == "" {
+			account.LoginDatabase = cfg.WhatsApp.LoginDatabase
+		}
+		loginDatabases[account.LoginDatabase] = append(loginDatabases[account.LoginDatabase], account.Name)
+
+		if account.TargetChatID == 0 {
+			account.TargetChatID = cfg.Telegram.TargetChatID
+		}
+		targetChats[account.TargetChatID] = append(targetChats[account.TargetChatID], account)
+	}
+
+	// Without a phone number the first login in the database is used, which would be the same
+	// for all of the accounts sharing it
+	for _, accountNames := range loginDatabases {
+		if len(accountNames) < 2 {
+			continue
+		}
+		for _, accountName := range accountNames {
+			if !slices.ContainsFunc(cfg.WhatsApp.Accounts, func(account WhatsAppAccountConfig) bool {
+				return account.Name == accountName && account.PhoneNumber != ""
+			}) {
+				return fmt.Errorf("whatsapp account '%s' shares its login_database with other accounts, so it needs a phone_number", accountName)
+			}
+		}
+	}
+
+	for targetChatID, accounts := range targetChats {
+		if len(accounts) < 2 {
+			continue
+		}
+		for _, account := range accounts {
+			if !account.SharedTargetChat {
+				return fmt.Errorf("whatsapp account '%s' has the same target_chat_id (%d) as another account, set a target_chat_id "+
+					"for each account or set shared_target_chat on all of them", account.Name, targetChatID)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (cfg *Config) setupTelegramRoutes() error {
+	for i := range cfg.Telegram.Routes {
+		route := &cfg.Telegram.Routes[i]
+
+		if route.TargetChatID == 0 {
+			return fmt.Errorf("telegram route number %d does not have a target_chat_id", i+1)
+		}
+
+		if route.ChatType != "" && route.ChatType != "group" && route.ChatType != "private" {
+			return fmt.Errorf("telegram route number %d has invalid chat_type '%s', it should be group or private", i+1, route.ChatType)
+		}
+
+		for _, accountName := range route.Accounts {
+			if !slices.ContainsFunc(cfg.WhatsApp.Accounts, func(account WhatsAppAccountConfig) bool {
+				return account.Name == accountName
+			}) {
+				return fmt.Errorf("telegram route number %d refers to unknown whatsapp account '%s'", i+1, accountName)
+			}
+		}
+
+		if route.NameRegex != "" {
+			nameRegexp, err := regexp.Compile(route.NameRegex)
+			if err != nil {
+				return fmt.Errorf("telegram route number %d has invalid name_regex : %s", i+1, err)
+			}
+			route.NameRegexp = nameRegexp
+		}
+	}
+
+	return nil
+}
+
+// checkRevokePolicies makes sure that only the known policies are used for revoked messages
+func (cfg *Config) checkRevokePolicies() error {
+	if !slices.Contains(RevokePolicies, cfg.WhatsApp.RevokePolicy) {
+		return fmt.Errorf("invalid revoke_policy '%s', it should be one of %s", cfg.WhatsApp.RevokePolicy,
+			strings.Join(RevokePolicies, ", "))
+	}
+
+	for chat, policy := range cfg.WhatsApp.RevokePolicyChats {
+		if !slices.Contains(RevokePolicies, policy) {
+			return fmt.Errorf("invalid revoke policy '%s' for chat '%s', it should be one of %s", policy, chat,
+				strings.Join(RevokePolicies, ", "))
+		}
+	}
+
+	return nil
+}
+
+// TgSizeLimit returns the size in bytes up to which files of the media type are sent to Telegram,
+// by default the limits of the Bot API for uploads
+func (cfg *Config) TgSizeLimit(mediaType string) uint64 {
+	if limitMB, found := cfg.Telegram.SizeLimitsMB[mediaType]; found {
+		return uint64(limitMB) * 1024 * 1024
+	}
+
+	switch {
+	case mediaType == "image":
+		return 10 * 1024 * 1024
+	case cfg.Telegram.SelfHostedAPI:
+		return 2000 * 1024 * 1024
+	}
+	return 50 * 1024 * 1024
+}
+
 func (cfg *Config) SetDefaults() {
 	cfg.TimeZone = "UTC"
+	cfg.MediaTransfers.TempDir = "downloads/transfers"
+	cfg.MediaTransfers.MaxConcurrent = 10
+	cfg.MediaTransfers.MaxInFlightMB = 1024
+	cfg.MediaStore.Directory = "downloads/store"
+	cfg.MediaStore.ListenAddress = ":8090"
+	cfg.MediaStore.LinkExpiryHours = 72
+	cfg.VideoTranscoding.WhatsAppLimitMB = 64
+	cfg.VideoTranscoding.Preset = "veryfast"
+	cfg.VideoTranscoding.MaxHeight = 720
+	cfg.VideoTranscoding.TimeoutMin = 30
 
 	cfg.WhatsApp.SessionName = "coco-watg"
 	cfg.WhatsApp.BrowserName = "FIREFOX"
@@ -121,7 +426,18 @@ func (cfg *Config) SetDefaults() {
 	cfg.WhatsApp.LoginDatabase.URL = "file:coco_wawebstore.db?_foreign_keys=on"
 	cfg.WhatsApp.StickerMetadata.PackName = "CocoWaTgBridge"
 	cfg.WhatsApp.StickerMetadata.AuthorName = "CocoWaTgBridge"
+	cfg.WhatsApp.RevokePolicy = RevokePolicyAnnotate
+	cfg.WhatsApp.UndecryptableTimeoutMin = 60
+	cfg.WhatsApp.InboundQueue.MaxAttempts = 10
+	cfg.WhatsApp.InboundQueue.RetryBaseDelaySec = 5
+	cfg.WhatsApp.InboundQueue.RetryMaxDelaySec = 600
 
 	cfg.Telegram.ApiUrl = gotgbot.DefaultAPIURL
 	cfg.Telegram.ConfirmationType = "emoji"
+	cfg.Telegram.OutboundQueue.MaxAttempts = 10
+	cfg.Telegram.OutboundQueue.RetryBaseDelaySec = 5
+	cfg.Telegram.OutboundQueue.RetryMaxDelaySec = 600
+	cfg.Telegram.DeletionSync.ListenAddress = "127.0.0.1:8091"
+	cfg.Telegram.DeletionSync.LookbackHours = 48
+	cfg.Telegram.LinkPreviews.TimeoutSec = 10
 }
@@ -15,6 +15,14 @@ import (
 //go:embed version.txt
 var WATGBRIDGE_VERSION string
 
+// WhatsAppAccount is one WhatsApp number bridged by this process, along with the
+// Telegram supergroup its chats are bridged to
+type WhatsAppAccount struct {
+	Name         string
+	Client       *whatsmeow.Client
+	TargetChatID int64
+}
+
 type state struct {
 	Config   *Config
 	Database *gorm.DB
@@ -25,7 +33,7 @@ type state struct {
 	TelegramUpdater    *ext.Updater
 	TelegramCommands   []gotgbot.BotCommand
 
-	WhatsAppClient *whatsmeow.Client
+	WhatsAppAccounts []*WhatsAppAccount
 
 	Modules []string
 
@@ -35,6 +35,7 @@ func NewTelegramClient() error {
 	}
 	state.State.TelegramBot = bot
 
+	bot.UseMiddleware(middlewares.CaptureRequest)
 	bot.UseMiddleware(middlewares.AutoHandleRateLimit)
 	bot.UseMiddleware(middlewares.ParseAsHTML)
 	bot.UseMiddleware(middlewares.DisableWebPagePreview)
@@ -63,7 +64,8 @@ func NewTelegramClient() error {
 	err = updater.StartPolling(bot, &ext.PollingOpts{
 		DropPendingUpdates: true,
 		GetUpdatesOpts: &gotgbot.GetUpdatesOpts{
-			Timeout: 9,
+			Timeout:        9,
+			AllowedUpdates: []string{"message", "edited_message", "callback_query", "message_reaction"},
 			RequestOpts: &gotgbot.RequestOpts{
 				Timeout: 10 * time.Second,
 			},
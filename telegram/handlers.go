@@ -3,10 +3,13 @@ package telegram
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"html"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,17 +34,26 @@ type waTgBridgeCommand struct {
 var commands = []waTgBridgeCommand{}
 
 func AddTelegramHandlers() {
-	var (
-		cfg        = state.State.Config
-		dispatcher = state.State.TelegramDispatcher
-	)
+	dispatcher := state.State.TelegramDispatcher
 
 	dispatcher.AddHandlerToGroup(handlers.NewMessage(
 		func(msg *gotgbot.Message) bool {
-			return msg.Chat.Id == cfg.Telegram.TargetChatID
+			return utils.TgChatIsBridged(msg.Chat.Id)
 		}, BridgeTelegramToWhatsAppHandler,
 	), DispatcherForwardHandlerGroup)
 
+	dispatcher.AddHandlerToGroup(handlers.NewMessage(
+		func(msg *gotgbot.Message) bool {
+			return msg.EditDate != 0 && utils.TgChatIsBridged(msg.Chat.Id)
+		}, BridgeTelegramEditToWhatsAppHandler,
+	).SetAllowEdited(true), DispatcherForwardHandlerGroup)
+
+	dispatcher.AddHandlerToGroup(handlers.NewReaction(
+		func(reaction *gotgbot.MessageReactionUpdated) bool {
+			return utils.TgChatIsBridged(reaction.Chat.Id)
+		}, BridgeTelegramReactionToWhatsAppHandler,
+	), DispatcherForwardHandlerGroup)
+
 	commands = append(commands,
 		waTgBridgeCommand{
 			handlers.NewCommand("start", StartCommandHandler),
@@ -59,6 +71,10 @@ func AddTelegramHandlers() {
 			handlers.NewCommand("revoke", RevokeCommandHandler),
 			"Revoke a message from WhatsApp",
 		},
+		waTgBridgeCommand{
+			handlers.NewCommand("pollresults", PollResultsCommandHandler),
+			"Show the votes of a WhatsApp poll",
+		},
 		waTgBridgeCommand{
 			handlers.NewCommand("synccontacts", SyncContactsHandler),
 			"Try to sync the contacts list from WhatsApp",
@@ -111,6 +127,18 @@ func AddTelegramHandlers() {
 			handlers.NewCommand("unblock", UnblockCommandHandler),
 			"Unblock a user in WhatsApp",
 		},
+		waTgBridgeCommand{
+			handlers.NewCommand("failed", FailedDeliveriesHandler),
+			"List and replay WhatsApp messages which could not be delivered to Telegram",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("unsent", UnsentMessagesHandler),
+			"List and retry Telegram messages which could not be sent to WhatsApp",
+		},
+		waTgBridgeCommand{
+			handlers.NewCommand("decryptfailures", DecryptFailuresHandler),
+			"Show how many WhatsApp messages could not be decrypted in each chat",
+		},
 	)
 
 	for _, command := range commands {
@@ -129,6 +157,88 @@ func AddTelegramHandlers() {
 		func(cq *gotgbot.CallbackQuery) bool {
 			return strings.HasPrefix(cq.Data, "revoke")
 		}, RevokeCallbackHandler), DispatcherCallbackHandlerGroup)
+
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "edithistory")
+		}, EditHistoryCallbackHandler), DispatcherCallbackHandlerGroup)
+
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "wapoll_")
+		}, WaPollVoteCallbackHandler), DispatcherCallbackHandlerGroup)
+
+	dispatcher.AddHandlerToGroup(handlers.NewCallback(
+		func(cq *gotgbot.CallbackQuery) bool {
+			return strings.HasPrefix(cq.Data, "outmention_")
+		}, OutboundMentionCallbackHandler), DispatcherCallbackHandlerGroup)
+}
+
+func BridgeTelegramEditToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	var (
+		account   = utils.WaAccountByContext(c)
+		editedMsg = c.EffectiveMessage
+	)
+
+	waMsgId, participantId, waChatId, err := database.MsgIdGetWaFromTg(c.EffectiveChat.Id, editedMsg.MessageId, editedMsg.MessageThreadId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retreive a pair from database", err)
+	}
+
+	if waMsgId == "" {
+		// The message may still be waiting in the outbound queue
+		updated, err := utils.OutboundUpdateQueued(editedMsg)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to update the queued message", err)
+		}
+		if updated {
+			utils.SendEditConfirmation(b, c, state.State.Config, editedMsg)
+		}
+		return nil
+	}
+
+	return utils.TgSendEditToWhatsApp(b, c, account, editedMsg, waMsgId, participantId, waChatId)
+}
+
+func BridgeTelegramReactionToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	reaction := c.MessageReaction
+
+	bridgePair, err := database.MsgIdGetPairFromTg(reaction.Chat.Id, reaction.MessageId)
+	if err != nil || bridgePair.ID == "" {
+		return err
+	}
+
+	account := utils.WaAccountByName(bridgePair.Account)
+	if account == nil {
+		return nil
+	}
+
+	// WhatsApp allows one reaction per user, custom emojis cannot be sent at all
+	var emoji string
+	for _, reactionType := range reaction.NewReaction {
+		if reactionEmoji, ok := reactionType.(gotgbot.ReactionTypeEmoji); ok {
+			emoji = reactionEmoji.Emoji
+			break
+		}
+	}
+	if emoji == "" && len(reaction.NewReaction) > 0 {
+		return nil
+	}
+
+	waChatJID, _ := utils.WaParseJID(bridgePair.WaChatId)
+	err = utils.WaSendReaction(account, waChatJID, bridgePair.ID, bridgePair.ParticipantId, emoji)
+	if err != nil {
+		utils.TgSendErrorById(b, reaction.Chat.Id, bridgePair.TgThreadId, "Failed to send reaction to WhatsApp", err)
+	}
+	return nil
 }
 
 func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
@@ -143,7 +253,8 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	var (
-		waClient     = state.State.WhatsAppClient
+		account      = utils.WaAccountByContext(c)
+		waClient     = account.Client
 		msgToForward = c.EffectiveMessage
 		msgToReplyTo = c.EffectiveMessage.ReplyToMessage
 	)
@@ -180,9 +291,9 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 		waChatID = participantID
 
 		waChatJID, _ := utils.WaParseJID(participantID)
-		contactName := utils.WaGetContactName(waChatJID)
+		contactName := utils.WaGetContactName(account, waChatJID)
 
-		contactThreadID, _, err := utils.TgGetOrMakeThreadFromWa(waChatJID, c.EffectiveChat.Id, contactName)
+		contactThreadID, _, err := utils.TgGetOrMakeThreadFromWa(account, waChatJID, c.EffectiveChat.Id, contactName)
 		if err != nil {
 			return utils.TgReplyWithErrorByContext(b, c, "Failed to get or create a thread for the contact", err)
 		}
@@ -199,7 +310,7 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 		msgCopy.MessageThreadId = forwardedMsg.MessageThreadId
 
 		finalWaChatJID, _ := utils.WaParseJID(waChatID)
-		return utils.TgSendToWhatsApp(b, c, &msgCopy, msgToReplyTo, finalWaChatJID, participantID, stanzaID, true)
+		return utils.TgQueueToWhatsApp(b, c, &msgCopy, msgToReplyTo, finalWaChatJID, participantID, stanzaID, true)
 
 	} else if participantID != "" {
 		participant, _ := utils.WaParseJID(participantID)
@@ -208,7 +319,7 @@ func BridgeTelegramToWhatsAppHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	waChatJID, _ := utils.WaParseJID(waChatID)
 
-	return utils.TgSendToWhatsApp(b, c, msgToForward, msgToReplyTo, waChatJID, participantID, stanzaID, msgToReplyTo != nil && msgToReplyTo.ForumTopicCreated == nil)
+	return utils.TgQueueToWhatsApp(b, c, msgToForward, msgToReplyTo, waChatJID, participantID, stanzaID, msgToReplyTo != nil && msgToReplyTo.ForumTopicCreated == nil)
 }
 
 func StartCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
@@ -247,7 +358,7 @@ func GetWhatsAppGroupsHandler(b *gotgbot.Bot, c *ext.Context) error {
 		return nil
 	}
 
-	waClient := state.State.WhatsAppClient
+	waClient := utils.WaAccountByContext(c).Client
 
 	waGroups, err := waClient.GetJoinedGroups(context.Background())
 	if err != nil {
@@ -323,7 +434,7 @@ func SyncContactsHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	utils.TgReplyTextByContext(b, c, "Starting syncing contacts... may take some time", nil, false)
 
-	waClient := state.State.WhatsAppClient
+	waClient := utils.WaAccountByContext(c).Client
 
 	err := waClient.FetchAppState(context.Background(), appstate.WAPatchCriticalUnblockLow, false, false)
 	if err != nil {
@@ -358,7 +469,7 @@ func RestartWhatsAppConnectionHandler(b *gotgbot.Bot, c *ext.Context) error {
 		return nil
 	}
 
-	waClient := state.State.WhatsAppClient
+	waClient := utils.WaAccountByContext(c).Client
 
 	waClient.Disconnect()
 	err := waClient.Connect()
@@ -384,7 +495,7 @@ func JoinInviteLinkHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 	inviteLink := args[1]
 
-	waClient := state.State.WhatsAppClient
+	waClient := utils.WaAccountByContext(c).Client
 
 	groupID, err := waClient.JoinGroupWithLink(context.Background(), inviteLink)
 	if err != nil {
@@ -415,19 +526,18 @@ func SetTargetGroupChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	var (
-		cfg      = state.State.Config
-		groupID  = args[1]
-		waClient = state.State.WhatsAppClient
+		account = utils.WaAccountByContext(c)
+		groupID = args[1]
 	)
 
 	groupJID, _ := utils.WaParseJID(groupID)
-	groupInfo, err := waClient.GetGroupInfo(context.Background(), groupJID)
+	groupInfo, err := account.Client.GetGroupInfo(context.Background(), groupJID)
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "Failed to get group info", err)
 	}
 	groupJID = groupInfo.JID
 
-	_, threadFound, err := database.ChatThreadGetTgFromWa(groupJID.String(), cfg.Telegram.TargetChatID)
+	_, threadFound, err := database.ChatThreadGetTgFromWa(account.Name, groupJID.String(), c.EffectiveChat.Id)
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "Failed to check database for existing mapping", err)
 	} else if threadFound {
@@ -435,7 +545,7 @@ func SetTargetGroupChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 		return err
 	}
 
-	err = database.ChatThreadAddNewPair(groupJID.String(), cfg.Telegram.TargetChatID, c.EffectiveMessage.MessageThreadId)
+	err = database.ChatThreadAddNewPair(account.Name, groupJID.String(), c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "Failed to add the mapping in database. Unsuccessful", err)
 	}
@@ -501,7 +611,7 @@ func handleBlockUnblockUser(b *gotgbot.Bot, c *ext.Context, action events.Blockl
 		return err
 	}
 	jid, _ := utils.WaParseJID(waChatId)
-	_, err = state.State.WhatsAppClient.UpdateBlocklist(context.Background(), jid, action)
+	_, err = utils.WaAccountByContext(c).Client.UpdateBlocklist(context.Background(), jid, action)
 	if err != nil {
 		err = utils.TgReplyWithErrorByContext(b, c, "Failed to change the blocklist status", err)
 		return err
@@ -523,6 +633,230 @@ func UnblockCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 	return handleBlockUnblockUser(b, c, events.BlocklistChangeActionUnblock)
 }
 
+func FailedDeliveriesHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage : <code>" + html.EscapeString("/failed [replay|drop <id|all>]") + "</code>\n"
+	usageString += "Example : <code>/failed replay 12</code>"
+
+	deadMsgs, err := database.InboundMsgGetByState("dead")
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve the failed deliveries", err)
+	}
+
+	args := c.Args()
+	if len(args) <= 1 {
+		if len(deadMsgs) == 0 {
+			_, err = utils.TgReplyTextByContext(b, c, "There are no failed deliveries", nil, false)
+			return err
+		}
+
+		outputString := fmt.Sprintf("%v messages could not be delivered to Telegram:\n\n", len(deadMsgs))
+		for _, deadMsg := range deadMsgs {
+			outputString += fmt.Sprintf("<code>%v</code>. %s in <code>%s</code> (%v attempts, %s)\n<i>%s</i>\n\n",
+				deadMsg.ID, html.EscapeString(deadMsg.Method), html.EscapeString(deadMsg.WaChatId),
+				deadMsg.Attempts, deadMsg.CreatedAt.Format(time.DateTime), html.EscapeString(deadMsg.LastError))
+
+			if len(outputString) >= 1800 {
+				utils.TgReplyTextByContext(b, c, outputString, nil, false)
+				time.Sleep(500 * time.Millisecond)
+				outputString = ""
+			}
+		}
+
+		if len(outputString) > 0 {
+			_, err = utils.TgReplyTextByContext(b, c, outputString, nil, false)
+			return err
+		}
+		return nil
+	}
+
+	if len(args) <= 2 || (args[1] != "replay" && args[1] != "drop") {
+		_, err = utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	var ids []uint
+	if args[2] == "all" {
+		for _, deadMsg := range deadMsgs {
+			ids = append(ids, deadMsg.ID)
+		}
+	} else {
+		id, err := strconv.ParseUint(args[2], 10, 0)
+		if err != nil {
+			_, err = utils.TgReplyTextByContext(b, c, usageString, nil, false)
+			return err
+		}
+		ids = append(ids, uint(id))
+	}
+
+	for _, id := range ids {
+		if args[1] == "replay" {
+			err = utils.InboundReplay(id)
+		} else {
+			err = utils.InboundDiscard(id)
+		}
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, fmt.Sprintf("Failed to %s delivery %v", args[1], id), err)
+		}
+	}
+
+	remainingMsgs, _ := database.InboundMsgGetByState("dead")
+	_, err = utils.TgReplyTextByContext(b, c,
+		fmt.Sprintf("Done, %v failed deliveries remaining", len(remainingMsgs)), nil, false)
+	return err
+}
+
+func UnsentMessagesHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage : <code>" + html.EscapeString("/unsent [retry|drop <id|all>]") + "</code>\n"
+	usageString += "Example : <code>/unsent retry 12</code>"
+
+	failedMsgs, err := database.OutboundMsgGetFailed()
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve the unsent messages", err)
+	}
+
+	args := c.Args()
+	if len(args) <= 1 {
+		if len(failedMsgs) == 0 {
+			_, err = utils.TgReplyTextByContext(b, c, "There are no unsent messages", nil, false)
+			return err
+		}
+
+		outputString := fmt.Sprintf("%v messages could not be sent to WhatsApp:\n\n", len(failedMsgs))
+		for _, failedMsg := range failedMsgs {
+			outputString += fmt.Sprintf("<code>%v</code>. Message %v to <code>%s</code> (%v attempts, %s)\n<i>%s</i>\n\n",
+				failedMsg.ID, failedMsg.TgMsgId, html.EscapeString(failedMsg.WaChatId),
+				failedMsg.Attempts, failedMsg.CreatedAt.Format(time.DateTime), html.EscapeString(failedMsg.LastError))
+
+			if len(outputString) >= 1800 {
+				utils.TgReplyTextByContext(b, c, outputString, nil, false)
+				time.Sleep(500 * time.Millisecond)
+				outputString = ""
+			}
+		}
+
+		if len(outputString) > 0 {
+			_, err = utils.TgReplyTextByContext(b, c, outputString, nil, false)
+			return err
+		}
+		return nil
+	}
+
+	if len(args) <= 2 || (args[1] != "retry" && args[1] != "drop") {
+		_, err = utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	var ids []uint
+	if args[2] == "all" {
+		for _, failedMsg := range failedMsgs {
+			ids = append(ids, failedMsg.ID)
+		}
+	} else {
+		id, err := strconv.ParseUint(args[2], 10, 0)
+		if err != nil {
+			_, err = utils.TgReplyTextByContext(b, c, usageString, nil, false)
+			return err
+		}
+		ids = append(ids, uint(id))
+	}
+
+	for _, id := range ids {
+		if args[1] == "retry" {
+			err = utils.OutboundRetry(id)
+		} else {
+			err = utils.OutboundDiscard(id)
+		}
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, fmt.Sprintf("Failed to %s unsent message %v", args[1], id), err)
+		}
+	}
+
+	remainingMsgs, _ := database.OutboundMsgGetFailed()
+	_, err = utils.TgReplyTextByContext(b, c,
+		fmt.Sprintf("Done, %v unsent messages remaining", len(remainingMsgs)), nil, false)
+	return err
+}
+
+func DecryptFailuresHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	counts, err := database.WaUndecryptableCountFailed()
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve the decryption failures", err)
+	}
+
+	// In a topic only the chat bridged to it is shown
+	if c.EffectiveMessage.IsTopicMessage {
+		waChatId, err := database.ChatThreadGetWaFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err != nil {
+			return utils.TgReplyWithErrorByContext(b, c, "Failed to find the WhatsApp chat of the topic", err)
+		}
+		if waChatId != "" {
+			account := utils.WaAccountByContext(c)
+			_, err = utils.TgReplyTextByContext(b, c,
+				fmt.Sprintf("%v messages of this chat could not be decrypted", counts[account.Name+"|"+waChatId]), nil, false)
+			return err
+		}
+	}
+
+	if len(counts) == 0 {
+		_, err = utils.TgReplyTextByContext(b, c, "No messages have failed to decrypt", nil, false)
+		return err
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return int(counts[b] - counts[a])
+	})
+
+	outputString := "Messages which could not be decrypted:\n\n"
+	for _, key := range keys {
+		accountName, waChatId, _ := strings.Cut(key, "|")
+
+		chatName := waChatId
+		if account := utils.WaAccountByName(accountName); account != nil {
+			if waChatJid, err := waTypes.ParseJID(waChatId); err == nil {
+				if waChatJid.Server == waTypes.GroupServer {
+					chatName = utils.WaGetGroupName(account, waChatJid)
+				} else {
+					chatName = utils.WaGetContactName(account, waChatJid)
+				}
+			}
+		}
+		if len(state.State.WhatsAppAccounts) > 1 {
+			chatName += " [" + accountName + "]"
+		}
+
+		outputString += fmt.Sprintf("%s (<code>%s</code>): %v\n",
+			html.EscapeString(chatName), html.EscapeString(waChatId), counts[key])
+
+		if len(outputString) >= 1800 {
+			utils.TgReplyTextByContext(b, c, outputString, nil, false)
+			time.Sleep(500 * time.Millisecond)
+			outputString = ""
+		}
+	}
+
+	if len(outputString) > 0 {
+		_, err = utils.TgReplyTextByContext(b, c, outputString, nil, false)
+		return err
+	}
+	return nil
+}
+
 func SetTargetPrivateChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 	if !utils.TgUpdateIsAuthorized(b, c) {
 		return nil
@@ -542,13 +876,13 @@ func SetTargetPrivateChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	var (
-		cfg     = state.State.Config
+		account = utils.WaAccountByContext(c)
 		groupID = args[1]
 	)
 
 	userJID, _ := utils.WaParseJID(groupID)
 
-	_, threadFound, err := database.ChatThreadGetTgFromWa(userJID.String(), cfg.Telegram.TargetChatID)
+	_, threadFound, err := database.ChatThreadGetTgFromWa(account.Name, userJID.String(), c.EffectiveChat.Id)
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "Failed to check database for existing mapping", err)
 	} else if threadFound {
@@ -556,7 +890,7 @@ func SetTargetPrivateChatHandler(b *gotgbot.Bot, c *ext.Context) error {
 		return err
 	}
 
-	err = database.ChatThreadAddNewPair(userJID.String(), cfg.Telegram.TargetChatID, c.EffectiveMessage.MessageThreadId)
+	err = database.ChatThreadAddNewPair(account.Name, userJID.String(), c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
 	if err != nil {
 		return utils.TgReplyWithErrorByContext(b, c, "Failed to add the mapping in database. Unsuccessful", err)
 	}
@@ -580,7 +914,7 @@ func GetProfilePictureHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	var (
-		waClient = state.State.WhatsAppClient
+		waClient = utils.WaAccountByContext(c).Client
 		userID   = args[1]
 	)
 
@@ -630,20 +964,21 @@ func SyncTopicNamesHandler(b *gotgbot.Bot, c *ext.Context) error {
 
 	for _, pair := range chatThreadPairs {
 		var (
+			account    = utils.WaAccountByName(pair.Account)
 			waChatId   = pair.ID
 			tgThreadId = pair.TgThreadId
 		)
 
-		if waChatId == "status@broadcast" || waChatId == "calls" || waChatId == "mentions" {
+		if account == nil || waChatId == "status@broadcast" || waChatId == "calls" || waChatId == "mentions" {
 			continue
 		}
 		waChatJid, _ := utils.WaParseJID(waChatId)
 
 		var newName string
 		if waChatJid.Server == waTypes.GroupServer {
-			newName = utils.WaGetGroupName(waChatJid)
+			newName = utils.WaGetGroupName(account, waChatJid)
 		} else {
-			newName = utils.WaGetContactName(waChatJid)
+			newName = utils.WaGetContactName(account, waChatJid)
 		}
 
 		b.EditForumTopic(c.EffectiveChat.Id, tgThreadId, &gotgbot.EditForumTopicOpts{
@@ -717,7 +1052,7 @@ func RevokeCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 	}
 
 	var (
-		waClient    = state.State.WhatsAppClient
+		waClient    = utils.WaAccountByContext(c).Client
 		msgToRevoke = c.EffectiveMessage.ReplyToMessage
 		chatId      = c.EffectiveChat.Id
 	)
@@ -738,13 +1073,51 @@ func RevokeCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
 	return err
 }
 
+func PollResultsCommandHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	usageString := "Usage : Reply to a poll, <code>/pollresults</code>"
+
+	if c.EffectiveMessage.ReplyToMessage == nil || c.EffectiveMessage.ReplyToMessage.ForumTopicCreated != nil {
+		_, err := utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	bridgePair, err := database.MsgIdGetPairFromTg(c.EffectiveChat.Id, c.EffectiveMessage.ReplyToMessage.MessageId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve WhatsApp side IDs", err)
+	}
+
+	account := utils.WaAccountByName(bridgePair.Account)
+	poll, found, err := database.WaPollGet(bridgePair.Account, bridgePair.ID, bridgePair.WaChatId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve the poll", err)
+	} else if account == nil || !found {
+		_, err = utils.TgReplyTextByContext(b, c, usageString, nil, false)
+		return err
+	}
+
+	votes, err := database.WaPollVoteGetAll(account.Name, poll.WaMsgId, poll.WaChatId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to retrieve the votes of the poll", err)
+	}
+
+	// The header only belongs on the bridged message itself
+	poll.Header = ""
+	results, _ := utils.TgRenderWaPoll(account, &poll, votes)
+	_, err = utils.TgReplyTextByContext(b, c, results, nil, false)
+	return err
+}
+
 func RevokeCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
 	if !utils.TgUpdateIsAuthorized(b, c) {
 		return nil
 	}
 
 	var (
-		waClient = state.State.WhatsAppClient
+		waClient = utils.WaAccountByContext(c).Client
 		cq       = c.CallbackQuery
 		data     = strings.Split(cq.Data, "_")
 	)
@@ -829,3 +1202,207 @@ func RevokeCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
 		return err
 	}
 }
+
+func EditHistoryCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	var (
+		account = utils.WaAccountByContext(c)
+		cq      = c.CallbackQuery
+		msg     = c.EffectiveMessage
+	)
+
+	waMsgId, _, waChatId, err := database.MsgIdGetWaFromTg(c.EffectiveChat.Id, msg.MessageId, msg.MessageThreadId)
+	if err != nil || waMsgId == "" {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Could not find the WhatsApp message of this message",
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	versions, err := database.MsgVersionGetAll(account.Name, waMsgId, waChatId)
+	if err != nil || len(versions) == 0 {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "No edit history was kept for this message",
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	var (
+		latest         = versions[len(versions)-1]
+		showingHistory = cq.Data == "edithistory_show"
+		rendered       = latest.Rendered
+		maxLength      = 4096
+	)
+	if latest.IsCaption {
+		maxLength = 1024
+	}
+	if showingHistory {
+		rendered = utils.TgRenderEditHistory(versions, maxLength)
+	}
+
+	if latest.IsCaption {
+		_, _, err = b.EditMessageCaption(&gotgbot.EditMessageCaptionOpts{
+			ChatId:      c.EffectiveChat.Id,
+			MessageId:   msg.MessageId,
+			Caption:     rendered,
+			ReplyMarkup: utils.TgMakeEditHistoryKeyboard(showingHistory),
+		})
+	} else {
+		_, _, err = b.EditMessageText(rendered, &gotgbot.EditMessageTextOpts{
+			ChatId:      c.EffectiveChat.Id,
+			MessageId:   msg.MessageId,
+			ReplyMarkup: utils.TgMakeEditHistoryKeyboard(showingHistory),
+		})
+	}
+	if err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Failed to show the edit history : " + err.Error(),
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	_, err = cq.Answer(b, nil)
+	return err
+}
+
+func WaPollVoteCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	var (
+		cq  = c.CallbackQuery
+		msg = c.EffectiveMessage
+	)
+
+	bridgePair, err := database.MsgIdGetPairFromTg(c.EffectiveChat.Id, msg.MessageId)
+	if err != nil || bridgePair.ID == "" {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Could not find the WhatsApp poll of this message",
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	account := utils.WaAccountByName(bridgePair.Account)
+	poll, found, err := database.WaPollGet(bridgePair.Account, bridgePair.ID, bridgePair.WaChatId)
+	if account == nil || err != nil || !found {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Could not find the WhatsApp poll of this message",
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	options := utils.WaPollOptions(&poll)
+	optionNum, err := strconv.Atoi(strings.TrimPrefix(cq.Data, "wapoll_"))
+	if err != nil || optionNum < 0 || optionNum >= len(options) {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "This option does not exist anymore",
+			ShowAlert: true,
+		})
+		return err
+	}
+	option := options[optionNum]
+
+	// Clicking an option selects it, or takes it back if it was selected
+	var ownVote []string
+	votes, err := database.WaPollVoteGetAll(account.Name, poll.WaMsgId, poll.WaChatId)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to get the votes of the poll", err)
+	}
+	for _, vote := range votes {
+		if vote.VoterId == utils.WaPollOwnVoterId(account) {
+			json.Unmarshal([]byte(vote.Options), &ownVote)
+		}
+	}
+
+	if slices.Contains(ownVote, option) {
+		ownVote = slices.DeleteFunc(ownVote, func(selected string) bool { return selected == option })
+	} else if poll.SelectableCount == 1 {
+		ownVote = []string{option}
+	} else if poll.SelectableCount != 0 && len(ownVote) >= int(poll.SelectableCount) {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      fmt.Sprintf("Only %d options can be selected, take one back first", poll.SelectableCount),
+			ShowAlert: true,
+		})
+		return err
+	} else {
+		ownVote = append(ownVote, option)
+	}
+
+	if err = utils.WaSendPollVote(account, &poll, ownVote); err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "Failed to send the vote to WhatsApp : " + err.Error(),
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	if ownVote == nil {
+		ownVote = []string{}
+	}
+	ownVoteJson, _ := json.Marshal(ownVote)
+	err = database.WaPollVoteSet(account.Name, poll.WaMsgId, poll.WaChatId, utils.WaPollOwnVoterId(account), string(ownVoteJson))
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to save the vote to database", err)
+	}
+
+	if err = utils.TgUpdateWaPoll(account, &poll); err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to update the votes of the poll", err)
+	}
+
+	answerText := "Voted"
+	if len(ownVote) == 0 {
+		answerText = "Vote taken back"
+	}
+	_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+		Text: answerText,
+	})
+	return err
+}
+
+func OutboundMentionCallbackHandler(b *gotgbot.Bot, c *ext.Context) error {
+	if !utils.TgUpdateIsAuthorized(b, c) {
+		return nil
+	}
+
+	cq := c.CallbackQuery
+
+	// outmention_<id>_<jid>, where an empty JID means no one
+	idString, jid, _ := strings.Cut(strings.TrimPrefix(cq.Data, "outmention_"), "_")
+	id, err := strconv.ParseUint(idString, 10, 64)
+	if err != nil {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "This button is not valid",
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	picked, err := utils.OutboundPickMention(uint(id), jid)
+	if err != nil {
+		return utils.TgReplyWithErrorByContext(b, c, "Failed to save the picked member", err)
+	} else if !picked {
+		_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text:      "This message is not waiting for a mention anymore",
+			ShowAlert: true,
+		})
+		return err
+	}
+
+	answerText := "Sending the name as text"
+	if jid != "" {
+		answerText = "Mentioning them"
+	}
+	_, err = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+		Text: answerText,
+	})
+	return err
+}
@@ -0,0 +1,102 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// CapturedFile is a file of a captured request, either its contents or the path of the file
+// on disk for readers which are backed by one
+type CapturedFile struct {
+	Name string
+	Data []byte `json:",omitempty"`
+	Path string `json:",omitempty"`
+}
+
+// fileBackedReader is implemented by readers over files on disk, which are captured by their
+// path instead of being read into memory
+type fileBackedReader interface {
+	io.ReadSeeker
+	FilePath() string
+}
+
+// CapturedRequest holds the final parameters of a request, so that it can be sent again later
+type CapturedRequest struct {
+	Method string
+	Params map[string]string
+	Files  map[string]CapturedFile
+
+	// Hold is asked before the request is sent, when it returns true the request is only
+	// captured and ErrRequestHeld is returned
+	Hold func(params map[string]string) bool
+}
+
+// ErrRequestHeld is returned for requests which were captured but not sent, see CapturedRequest.Hold
+var ErrRequestHeld = errors.New("request held back")
+
+type captureRequestKey struct{}
+
+// WithRequestCapture returns a context which makes the CaptureRequest middleware record
+// the requests made with it into the returned CapturedRequest
+func WithRequestCapture(ctx context.Context) (context.Context, *CapturedRequest) {
+	captured := &CapturedRequest{
+		Files: make(map[string]CapturedFile),
+	}
+	return context.WithValue(ctx, captureRequestKey{}, captured), captured
+}
+
+type captureRequestBotClient struct {
+	gotgbot.BotClient
+}
+
+func (b *captureRequestBotClient) RequestWithContext(ctx context.Context,
+	token string, method string, params map[string]string,
+	data map[string]gotgbot.FileReader,
+	opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+
+	captured, ok := ctx.Value(captureRequestKey{}).(*CapturedRequest)
+	if !ok {
+		return b.BotClient.RequestWithContext(ctx, token, method, params, data, opts)
+	}
+
+	captured.Method = method
+	captured.Params = maps.Clone(params)
+
+	for key, file := range data {
+		if fileBacked, ok := file.Data.(fileBackedReader); ok {
+			captured.Files[key] = CapturedFile{Name: file.Name, Path: fileBacked.FilePath()}
+			if _, err := fileBacked.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		capturedFile, found := captured.Files[key]
+		if !found {
+			fileBytes, err := io.ReadAll(file.Data)
+			if err != nil {
+				return nil, err
+			}
+			capturedFile = CapturedFile{Name: file.Name, Data: fileBytes}
+			captured.Files[key] = capturedFile
+		}
+		file.Data = bytes.NewReader(capturedFile.Data)
+		data[key] = file
+	}
+
+	if captured.Hold != nil && captured.Hold(captured.Params) {
+		return nil, ErrRequestHeld
+	}
+
+	return b.BotClient.RequestWithContext(ctx, token, method, params, data, opts)
+}
+
+func CaptureRequest(b gotgbot.BotClient) gotgbot.BotClient {
+	return &captureRequestBotClient{b}
+}
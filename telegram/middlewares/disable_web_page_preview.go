@@ -17,7 +17,8 @@ func (b *disableWebPagePreviewBotClient) RequestWithContext(ctx context.Context,
 	data map[string]gotgbot.FileReader,
 	opts *gotgbot.RequestOpts) (json.RawMessage, error) {
 
-	if strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit") {
+	// Previews asked for on purpose, like those of WhatsApp links, are left alone
+	if (strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit")) && params["link_preview_options"] == "" {
 		params["disable_web_page_preview"] = "true"
 	}
 
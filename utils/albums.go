@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// How long to wait for more items of a Telegram album after the last one arrived
+const tgAlbumWindow = 1500 * time.Millisecond
+
+// outboundStartAlbum creates the album on WhatsApp for the queued items of the album which the
+// first of the messages belongs to, and attaches the items to it. Telegram sends the items of an
+// album as separate messages sharing a media group ID, they are sent in the order they were sent
+// in. A single item, or an album which cannot be created, is sent as separate messages. It
+// returns false if WhatsApp is not connected, the album then stays held back.
+func outboundStartAlbum(outboundMsgs []database.OutboundMsg) bool {
+	var (
+		first  = outboundMsgs[0]
+		update gotgbot.Update
+	)
+
+	account := state.State.WhatsAppAccounts[0]
+	if err := json.Unmarshal([]byte(first.TgUpdate), &update); err == nil {
+		account = WaAccountByContext(ext.NewContext(state.State.TelegramBot, &update, nil))
+	}
+	if !account.Client.IsConnected() {
+		return false
+	}
+
+	var (
+		positions              []int
+		items                  []database.OutboundMsg
+		imageCount, videoCount uint32
+	)
+	for i, item := range outboundMsgs {
+		if item.TgChatId != first.TgChatId || item.TgAlbumId != first.TgAlbumId {
+			continue
+		}
+		positions = append(positions, i)
+		items = append(items, item)
+
+		var msg gotgbot.Message
+		if json.Unmarshal([]byte(item.TgMessage), &msg) == nil {
+			if len(msg.Photo) > 0 {
+				imageCount++
+			} else if msg.Video != nil {
+				videoCount++
+			}
+		}
+	}
+
+	var waAlbumId string
+	if imageCount+videoCount > 1 {
+		waAlbumId = waCreateAlbum(account, first.WaChatId, imageCount, videoCount)
+	}
+
+	slices.SortFunc(items, func(a, b database.OutboundMsg) int {
+		return int(a.TgMsgId - b.TgMsgId)
+	})
+	for i, item := range items {
+		item.TgAlbumId = ""
+		item.WaAlbumId = waAlbumId
+		database.OutboundMsgSave(&item)
+		outboundMsgs[positions[i]] = item
+	}
+
+	return true
+}
+
+// waCreateAlbum sends the message which the items of an album are attached to and returns its
+// ID, or an empty string if it could not be sent
+func waCreateAlbum(account *state.WhatsAppAccount, waChatId string, imageCount, videoCount uint32) string {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	waChatJID, ok := WaParseJID(waChatId)
+	if !ok {
+		return ""
+	}
+
+	sentMsg, err := account.Client.SendMessage(context.Background(), waChatJID, &waE2E.Message{
+		AlbumMessage: &waE2E.AlbumMessage{
+			ExpectedImageCount: proto.Uint32(imageCount),
+			ExpectedVideoCount: proto.Uint32(videoCount),
+		},
+	})
+	if err != nil {
+		logger.Warn("failed to create album on WhatsApp, sending the items on their own",
+			zap.String("chat_id", waChatId),
+			zap.Error(err),
+		)
+		return ""
+	}
+
+	return sentMsg.ID
+}
+
+// waAlbumItemContextInfo attaches a message to the album with the given ID
+func waAlbumItemContextInfo(waChatJID waTypes.JID, waAlbumId string) *waE2E.MessageContextInfo {
+	return &waE2E.MessageContextInfo{
+		MessageAssociation: &waE2E.MessageAssociation{
+			AssociationType: waE2E.MessageAssociation_MEDIA_ALBUM.Enum(),
+			ParentMessageKey: &waCommon.MessageKey{
+				RemoteJID: proto.String(waChatJID.String()),
+				FromMe:    proto.Bool(true),
+				ID:        proto.String(waAlbumId),
+			},
+		},
+	}
+}
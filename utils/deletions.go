@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// A report of deletions is small, this leaves room for a few thousand message IDs
+const tgDeletionsMaxBodyBytes = 64 * 1024
+
+// tgDeletions is what the userbot reports when messages are deleted in a chat. The chat
+// is given like the Bot API does, with the -100 prefix for supergroups.
+type tgDeletions struct {
+	ChatId     int64   `json:"chat_id"`
+	MessageIds []int64 `json:"message_ids"`
+}
+
+// TgServeDeletions takes the deletions reported by deletion-sync-userbot.py, a session of a user
+// in the target chats which Telegram tells about them unlike bots, and revokes the bridged
+// messages on WhatsApp.
+// Only requests carrying the configured secret are accepted, as revoking cannot be undone.
+func TgServeDeletions() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /deleted", func(w http.ResponseWriter, r *http.Request) {
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+cfg.Telegram.DeletionSync.Secret)) != 1 {
+			http.Error(w, "Invalid secret", http.StatusUnauthorized)
+			return
+		}
+
+		var deletions tgDeletions
+		err := json.NewDecoder(io.LimitReader(r.Body, tgDeletionsMaxBodyBytes)).Decode(&deletions)
+		if err != nil || deletions.ChatId == 0 {
+			http.Error(w, "Expected a JSON object with chat_id and message_ids", http.StatusBadRequest)
+			return
+		}
+
+		revoked := 0
+		for _, tgMsgId := range deletions.MessageIds {
+			revoked += TgRevokeDeleted(deletions.ChatId, tgMsgId)
+		}
+		fmt.Fprintf(w, "{\"revoked\":%d}\n", revoked)
+	})
+
+	logger.Info("listening for deletions on Telegram",
+		zap.String("listen_address", cfg.Telegram.DeletionSync.ListenAddress),
+	)
+	if err := http.ListenAndServe(cfg.Telegram.DeletionSync.ListenAddress, mux); err != nil {
+		logger.Error("deletion sync server stopped",
+			zap.Error(err),
+		)
+	}
+}
+
+// TgRevokeDeleted revokes the WhatsApp messages bridged to the deleted Telegram message and
+// returns how many were revoked. Only messages sent from our own accounts can be revoked, and
+// only while they are younger than lookback_hours.
+func TgRevokeDeleted(tgChatId, tgMsgId int64) int {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		since  = time.Now().Add(-time.Duration(cfg.Telegram.DeletionSync.LookbackHours) * time.Hour)
+	)
+	defer logger.Sync()
+
+	pairs, err := database.MsgIdGetPairsFromTg(tgChatId, tgMsgId)
+	if err != nil {
+		logger.Error("failed to get message id pairs of message deleted on Telegram",
+			zap.Int64("tg_chat_id", tgChatId),
+			zap.Int64("tg_msg_id", tgMsgId),
+			zap.Error(err),
+		)
+		return 0
+	}
+
+	revoked := 0
+	for _, pair := range pairs {
+		account := WaAccountByName(pair.Account)
+		if account == nil || account.Client.Store.ID == nil || pair.CreatedAt.Before(since) {
+			continue
+		}
+		if participant, _ := waTypes.ParseJID(pair.ParticipantId); participant.User != account.Client.Store.ID.User {
+			continue
+		}
+		if waRevokeDeletedOnTg(account, pair) {
+			revoked += 1
+		}
+	}
+
+	return revoked
+}
+
+// waRevokeDeletedOnTg revokes the WhatsApp message and forgets its pair
+func waRevokeDeletedOnTg(account *state.WhatsAppAccount, pair database.MsgIdPair) bool {
+	var (
+		logger   = state.State.Logger
+		waClient = account.Client
+	)
+
+	waChatJID, ok := WaParseJID(pair.WaChatId)
+	if !ok {
+		logger.Error("failed to parse chat of message deleted on Telegram",
+			zap.String("chat_id", pair.WaChatId),
+		)
+		return false
+	}
+
+	_, err := waClient.SendMessage(context.Background(), waChatJID, waClient.BuildRevoke(waChatJID, waTypes.EmptyJID, pair.ID))
+	if err != nil {
+		logger.Warn("failed to revoke message deleted on Telegram",
+			zap.String("msg_id", pair.ID),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	logger.Info("revoked message deleted on Telegram",
+		zap.String("account", account.Name),
+		zap.String("msg_id", pair.ID),
+		zap.Int64("tg_msg_id", pair.TgMsgId),
+	)
+
+	if err = database.MsgIdDeletePair(pair.TgChatId, pair.TgMsgId); err != nil {
+		logger.Error("failed to delete message id pair of revoked message",
+			zap.Int64("tg_msg_id", pair.TgMsgId),
+			zap.Error(err),
+		)
+	}
+	return true
+}
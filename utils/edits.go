@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// TgSendEditToWhatsApp edits the WhatsApp message which the Telegram message was bridged as, so
+// that it gets the new text or caption
+func TgSendEditToWhatsApp(b *gotgbot.Bot, c *ext.Context, account *state.WhatsAppAccount,
+	editedMsg *gotgbot.Message, waMsgId, participantId, waChatId string) error {
+
+	var (
+		cfg      = state.State.Config
+		waClient = account.Client
+	)
+
+	if participant, _ := waTypes.ParseJID(participantId); participant.User != waClient.Store.ID.User {
+		// Only the messages sent through the bridge belong to this account
+		return nil
+	}
+
+	waChatJID, err := waTypes.ParseJID(waChatId)
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, "Failed to parse the WhatsApp chat of the message", err)
+	}
+
+	if editedMsg.Location != nil {
+		// A live location moved, positions missed while disconnected are not worth reporting
+		if waClient.IsConnected() {
+			waSendLiveLocationUpdate(account, waChatJID, editedMsg)
+		}
+		return nil
+	}
+
+	if !waClient.IsConnected() {
+		return TgReplyWithErrorByContext(b, c, "Failed to edit the message on WhatsApp", whatsmeow.ErrNotConnected)
+	}
+
+	newContent, unresolvedNames := waEditedContentFromTg(account, waChatJID, editedMsg)
+	if newContent == nil {
+		_, err = TgReplyTextByContext(b, c, "This kind of message cannot be edited on WhatsApp", nil, false)
+		return err
+	}
+
+	_, err = waClient.SendMessage(context.Background(), waChatJID, waClient.BuildEdit(waChatJID, waMsgId, newContent))
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, "Failed to send the edit to WhatsApp", err)
+	}
+
+	SendEditConfirmation(b, c, cfg, editedMsg)
+
+	if len(unresolvedNames) > 0 {
+		for i := range unresolvedNames {
+			unresolvedNames[i] = "@" + html.EscapeString(unresolvedNames[i])
+		}
+		_, err = TgReplyTextByContext(b, c, fmt.Sprintf("%s did not exactly match one member of the group and was left as text in the edit, mention them by their number instead",
+			strings.Join(unresolvedNames, ", ")), nil, false)
+		return err
+	}
+	return nil
+}
+
+// waEditedContentFromTg builds the new content of the WhatsApp message, which has to be of the
+// same kind as the message being edited. It returns nil for messages WhatsApp cannot edit. There
+// is no queued message to pick members for, so the @Name mentions which are not exactly one
+// member are left as text and returned.
+func waEditedContentFromTg(account *state.WhatsAppAccount, waChatJID waTypes.JID,
+	editedMsg *gotgbot.Message) (*waE2E.Message, []string) {
+
+	var (
+		mentions        = tgMessageMentions(editedMsg)
+		pickedMentions  = make(map[string]string)
+		unresolvedNames []string
+	)
+
+	resolvedMentions, err := tgResolveNameMentions(account, waChatJID, editedMsg.Text+"\n"+editedMsg.Caption, pickedMentions)
+	var ambiguousErr *MentionAmbiguousError
+	for errors.As(err, &ambiguousErr) {
+		pickedMentions[ambiguousErr.Name] = ""
+		unresolvedNames = append(unresolvedNames, ambiguousErr.Name)
+		resolvedMentions, err = tgResolveNameMentions(account, waChatJID, editedMsg.Text+"\n"+editedMsg.Caption, pickedMentions)
+	}
+
+	text, textMentions := tgSubstituteMentions(TgTextWaMarkup(editedMsg), resolvedMentions)
+	caption, captionMentions := tgSubstituteMentions(TgCaptionWaMarkup(editedMsg), resolvedMentions)
+	for _, jid := range append(textMentions, captionMentions...) {
+		if !slices.Contains(mentions, jid) {
+			mentions = append(mentions, jid)
+		}
+	}
+
+	var contextInfo *waE2E.ContextInfo
+	if len(mentions) > 0 {
+		contextInfo = &waE2E.ContextInfo{MentionedJID: mentions}
+	}
+
+	switch {
+	case editedMsg.Text != "":
+		if contextInfo == nil {
+			return &waE2E.Message{Conversation: proto.String(text)}, unresolvedNames
+		}
+		return &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			Text:        proto.String(text),
+			ContextInfo: contextInfo,
+		}}, unresolvedNames
+	case len(editedMsg.Photo) > 0:
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+			Caption:     proto.String(caption),
+			ContextInfo: contextInfo,
+		}}, unresolvedNames
+	case editedMsg.Video != nil || editedMsg.Animation != nil:
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+			Caption:     proto.String(caption),
+			ContextInfo: contextInfo,
+		}}, unresolvedNames
+	case editedMsg.Document != nil:
+		return &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+			Caption:     proto.String(caption),
+			ContextInfo: contextInfo,
+		}}, unresolvedNames
+	}
+	return nil, nil
+}
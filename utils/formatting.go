@@ -0,0 +1,272 @@
+package utils
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// Inline styles of WhatsApp markup and the Telegram HTML tags they stand for
+var (
+	tgEntityWaMarkers = map[string]string{
+		"bold":          "*",
+		"italic":        "_",
+		"strikethrough": "~",
+		"code":          "`",
+	}
+	waMarkerTgTags = map[rune]string{
+		'*': "b",
+		'_': "i",
+		'~': "s",
+		'`': "code",
+	}
+)
+
+// waMarkupSpan is a part of the text wrapped in WhatsApp markers
+type waMarkupSpan struct {
+	start, end  int
+	open, close string
+}
+
+// TgTextWaMarkup returns the text of the Telegram message with its formatting as WhatsApp markup
+func TgTextWaMarkup(msg *gotgbot.Message) string {
+	return TgEntitiesToWaMarkup(msg.Text, msg.ParseEntities())
+}
+
+// TgCaptionWaMarkup returns the caption of the Telegram message with its formatting as WhatsApp markup
+func TgCaptionWaMarkup(msg *gotgbot.Message) string {
+	return TgEntitiesToWaMarkup(msg.Caption, msg.ParseCaptionEntities())
+}
+
+// TgEntitiesToWaMarkup writes the formatting of the text as WhatsApp markup. WhatsApp has no
+// spoilers or underlines, so their text is kept plain, and links get their address after them.
+func TgEntitiesToWaMarkup(text string, entities []gotgbot.ParsedMessageEntity) string {
+	var (
+		spans  []waMarkupSpan
+		quotes [][2]int
+	)
+
+	for _, entity := range entities {
+		start, end := int(entity.Offset), int(entity.Offset+entity.Length)
+
+		switch entity.Type {
+		case "bold", "italic", "strikethrough", "code":
+			// WhatsApp markers have to hug the text and cannot span lines
+			marker := tgEntityWaMarkers[entity.Type]
+			for lineStart := start; lineStart < end; {
+				lineEnd := strings.IndexByte(text[lineStart:end], '\n')
+				if lineEnd < 0 {
+					lineEnd = end
+				} else {
+					lineEnd += lineStart
+				}
+
+				line := text[lineStart:lineEnd]
+				trimmedStart := lineStart + len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+				trimmedEnd := lineStart + len(strings.TrimRightFunc(line, unicode.IsSpace))
+				if trimmedStart < trimmedEnd {
+					spans = append(spans, waMarkupSpan{trimmedStart, trimmedEnd, marker, marker})
+				}
+				lineStart = lineEnd + 1
+			}
+		case "pre":
+			spans = append(spans, waMarkupSpan{start, end, "```", "```"})
+		case "text_link":
+			if entity.Text != entity.Url {
+				spans = append(spans, waMarkupSpan{start, end, "", " (" + entity.Url + ")"})
+			}
+		case "blockquote", "expandable_blockquote":
+			quotes = append(quotes, [2]int{start, end})
+		}
+	}
+
+	if len(spans) == 0 && len(quotes) == 0 {
+		return text
+	}
+
+	// Outer spans open first and close last, spans over the same text nest in their order
+	order := make([]int, len(spans))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		if spans[a].start != spans[b].start {
+			return spans[a].start - spans[b].start
+		}
+		return spans[b].end - spans[a].end
+	})
+
+	var (
+		result strings.Builder
+		open   []int
+	)
+	for i := 0; i <= len(text); i++ {
+		for j := len(open) - 1; j >= 0; j-- {
+			if span := spans[open[j]]; span.end == i {
+				result.WriteString(span.close)
+				open = slices.Delete(open, j, j+1)
+			}
+		}
+
+		if i == len(text) {
+			break
+		}
+
+		if i == 0 || text[i-1] == '\n' {
+			for _, quote := range quotes {
+				if i >= quote[0] && i < quote[1] {
+					result.WriteString("> ")
+					break
+				}
+			}
+		}
+
+		for _, index := range order {
+			if spans[index].start == i {
+				result.WriteString(spans[index].open)
+				open = append(open, index)
+			}
+		}
+
+		result.WriteByte(text[i])
+	}
+
+	return result.String()
+}
+
+// WaMarkupToTgHtml escapes the WhatsApp text into Telegram HTML, turning its markup into tags.
+// Code blocks, inline code, quotes and bulleted lists are kept, and styles can be nested.
+func WaMarkupToTgHtml(text string) string {
+	var result strings.Builder
+
+	for i, part := range strings.Split(text, "```") {
+		// Every other part is inside a code block, a marker left alone is kept as it is
+		if i%2 == 1 {
+			if i == strings.Count(text, "```") {
+				result.WriteString(html.EscapeString("```" + part))
+			} else {
+				result.WriteString("<pre>" + html.EscapeString(part) + "</pre>")
+			}
+			continue
+		}
+
+		result.WriteString(waLinesToTgHtml(part))
+	}
+
+	return result.String()
+}
+
+func waLinesToTgHtml(text string) string {
+	var (
+		result  strings.Builder
+		inQuote bool
+	)
+
+	for i, line := range strings.Split(text, "\n") {
+		quoted, isQuote := strings.CutPrefix(line, "> ")
+		if !isQuote && line == ">" {
+			quoted, isQuote = "", true
+		}
+
+		if i > 0 && !(inQuote && !isQuote) {
+			result.WriteByte('\n')
+		}
+		if isQuote && !inQuote {
+			result.WriteString("<blockquote>")
+		} else if !isQuote && inQuote {
+			result.WriteString("</blockquote>\n")
+		}
+		inQuote = isQuote
+
+		if isQuote {
+			line = quoted
+		}
+		if item, isItem := strings.CutPrefix(line, "* "); isItem {
+			line = "• " + item
+		} else if item, isItem := strings.CutPrefix(line, "- "); isItem {
+			line = "• " + item
+		}
+
+		result.WriteString(waInlineToTgHtml([]rune(line)))
+	}
+
+	if inQuote {
+		result.WriteString("</blockquote>")
+	}
+	return result.String()
+}
+
+func waInlineToTgHtml(line []rune) string {
+	var result strings.Builder
+
+	for i := 0; i < len(line); i++ {
+		if end := waLinkEnd(line, i); end > 0 {
+			// Markers in links are part of them
+			result.WriteString(html.EscapeString(string(line[i:end])))
+			i = end - 1
+			continue
+		}
+
+		tag, isMarker := waMarkerTgTags[line[i]]
+		if isMarker && waMarkerCanOpen(line, i) {
+			if end := waMarkerClose(line, i); end > 0 {
+				inner := line[i+1 : end]
+				if tag == "code" {
+					result.WriteString("<code>" + html.EscapeString(string(inner)) + "</code>")
+				} else {
+					result.WriteString("<" + tag + ">" + waInlineToTgHtml(inner) + "</" + tag + ">")
+				}
+				i = end
+				continue
+			}
+		}
+
+		result.WriteString(html.EscapeString(string(line[i])))
+	}
+
+	return result.String()
+}
+
+// waMarkerCanOpen reports whether the marker starts a style, which it does at the start of a
+// word when text follows it
+func waMarkerCanOpen(line []rune, i int) bool {
+	return (i == 0 || !waIsWordRune(line[i-1])) && i+1 < len(line) && !unicode.IsSpace(line[i+1])
+}
+
+// waMarkerClose finds the marker ending the style started at i, at the end of a word
+func waMarkerClose(line []rune, i int) int {
+	for j := i + 2; j < len(line); j++ {
+		if end := waLinkEnd(line, j); end > 0 {
+			j = end - 1
+			continue
+		}
+		if line[j] == line[i] && !unicode.IsSpace(line[j-1]) && (j+1 == len(line) || !waIsWordRune(line[j+1])) {
+			return j
+		}
+	}
+	return -1
+}
+
+// waLinkEnd returns where the word starting at i ends if it is a link, or -1 if it is not
+func waLinkEnd(line []rune, i int) int {
+	if i > 0 && !unicode.IsSpace(line[i-1]) {
+		return -1
+	}
+
+	end := i
+	for end < len(line) && !unicode.IsSpace(line[end]) {
+		end++
+	}
+
+	if word := string(line[i:end]); strings.Contains(word, "://") || strings.Contains(word, "www.") {
+		return end
+	}
+	return -1
+}
+
+func waIsWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
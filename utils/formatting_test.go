@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+func TestTgTextWaMarkup(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []gotgbot.MessageEntity
+		want     string
+	}{
+		{
+			name: "plain",
+			text: "hello there",
+			want: "hello there",
+		},
+		{
+			name:     "bold",
+			text:     "hello there",
+			entities: []gotgbot.MessageEntity{{Type: "bold", Offset: 6, Length: 5}},
+			want:     "hello *there*",
+		},
+		{
+			name: "nested",
+			text: "bold and italic",
+			entities: []gotgbot.MessageEntity{
+				{Type: "bold", Offset: 0, Length: 15},
+				{Type: "italic", Offset: 9, Length: 6},
+			},
+			want: "*bold and _italic_*",
+		},
+		{
+			name:     "markers hug the text",
+			text:     "a  spaced  b",
+			entities: []gotgbot.MessageEntity{{Type: "bold", Offset: 1, Length: 9}},
+			want:     "a  *spaced*  b",
+		},
+		{
+			name:     "markers do not span lines",
+			text:     "one\ntwo",
+			entities: []gotgbot.MessageEntity{{Type: "strikethrough", Offset: 0, Length: 7}},
+			want:     "~one~\n~two~",
+		},
+		{
+			name:     "code block",
+			text:     "x := 1\ny := 2",
+			entities: []gotgbot.MessageEntity{{Type: "pre", Offset: 0, Length: 13}},
+			want:     "```x := 1\ny := 2```",
+		},
+		{
+			name:     "inline code",
+			text:     "run go test now",
+			entities: []gotgbot.MessageEntity{{Type: "code", Offset: 4, Length: 7}},
+			want:     "run `go test` now",
+		},
+		{
+			name:     "text link",
+			text:     "see the docs",
+			entities: []gotgbot.MessageEntity{{Type: "text_link", Offset: 8, Length: 4, Url: "https://example.com"}},
+			want:     "see the docs (https://example.com)",
+		},
+		{
+			name:     "quote",
+			text:     "said:\nfirst\nsecond",
+			entities: []gotgbot.MessageEntity{{Type: "blockquote", Offset: 6, Length: 12}},
+			want:     "said:\n> first\n> second",
+		},
+		{
+			name:     "spoiler is kept plain",
+			text:     "it was him",
+			entities: []gotgbot.MessageEntity{{Type: "spoiler", Offset: 7, Length: 3}},
+			want:     "it was him",
+		},
+		{
+			// The emoji takes two UTF-16 code units and four bytes
+			name:     "utf-16 offsets",
+			text:     "👍 good ünïcode",
+			entities: []gotgbot.MessageEntity{{Type: "bold", Offset: 3, Length: 4}, {Type: "italic", Offset: 8, Length: 7}},
+			want:     "👍 *good* _ünïcode_",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg := &gotgbot.Message{Text: test.text, Entities: test.entities}
+			if got := TgTextWaMarkup(msg); got != test.want {
+				t.Errorf("TgTextWaMarkup() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWaMarkupToTgHtml(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "plain is escaped",
+			text: "a < b & c",
+			want: "a &lt; b &amp; c",
+		},
+		{
+			name: "styles",
+			text: "*bold* _italic_ ~struck~ `code`",
+			want: "<b>bold</b> <i>italic</i> <s>struck</s> <code>code</code>",
+		},
+		{
+			name: "nested",
+			text: "*bold _and italic_*",
+			want: "<b>bold <i>and italic</i></b>",
+		},
+		{
+			name: "code is not styled",
+			text: "`*not bold*`",
+			want: "<code>*not bold*</code>",
+		},
+		{
+			name: "unclosed marker",
+			text: "*not bold",
+			want: "*not bold",
+		},
+		{
+			name: "markers inside words",
+			text: "snake_case_name 2*3*4",
+			want: "snake_case_name 2*3*4",
+		},
+		{
+			name: "marker needs text after it",
+			text: "* not bold*",
+			want: "• not bold*",
+		},
+		{
+			name: "code block",
+			text: "look:\n```*x* < 1```",
+			want: "look:\n<pre>*x* &lt; 1</pre>",
+		},
+		{
+			name: "unclosed code block",
+			text: "```no end",
+			want: "```no end",
+		},
+		{
+			name: "quote",
+			text: "> first\n> *second*\nafter",
+			want: "<blockquote>first\n<b>second</b></blockquote>\nafter",
+		},
+		{
+			name: "list",
+			text: "* one\n- two",
+			want: "• one\n• two",
+		},
+		{
+			name: "link keeps its markers",
+			text: "see https://x.com/_foo_ now",
+			want: "see https://x.com/_foo_ now",
+		},
+		{
+			name: "link without scheme",
+			text: "www.x.com/*a*",
+			want: "www.x.com/*a*",
+		},
+		{
+			name: "style around a link",
+			text: "_go to https://x.com/a_b here_",
+			want: "<i>go to https://x.com/a_b here</i>",
+		},
+		{
+			name: "link is escaped",
+			text: "https://x.com/?a=1&b=2",
+			want: "https://x.com/?a=1&amp;b=2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := WaMarkupToTgHtml(test.text); got != test.want {
+				t.Errorf("WaMarkupToTgHtml(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package utils
 
+import "time"
+
 func SubString(s string, start, length int) string {
 	asRunes := []rune(s)
 
@@ -13,3 +15,16 @@ func SubString(s string, start, length int) string {
 
 	return string(s[start : start+length])
 }
+
+// retryDelay returns the exponential backoff delay before the next attempt
+func retryDelay(attempts, baseDelaySec, maxDelaySec int) time.Duration {
+	var (
+		delay    = time.Duration(baseDelaySec) * time.Second
+		maxDelay = time.Duration(maxDelaySec) * time.Second
+	)
+
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
@@ -0,0 +1,342 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/telegram/middlewares"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+var inboundLock sync.Mutex
+
+// TgDeliverFromWa sends a message coming from WhatsApp to Telegram using the given function and
+// maps the message IDs once it is delivered. If Telegram refuses it, the rendered request is stored
+// in the inbound queue so that InboundProcessQueue can retry it later. While earlier messages to
+// the same topic wait in the queue, the message is queued behind them without being sent.
+func TgDeliverFromWa(account *state.WhatsAppAccount, waMsgId, participantId, waChatId string,
+	send func(ctx context.Context) (*gotgbot.Message, error)) (*gotgbot.Message, error) {
+
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	ctx, captured := middlewares.WithRequestCapture(context.Background())
+	captured.Hold = inboundThreadIsWaiting
+
+	sentMsg, err := send(ctx)
+	if err == nil {
+		if sentMsg != nil && sentMsg.MessageId != 0 && waMsgId != "" {
+			database.MsgIdAddNewPair(account.Name, waMsgId, participantId, waChatId,
+				sentMsg.Chat.Id, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		return sentMsg, nil
+	}
+
+	isHeld := errors.Is(err, middlewares.ErrRequestHeld)
+	if isHeld {
+		logger.Info("queueing message from WhatsApp behind the ones waiting for the topic",
+			zap.String("account", account.Name),
+			zap.String("wa_msg_id", waMsgId),
+			zap.String("wa_chat_id", waChatId),
+		)
+	} else {
+		logger.Error("failed to send message from WhatsApp to Telegram",
+			zap.String("account", account.Name),
+			zap.String("wa_msg_id", waMsgId),
+			zap.String("wa_chat_id", waChatId),
+			zap.Error(err),
+		)
+	}
+
+	if captured.Method == "" {
+		// The request never reached the bot client, so there is nothing to retry
+		return nil, err
+	}
+
+	inboundMsg, encodeErr := inboundMsgFromCapture(captured)
+	if encodeErr != nil {
+		logger.Error("failed to encode the request for the inbound queue",
+			zap.String("wa_msg_id", waMsgId),
+			zap.Error(encodeErr),
+		)
+		return nil, err
+	}
+
+	inboundMsg.Account = account.Name
+	inboundMsg.WaMsgId = waMsgId
+	inboundMsg.ParticipantId = participantId
+	inboundMsg.WaChatId = waChatId
+	inboundMsg.Attempts = 1
+	inboundMsg.LastError = err.Error()
+
+	if isHeld {
+		// Sent by InboundProcessQueue right after the ones before it
+		inboundMsg.Attempts = 0
+		inboundMsg.NextAttemptAt = time.Now()
+	} else if inboundErrIsPermanent(err) {
+		inboundMsg.State = "dead"
+	} else {
+		inboundMsg.NextAttemptAt = time.Now().Add(retryDelay(inboundMsg.Attempts,
+			cfg.WhatsApp.InboundQueue.RetryBaseDelaySec, cfg.WhatsApp.InboundQueue.RetryMaxDelaySec))
+	}
+
+	if dbErr := database.InboundMsgAddNew(inboundMsg); dbErr != nil {
+		logger.Error("failed to add the message to the inbound queue",
+			zap.String("wa_msg_id", waMsgId),
+			zap.Error(dbErr),
+		)
+	}
+
+	return nil, err
+}
+
+// inboundThreadIsWaiting reports whether messages to the topic of the request wait in the queue
+func inboundThreadIsWaiting(params map[string]string) bool {
+	var tgChatId, tgThreadId int64
+	fmt.Sscan(params["chat_id"], &tgChatId)
+	fmt.Sscan(params["message_thread_id"], &tgThreadId)
+
+	isWaiting, err := database.InboundMsgHasPendingInThread(tgChatId, tgThreadId)
+	return err == nil && isWaiting
+}
+
+// InboundProcessQueue retries the pending deliveries to Telegram which are due. Messages to the
+// same Telegram topic are kept in order, so a topic is skipped once one of its messages fails again.
+func InboundProcessQueue() {
+	var (
+		logger         = state.State.Logger
+		waitingThreads = make(map[string]bool)
+	)
+	defer logger.Sync()
+
+	inboundLock.Lock()
+	defer inboundLock.Unlock()
+
+	pendingMsgs, err := database.InboundMsgGetByState("pending")
+	if err != nil {
+		logger.Error("failed to get pending messages from the inbound queue",
+			zap.Error(err),
+		)
+		return
+	}
+
+	for i := range pendingMsgs {
+		inboundMsg := &pendingMsgs[i]
+		threadKey := fmt.Sprintf("%d:%d", inboundMsg.TgChatId, inboundMsg.TgThreadId)
+
+		if waitingThreads[threadKey] {
+			continue
+		}
+
+		if inboundMsg.NextAttemptAt.After(time.Now()) || !inboundAttempt(inboundMsg) {
+			waitingThreads[threadKey] = true
+		}
+	}
+}
+
+// InboundReplay moves a dead delivery back into the queue and retries it right away
+func InboundReplay(id uint) error {
+	inboundMsg, found, err := database.InboundMsgGetDeadById(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no failed delivery with ID %d", id)
+	}
+
+	inboundMsg.State = "pending"
+	inboundMsg.Attempts = 0
+	inboundMsg.NextAttemptAt = time.Now()
+	if err = database.InboundMsgSave(&inboundMsg); err != nil {
+		return err
+	}
+
+	InboundProcessQueue()
+	return nil
+}
+
+// InboundDiscard drops a dead delivery along with its files
+func InboundDiscard(id uint) error {
+	inboundMsg, found, err := database.InboundMsgGetDeadById(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no failed delivery with ID %d", id)
+	}
+
+	inboundRemoveFiles(&inboundMsg)
+	return database.InboundMsgDelete(id)
+}
+
+// inboundAttempt sends a queued request to Telegram once more and returns true if the message
+// is done with, i.e. it was either delivered or moved to the dead letters.
+func inboundAttempt(inboundMsg *database.InboundMsg) bool {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+
+	var (
+		params map[string]string
+		files  map[string]middlewares.CapturedFile
+	)
+
+	decodeErr := json.Unmarshal([]byte(inboundMsg.Params), &params)
+	if inboundMsg.Files != "" {
+		decodeErr = errors.Join(decodeErr, json.Unmarshal([]byte(inboundMsg.Files), &files))
+	}
+	if decodeErr != nil {
+		logger.Error("failed to decode message from the inbound queue",
+			zap.Uint("id", inboundMsg.ID),
+			zap.Error(decodeErr),
+		)
+		inboundMsg.State = "dead"
+		inboundMsg.LastError = decodeErr.Error()
+		database.InboundMsgSave(inboundMsg)
+		return true
+	}
+
+	var data map[string]gotgbot.FileReader
+	if len(files) > 0 {
+		data = make(map[string]gotgbot.FileReader, len(files))
+		for key, file := range files {
+			if file.Path == "" {
+				data[key] = gotgbot.FileReader{Name: file.Name, Data: bytes.NewReader(file.Data)}
+				continue
+			}
+
+			spooledFile, err := os.Open(file.Path)
+			if err != nil {
+				logger.Error("failed to open file of message from the inbound queue",
+					zap.Uint("id", inboundMsg.ID),
+					zap.Error(err),
+				)
+				inboundMsg.State = "dead"
+				inboundMsg.LastError = err.Error()
+				database.InboundMsgSave(inboundMsg)
+				return true
+			}
+			defer spooledFile.Close()
+			data[key] = gotgbot.FileReader{Name: file.Name, Data: spooledFile}
+		}
+	}
+
+	response, err := tgBot.RequestWithContext(context.Background(), inboundMsg.Method, params, data, nil)
+	inboundMsg.Attempts += 1
+
+	if err == nil {
+		var sentMsg gotgbot.Message
+		if json.Unmarshal(response, &sentMsg) == nil && sentMsg.MessageId != 0 && inboundMsg.WaMsgId != "" {
+			database.MsgIdAddNewPair(inboundMsg.Account, inboundMsg.WaMsgId, inboundMsg.ParticipantId, inboundMsg.WaChatId,
+				sentMsg.Chat.Id, sentMsg.MessageId, sentMsg.MessageThreadId)
+		}
+		logger.Info("delivered queued message to Telegram",
+			zap.Uint("id", inboundMsg.ID),
+			zap.Int("attempts", inboundMsg.Attempts),
+		)
+		inboundRemoveFiles(inboundMsg)
+		database.InboundMsgDelete(inboundMsg.ID)
+		return true
+	}
+
+	inboundMsg.LastError = err.Error()
+
+	if inboundErrIsPermanent(err) || inboundMsg.Attempts >= cfg.WhatsApp.InboundQueue.MaxAttempts {
+		logger.Error("giving up on delivering queued message to Telegram",
+			zap.Uint("id", inboundMsg.ID),
+			zap.Int("attempts", inboundMsg.Attempts),
+			zap.Error(err),
+		)
+		inboundMsg.State = "dead"
+		database.InboundMsgSave(inboundMsg)
+		return true
+	}
+
+	retryDelay := retryDelay(inboundMsg.Attempts,
+		cfg.WhatsApp.InboundQueue.RetryBaseDelaySec, cfg.WhatsApp.InboundQueue.RetryMaxDelaySec)
+	inboundMsg.NextAttemptAt = time.Now().Add(retryDelay)
+
+	logger.Info("queued message could not be delivered to Telegram, will retry",
+		zap.Uint("id", inboundMsg.ID),
+		zap.Int("attempts", inboundMsg.Attempts),
+		zap.Duration("retry_in", retryDelay),
+		zap.Error(err),
+	)
+	database.InboundMsgSave(inboundMsg)
+	return false
+}
+
+func inboundMsgFromCapture(captured *middlewares.CapturedRequest) (*database.InboundMsg, error) {
+	paramsBytes, err := json.Marshal(captured.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	inboundMsg := &database.InboundMsg{
+		Method: captured.Method,
+		Params: string(paramsBytes),
+	}
+
+	if len(captured.Files) > 0 {
+		// The files on disk are temporary, so the queue keeps its own copies
+		for key, file := range captured.Files {
+			if file.Path == "" {
+				continue
+			}
+			if file.Path, err = mediaSpool(file.Path); err != nil {
+				return nil, err
+			}
+			captured.Files[key] = file
+		}
+
+		filesBytes, err := json.Marshal(captured.Files)
+		if err != nil {
+			return nil, err
+		}
+		inboundMsg.Files = string(filesBytes)
+	}
+
+	fmt.Sscan(captured.Params["chat_id"], &inboundMsg.TgChatId)
+	fmt.Sscan(captured.Params["message_thread_id"], &inboundMsg.TgThreadId)
+
+	return inboundMsg, nil
+}
+
+// inboundRemoveFiles removes the copies of the files which were kept for the delivery
+func inboundRemoveFiles(inboundMsg *database.InboundMsg) {
+	var files map[string]middlewares.CapturedFile
+	if inboundMsg.Files == "" || json.Unmarshal([]byte(inboundMsg.Files), &files) != nil {
+		return
+	}
+
+	for _, file := range files {
+		if file.Path != "" {
+			os.Remove(file.Path)
+		}
+	}
+}
+
+// inboundErrIsPermanent reports whether retrying the request cannot succeed, e.g. when
+// Telegram rejected it as malformed or the bot was removed from the chat
+func inboundErrIsPermanent(err error) bool {
+	var tgErr *gotgbot.TelegramError
+	if errors.As(err, &tgErr) {
+		return tgErr.Code == 400 || tgErr.Code == 403
+	}
+	return false
+}
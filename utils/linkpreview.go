@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// Only the start of the page is read, the meta tags are in its head
+	linkPreviewMaxPageBytes   = 512 * 1024
+	linkPreviewMaxImageBytes  = 5 * 1024 * 1024
+	linkPreviewMaxImagePixels = 25_000_000
+
+	// Longest side of the thumbnail sent along with the preview
+	linkPreviewThumbnailSize = 250
+)
+
+var (
+	htmlMetaRegex  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	htmlAttrRegex  = regexp.MustCompile(`(?is)([\w:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	htmlTitleRegex = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// LinkPreview is what WhatsApp shows about a link in a message
+type LinkPreview struct {
+	URL         string
+	Title       string
+	Description string
+
+	Thumbnail       []byte // JPEG
+	ThumbnailWidth  int
+	ThumbnailHeight int
+}
+
+// WaLinkPreviewEnabled reports whether links sent to the chat get previews
+func WaLinkPreviewEnabled(waChatJID waTypes.JID) bool {
+	cfg := state.State.Config
+	return cfg.Telegram.LinkPreviews.Enabled && !slices.Contains(cfg.Telegram.LinkPreviews.DisabledChats, waChatJID.User)
+}
+
+// TgFirstLink returns the first link in the text of the message as it is written there, along
+// with the address it goes to
+func TgFirstLink(msg *gotgbot.Message) (string, string) {
+	for _, entity := range msg.ParseEntities() {
+		switch entity.Type {
+		case "url":
+			if !strings.Contains(entity.Text, "://") {
+				return entity.Text, "https://" + entity.Text
+			}
+			return entity.Text, entity.Text
+		case "text_link":
+			// The address is written after the text, see TgEntitiesToWaMarkup
+			return entity.Url, entity.Url
+		}
+	}
+	return "", ""
+}
+
+// TgFetchLinkPreview makes the WhatsApp preview of the first link in the text of the message, or
+// returns nil if the chat gets no previews or the page has none. Previews turned off for the
+// message on Telegram are not made for WhatsApp either.
+func TgFetchLinkPreview(msg *gotgbot.Message, waChatJID waTypes.JID) *LinkPreview {
+	logger := state.State.Logger
+
+	matchedText, linkUrl := TgFirstLink(msg)
+	if linkUrl == "" || !WaLinkPreviewEnabled(waChatJID) ||
+		(msg.LinkPreviewOptions != nil && msg.LinkPreviewOptions.IsDisabled) {
+		return nil
+	}
+
+	linkPreview, err := LinkPreviewFetch(linkUrl)
+	if err != nil {
+		logger.Debug("failed to make link preview",
+			zap.String("url", linkUrl),
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	linkPreview.URL = matchedText
+	return linkPreview
+}
+
+// LinkPreviewFetch reads the title, description and image of the page from its meta tags, the
+// image is made into a small JPEG thumbnail
+func LinkPreviewFetch(pageUrl string) (*LinkPreview, error) {
+	client := &http.Client{
+		Timeout: time.Duration(state.State.Config.Telegram.LinkPreviews.TimeoutSec) * time.Second,
+	}
+
+	res, err := client.Get(pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("received non-200 status code : %s", res.Status)
+	} else if contentType := res.Header.Get("Content-Type"); !strings.Contains(contentType, "html") {
+		return nil, fmt.Errorf("the link is not a page but %s", contentType)
+	}
+
+	page, err := io.ReadAll(io.LimitReader(res.Body, linkPreviewMaxPageBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]string)
+	for _, tag := range htmlMetaRegex.FindAllString(string(page), -1) {
+		attrs := make(map[string]string)
+		for _, attr := range htmlAttrRegex.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3]
+		}
+
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		if key = strings.ToLower(key); key != "" && meta[key] == "" {
+			meta[key] = html.UnescapeString(strings.TrimSpace(attrs["content"]))
+		}
+	}
+
+	preview := &LinkPreview{
+		URL:         pageUrl,
+		Title:       firstNonEmpty(meta["og:title"], meta["twitter:title"]),
+		Description: firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"]),
+	}
+	if preview.Title == "" {
+		if match := htmlTitleRegex.FindSubmatch(page); match != nil {
+			preview.Title = html.UnescapeString(strings.TrimSpace(string(match[1])))
+		}
+	}
+	if preview.Title == "" && preview.Description == "" {
+		return nil, fmt.Errorf("the page has no title or description")
+	}
+
+	// A preview without a thumbnail is still worth sending
+	if imageUrl := firstNonEmpty(meta["og:image"], meta["og:image:url"], meta["twitter:image"]); imageUrl != "" {
+		if imageUrl, err := res.Request.URL.Parse(imageUrl); err == nil {
+			preview.Thumbnail, preview.ThumbnailWidth, preview.ThumbnailHeight, _ = linkPreviewThumbnail(client, imageUrl)
+		}
+	}
+
+	return preview, nil
+}
+
+// linkPreviewThumbnail downloads the image and scales it down to a JPEG thumbnail
+func linkPreviewThumbnail(client *http.Client, imageUrl *url.URL) ([]byte, int, int, error) {
+	res, err := client.Get(imageUrl.String())
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, 0, 0, fmt.Errorf("received non-200 status code : %s", res.Status)
+	}
+
+	imageBytes, err := io.ReadAll(io.LimitReader(res.Body, linkPreviewMaxImageBytes))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// Small files can still be huge images once decoded
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, 0, 0, err
+	} else if imageConfig.Width*imageConfig.Height > linkPreviewMaxImagePixels {
+		return nil, 0, 0, fmt.Errorf("the image is too big at %dx%d", imageConfig.Width, imageConfig.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	thumbnail := imageScaleDown(img, linkPreviewThumbnailSize)
+
+	var thumbnailBytes bytes.Buffer
+	if err = jpeg.Encode(&thumbnailBytes, thumbnail, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, 0, 0, err
+	}
+
+	bounds := thumbnail.Bounds()
+	return thumbnailBytes.Bytes(), bounds.Dx(), bounds.Dy(), nil
+}
+
+// imageScaleDown fits the image into a square of the given size, averaging the pixels which
+// are merged into one. Transparent parts are made white, as JPEG has no transparency.
+func imageScaleDown(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	newWidth, newHeight := width, height
+	if width > size || height > size {
+		newWidth, newHeight = size, height*size/width
+		if height > width {
+			newWidth, newHeight = width*size/height, size
+		}
+	}
+	newWidth, newHeight = max(newWidth, 1), max(newHeight, 1)
+
+	scaled := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := range newHeight {
+		for x := range newWidth {
+			var (
+				x0, x1 = bounds.Min.X + x*width/newWidth, bounds.Min.X + max((x+1)*width/newWidth, x*width/newWidth+1)
+				y0, y1 = bounds.Min.Y + y*height/newHeight, bounds.Min.Y + max((y+1)*height/newHeight, y*height/newHeight+1)
+
+				r, g, b, a, count uint64
+			)
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+
+			// The colors are premultiplied, so the missing alpha is what white adds
+			white := 0xffff - a/count
+			i := scaled.PixOffset(x, y)
+			scaled.Pix[i+0] = uint8((r/count + white) >> 8)
+			scaled.Pix[i+1] = uint8((g/count + white) >> 8)
+			scaled.Pix[i+2] = uint8((b/count + white) >> 8)
+			scaled.Pix[i+3] = 0xff
+		}
+	}
+
+	return scaled
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
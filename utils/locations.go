@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// Telegram sends a new position every few seconds, WhatsApp is told at most this often
+const waLiveLocationMinInterval = 30 * time.Second
+
+var (
+	waLiveLocationLastSent     = make(map[string]time.Time)
+	waLiveLocationLastSentLock sync.Mutex
+)
+
+// waLiveLocationFromTg returns the current position of a Telegram live location. Every edit of
+// the live location is a newer position, the edit date keeps the sequence increasing.
+func waLiveLocationFromTg(msg *gotgbot.Message) *waE2E.LiveLocationMessage {
+	location := msg.Location
+
+	liveLocation := &waE2E.LiveLocationMessage{
+		DegreesLatitude:                   proto.Float64(location.Latitude),
+		DegreesLongitude:                  proto.Float64(location.Longitude),
+		AccuracyInMeters:                  proto.Uint32(uint32(location.HorizontalAccuracy)),
+		DegreesClockwiseFromMagneticNorth: proto.Uint32(uint32(location.Heading)),
+		SequenceNumber:                    proto.Int64(msg.Date),
+	}
+	if msg.EditDate != 0 {
+		liveLocation.SequenceNumber = proto.Int64(msg.EditDate)
+		liveLocation.TimeOffset = proto.Uint32(uint32(msg.EditDate - msg.Date))
+	}
+
+	return liveLocation
+}
+
+// waSendLiveLocationUpdate sends the new position of a live location shared from Telegram.
+// Updates come often, so positions coming sooner than waLiveLocationMinInterval after the last
+// sent one are dropped and failures are only logged. The last position, sent when the sharing
+// stops, is always sent.
+func waSendLiveLocationUpdate(account *state.WhatsAppAccount, waChatJID waTypes.JID, editedMsg *gotgbot.Message) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	var (
+		key       = fmt.Sprintf("%d:%d", editedMsg.Chat.Id, editedMsg.MessageId)
+		isStopped = editedMsg.Location.LivePeriod == 0
+	)
+
+	waLiveLocationLastSentLock.Lock()
+	if lastSent, found := waLiveLocationLastSent[key]; found && !isStopped && time.Since(lastSent) < waLiveLocationMinInterval {
+		waLiveLocationLastSentLock.Unlock()
+		return
+	}
+	if isStopped {
+		delete(waLiveLocationLastSent, key)
+	} else {
+		waLiveLocationLastSent[key] = time.Now()
+	}
+	waLiveLocationLastSentLock.Unlock()
+
+	_, err := account.Client.SendMessage(context.Background(), waChatJID, &waE2E.Message{
+		LiveLocationMessage: waLiveLocationFromTg(editedMsg),
+	})
+	if err != nil {
+		logger.Warn("failed to send live location update to WhatsApp",
+			zap.String("chat_id", waChatJID.String()),
+			zap.Int64("tg_msg_id", editedMsg.MessageId),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,295 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+)
+
+// MediaFile is a file being moved between WhatsApp and Telegram. It is kept in a temporary file
+// instead of memory, and counts against the transfer limits until it is closed.
+type MediaFile struct {
+	*os.File
+	Size int64
+
+	owned    bool // Temporary file to be removed on close, not a file of the local Bot API server
+	reserved int64
+}
+
+// mediaReader reads a media file from its start, whatever else reads the file. Its path lets
+// the request capture refer to the file instead of reading it into memory.
+type mediaReader struct {
+	*io.SectionReader
+	path string
+}
+
+func (r *mediaReader) FilePath() string {
+	return r.path
+}
+
+var (
+	mediaLock      sync.Mutex
+	mediaReleased  = sync.NewCond(&mediaLock)
+	mediaTransfers int
+	mediaBytes     int64
+)
+
+// mediaAcquire waits until the transfer fits in the limits and returns the bytes reserved for
+// it. Files bigger than the whole limit wait for all other transfers to finish.
+func mediaAcquire(size int64) int64 {
+	var (
+		cfg           = state.State.Config
+		maxTransfers  = cfg.MediaTransfers.MaxConcurrent
+		maxBytes      = cfg.MediaTransfers.MaxInFlightMB * 1024 * 1024
+		reservedBytes = size
+	)
+
+	if maxBytes > 0 {
+		reservedBytes = min(size, maxBytes)
+	}
+
+	mediaLock.Lock()
+	defer mediaLock.Unlock()
+
+	for (maxTransfers > 0 && mediaTransfers >= maxTransfers) ||
+		(maxBytes > 0 && mediaBytes > 0 && mediaBytes+reservedBytes > maxBytes) {
+		mediaReleased.Wait()
+	}
+
+	mediaTransfers++
+	mediaBytes += reservedBytes
+	return reservedBytes
+}
+
+func mediaRelease(reservedBytes int64) {
+	mediaLock.Lock()
+	defer mediaLock.Unlock()
+
+	mediaTransfers--
+	mediaBytes -= reservedBytes
+	mediaReleased.Broadcast()
+}
+
+// MediaTempDir returns the directory the files being transferred are kept in
+func MediaTempDir() (string, error) {
+	tempDir := state.State.Config.MediaTransfers.TempDir
+	return tempDir, os.MkdirAll(tempDir, 0o755)
+}
+
+// NewMediaFile creates an empty temporary file for a transfer of about the given size, waiting
+// until the transfer fits in the limits
+func NewMediaFile(size int64) (*MediaFile, error) {
+	tempDir, err := MediaTempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := mediaAcquire(size)
+
+	file, err := os.CreateTemp(tempDir, "media-*")
+	if err != nil {
+		mediaRelease(reserved)
+		return nil, err
+	}
+
+	return &MediaFile{File: file, Size: size, owned: true, reserved: reserved}, nil
+}
+
+// Close closes the file, removes it if it is a temporary one and frees its share of the limits
+func (f *MediaFile) Close() error {
+	err := f.File.Close()
+	if f.owned {
+		os.Remove(f.Name())
+	}
+	mediaRelease(f.reserved)
+	return err
+}
+
+// Reader returns a new reader over the whole file
+func (f *MediaFile) Reader() io.Reader {
+	return &mediaReader{io.NewSectionReader(f.File, 0, f.Size), f.Name()}
+}
+
+// ReadAll reads the whole file into memory, for the small files which have to be converted
+func (f *MediaFile) ReadAll() ([]byte, error) {
+	return io.ReadAll(f.Reader())
+}
+
+// Mimetype detects the MIME type of the file from its first bytes
+func (f *MediaFile) Mimetype() string {
+	head := make([]byte, 512)
+	n, _ := f.ReadAt(head, 0)
+	return http.DetectContentType(head[:n])
+}
+
+// finish records the size of the written file, so that it can be read back
+func (f *MediaFile) finish() error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	f.Size = info.Size()
+	return nil
+}
+
+// TgDownloadToMediaFile downloads the Telegram file into a temporary file. Files of the local
+// Bot API server are used in place.
+func TgDownloadToMediaFile(b *gotgbot.Bot, fileId string, fileSize int64) (*MediaFile, error) {
+	cfg := state.State.Config
+
+	tgFile, err := b.GetFile(fileId, &gotgbot.GetFileOpts{
+		RequestOpts: &gotgbot.RequestOpts{
+			Timeout: -1,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Telegram.SelfHostedAPI {
+		reserved := mediaAcquire(tgFile.FileSize)
+		localFile, err := os.Open(tgFile.FilePath)
+		if err != nil {
+			mediaRelease(reserved)
+			return nil, err
+		}
+		mediaFile := &MediaFile{File: localFile, reserved: reserved}
+		return mediaFile, mediaFile.finish()
+	}
+
+	mediaFile, err := NewMediaFile(max(fileSize, tgFile.FileSize))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tgDownloadToWriter(b, tgFile.FilePath, mediaFile)
+	if err == nil {
+		err = mediaFile.finish()
+	}
+	if err != nil {
+		mediaFile.Close()
+		return nil, err
+	}
+
+	return mediaFile, nil
+}
+
+func tgDownloadToWriter(b *gotgbot.Bot, filePath string, w io.Writer) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/file/bot%s/%s",
+		state.State.Config.Telegram.ApiUrl, b.Token, filePath), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("received non-200 status code : %s", res.Status)
+	}
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// WaDownloadToMediaFile downloads and decrypts the WhatsApp attachment into a temporary file
+func WaDownloadToMediaFile(account *state.WhatsAppAccount, msg whatsmeow.DownloadableMessage, fileSize int64) (*MediaFile, error) {
+	mediaFile, err := NewMediaFile(fileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	err = account.Client.DownloadToFile(context.Background(), msg, mediaFile.File)
+	if err == nil {
+		err = mediaFile.finish()
+	}
+	if err != nil {
+		mediaFile.Close()
+		return nil, err
+	}
+
+	return mediaFile, nil
+}
+
+// WaUploadMediaFile encrypts and uploads the file to WhatsApp, the encrypted copy is kept in
+// another temporary file meanwhile
+func WaUploadMediaFile(account *state.WhatsAppAccount, mediaFile *MediaFile, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	tempDir, err := MediaTempDir()
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+
+	encryptedFile, err := os.CreateTemp(tempDir, "upload-*")
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	defer func() {
+		encryptedFile.Close()
+		os.Remove(encryptedFile.Name())
+	}()
+
+	return account.Client.UploadReader(context.Background(), mediaFile.Reader(), encryptedFile, mediaType)
+}
+
+// tgUploadToWhatsApp moves the Telegram file to WhatsApp and returns the upload along with the
+// MIME type detected from the file. Failures are returned as retryable WaSendErrors.
+func tgUploadToWhatsApp(b *gotgbot.Bot, account *state.WhatsAppAccount, fileId string, fileSize int64,
+	mediaType whatsmeow.MediaType, noun string) (whatsmeow.UploadResponse, string, error) {
+
+	mediaFile, err := TgDownloadToMediaFile(b, fileId, fileSize)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, "", NewWaSendError(fmt.Sprintf("Failed to download %s from Telegram", noun), err, true)
+	}
+	defer mediaFile.Close()
+
+	uploaded, err := WaUploadMediaFile(account, mediaFile, mediaType)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, "", NewWaSendError(fmt.Sprintf("Failed to upload %s to WhatsApp", noun), err, true)
+	}
+
+	return uploaded, mediaFile.Mimetype(), nil
+}
+
+// mediaSpool copies the file to where the files of the inbound queue are kept until they are
+// delivered, and returns the path of the copy
+func mediaSpool(path string) (string, error) {
+	tempDir, err := MediaTempDir()
+	if err != nil {
+		return "", err
+	}
+
+	spoolDir := filepath.Join(tempDir, "inbound")
+	if err = os.MkdirAll(spoolDir, 0o755); err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(spoolDir, "inbound-*")
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err = io.Copy(dst, src); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
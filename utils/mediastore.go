@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+// MediaStoreSave keeps the file in the media store and returns a signed link to download it
+// along with the time the link expires at
+func MediaStoreSave(mediaFile *MediaFile, fileName string) (string, time.Time, error) {
+	cfg := state.State.Config
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", time.Time{}, err
+	}
+	id := hex.EncodeToString(idBytes)
+	fileName = filepath.Base(fileName)
+
+	storeDir := filepath.Join(cfg.MediaStore.Directory, id)
+	if err := os.MkdirAll(storeDir, 0o755); err != nil {
+		return "", time.Time{}, err
+	}
+
+	storedFile, err := os.Create(filepath.Join(storeDir, fileName))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer storedFile.Close()
+
+	if _, err = io.Copy(storedFile, mediaFile.Reader()); err != nil {
+		os.RemoveAll(storeDir)
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(time.Duration(cfg.MediaStore.LinkExpiryHours) * time.Hour)
+	filePath := id + "/" + url.PathEscape(fileName)
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+
+	link := fmt.Sprintf("%s/media/%s?expires=%s&sig=%s", strings.TrimSuffix(cfg.MediaStore.PublicURL, "/"),
+		filePath, expires, mediaStoreSign(id+"/"+fileName, expires))
+	return link, expiresAt, nil
+}
+
+func mediaStoreSign(filePath, expires string) string {
+	mac := hmac.New(sha256.New, []byte(state.State.Config.MediaStore.Secret))
+	mac.Write([]byte(filePath + "|" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MediaStoreServe serves the stored files to whoever has a link which is valid and not expired
+func MediaStoreServe() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /media/{id}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var (
+			id      = r.PathValue("id")
+			name    = r.PathValue("name")
+			expires = r.URL.Query().Get("expires")
+			sig     = r.URL.Query().Get("sig")
+		)
+
+		expiresAt, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > expiresAt ||
+			subtle.ConstantTimeCompare([]byte(sig), []byte(mediaStoreSign(id+"/"+name, expires))) != 1 {
+			http.Error(w, "This link is invalid or has expired", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(name)))
+		http.ServeFile(w, r, filepath.Join(cfg.MediaStore.Directory, filepath.Base(id), filepath.Base(name)))
+	})
+
+	logger.Info("serving media store",
+		zap.String("listen_address", cfg.MediaStore.ListenAddress),
+	)
+	if err := http.ListenAndServe(cfg.MediaStore.ListenAddress, mux); err != nil {
+		logger.Error("media store server stopped",
+			zap.Error(err),
+		)
+	}
+}
+
+// MediaStoreCleanup removes the stored files whose links have expired
+func MediaStoreCleanup() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		expiry = time.Duration(cfg.MediaStore.LinkExpiryHours) * time.Hour
+	)
+	defer logger.Sync()
+
+	entries, err := os.ReadDir(cfg.MediaStore.Directory)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < expiry {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(cfg.MediaStore.Directory, entry.Name())); err != nil {
+			logger.Warn("failed to remove expired file from media store",
+				zap.String("id", entry.Name()),
+				zap.Error(err),
+			)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/lithammer/fuzzysearch/fuzzy"
+	waTypes "go.mau.fi/whatsmeow/types"
+)
+
+// The most people offered to pick from when a name is ambiguous
+const mentionMaxCandidates = 8
+
+// Names may have dots and underscores inside them, but not at their end where they are punctuation
+var tgNameMentionRegex = regexp.MustCompile(`(^|\s)@([\p{L}\p{N}]+(?:[_.][\p{L}\p{N}]+)*)`)
+
+// MentionAmbiguousError is returned when an @Name mention matches several group members, or
+// only loosely matches one, and the user has to pick one or no one
+type MentionAmbiguousError struct {
+	Name       string
+	Candidates []waTypes.JID
+}
+
+func (e *MentionAmbiguousError) Error() string {
+	return fmt.Sprintf("@%s does not exactly match one member of the group", e.Name)
+}
+
+// waMentionCandidate is a group member along with the names they are known by
+type waMentionCandidate struct {
+	jid   waTypes.JID
+	names []string
+}
+
+// tgResolveNameMentions finds the group members meant by the @Name mentions in the text, the
+// same way /findcontact finds contacts. Names picked by the user before are taken from picked,
+// where an empty JID means the name is no one.
+func tgResolveNameMentions(account *state.WhatsAppAccount, waChatJID waTypes.JID, text string,
+	picked map[string]string) (map[string]waTypes.JID, error) {
+
+	resolved := make(map[string]waTypes.JID)
+	if waChatJID.Server != waTypes.GroupServer {
+		return resolved, nil
+	}
+
+	var names []string
+	for _, match := range tgNameMentionRegex.FindAllStringSubmatch(text, -1) {
+		if name := match[2]; !tgIsNumberMention(name) && !tgIsTagAllMention(name) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return resolved, nil
+	}
+
+	groupInfo, err := account.Client.GetGroupInfo(context.Background(), waChatJID)
+	if err != nil {
+		// Without the members the names are sent as they are
+		return resolved, nil
+	}
+
+	var candidates []waMentionCandidate
+	for _, participant := range groupInfo.Participants {
+		if participant.JID.ToNonAD() == account.Client.Store.ID.ToNonAD() {
+			continue
+		}
+		candidates = append(candidates, waMentionCandidate{
+			jid:   participant.JID,
+			names: waMemberNames(participant),
+		})
+	}
+
+	for _, name := range names {
+		if pickedJid, found := picked[name]; found {
+			if parsedJid, err := waTypes.ParseJID(pickedJid); pickedJid != "" && err == nil {
+				resolved[name] = parsedJid
+			}
+			continue
+		}
+
+		// A loose match is confirmed too, as an @word meant as text would otherwise notify someone
+		matches, exact := waMatchMentionName(name, candidates)
+		if len(matches) == 1 && exact {
+			resolved[name] = matches[0]
+		} else if len(matches) > 0 {
+			return nil, &MentionAmbiguousError{
+				Name:       name,
+				Candidates: matches[:min(len(matches), mentionMaxCandidates)],
+			}
+		}
+	}
+
+	return resolved, nil
+}
+
+// waMemberNames returns the lowercase names which the group member is saved or known by
+func waMemberNames(participant waTypes.GroupParticipant) []string {
+	var names []string
+
+	for _, jid := range []waTypes.JID{participant.PhoneNumber, participant.JID} {
+		if jid.IsEmpty() {
+			continue
+		}
+		firstName, fullName, pushName, businessName, found, err := database.ContactNameGet(jid.User, jid.Server)
+		if err != nil || !found {
+			continue
+		}
+		for _, name := range []string{firstName, fullName, pushName, businessName} {
+			if name != "" {
+				names = append(names, strings.ToLower(name))
+			}
+		}
+		break
+	}
+
+	if participant.DisplayName != "" {
+		names = append(names, strings.ToLower(participant.DisplayName))
+	}
+	return names
+}
+
+// waMatchMentionName returns the members the name stands for and whether they matched exactly. A
+// name which is exactly one of the names of a member, or the first word of one, wins over fuzzy
+// matches.
+func waMatchMentionName(name string, candidates []waMentionCandidate) ([]waTypes.JID, bool) {
+	var exact, fuzzyMatches []waTypes.JID
+	name = strings.ToLower(name)
+
+	for _, candidate := range candidates {
+		isExact, isFuzzy := false, false
+		for _, candidateName := range candidate.names {
+			firstWord, _, _ := strings.Cut(candidateName, " ")
+			if candidateName == name || firstWord == name {
+				isExact = true
+			} else if fuzzy.Match(name, candidateName) {
+				isFuzzy = true
+			}
+		}
+
+		if isExact {
+			exact = append(exact, candidate.jid)
+		} else if isFuzzy {
+			fuzzyMatches = append(fuzzyMatches, candidate.jid)
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact, true
+	}
+	return fuzzyMatches, false
+}
+
+// tgSubstituteMentions writes the resolved @Name mentions as the @number mentions which WhatsApp
+// shows as the names of the members, and returns the JIDs to mention
+func tgSubstituteMentions(text string, resolved map[string]waTypes.JID) (string, []string) {
+	var mentioned []string
+
+	text = tgNameMentionRegex.ReplaceAllStringFunc(text, func(match string) string {
+		prefix, name, _ := strings.Cut(match, "@")
+		jid, found := resolved[name]
+		if !found {
+			return match
+		}
+		if jidString := jid.String(); !slices.Contains(mentioned, jidString) {
+			mentioned = append(mentioned, jidString)
+		}
+		return prefix + "@" + jid.User
+	})
+
+	return text, mentioned
+}
+
+// tgIsNumberMention reports whether the mention is a phone number, which is mentioned as it is
+func tgIsNumberMention(name string) bool {
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// tgIsTagAllMention reports whether the mention is @all or @everyone, which tag the whole group
+// instead of a member
+func tgIsTagAllMention(name string) bool {
+	name = strings.ToLower(name)
+	return name == "all" || name == "everyone"
+}
+
+// TgMakeMentionKeyboard offers the members an ambiguous mention could stand for, the picked one
+// is passed back with the ID of the queued message
+func TgMakeMentionKeyboard(account *state.WhatsAppAccount, outboundId uint, candidates []waTypes.JID) gotgbot.InlineKeyboardMarkup {
+	var keyboard [][]gotgbot.InlineKeyboardButton
+
+	for _, jid := range candidates {
+		keyboard = append(keyboard, []gotgbot.InlineKeyboardButton{{
+			Text:         fmt.Sprintf("%s (%s)", WaGetContactName(account, jid), jid.User),
+			CallbackData: fmt.Sprintf("outmention_%d_%s", outboundId, jid.String()),
+		}})
+	}
+	keyboard = append(keyboard, []gotgbot.InlineKeyboardButton{{
+		Text:         "No one, send the name as text",
+		CallbackData: fmt.Sprintf("outmention_%d_", outboundId),
+	}})
+
+	return gotgbot.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
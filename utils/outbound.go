@@ -0,0 +1,483 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// WaSendError is returned when a message could not be sent to WhatsApp. Retryable errors
+// (disconnections, failed uploads, etc.) keep the message in the outbound queue.
+type WaSendError struct {
+	Message   string
+	Err       error
+	Retryable bool
+}
+
+func NewWaSendError(message string, err error, retryable bool) error {
+	return &WaSendError{
+		Message:   message,
+		Err:       err,
+		Retryable: retryable,
+	}
+}
+
+func (e *WaSendError) Error() string {
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *WaSendError) Unwrap() error {
+	return e.Err
+}
+
+// Messages are handled one at a time per chat, so that a slow one, like a video being
+// converted, only holds back the later messages to its own chat
+var (
+	outboundChatLocks     = make(map[string]*sync.Mutex)
+	outboundChatLocksLock sync.Mutex
+)
+
+func outboundChatLock(tgChatId int64, waChatId string) *sync.Mutex {
+	outboundChatLocksLock.Lock()
+	defer outboundChatLocksLock.Unlock()
+
+	chatKey := fmt.Sprintf("%d:%s", tgChatId, waChatId)
+	if outboundChatLocks[chatKey] == nil {
+		outboundChatLocks[chatKey] = &sync.Mutex{}
+	}
+	return outboundChatLocks[chatKey]
+}
+
+// TgQueueToWhatsApp stores the message in the outbound queue and tries to send it right away.
+// If it cannot be sent, it stays in the queue and is retried with backoff by OutboundProcessQueue.
+// Items of albums are held in the queue until the whole album has arrived, see outboundStartAlbum.
+func TgQueueToWhatsApp(b *gotgbot.Bot, c *ext.Context,
+	msgToForward, msgToReplyTo *gotgbot.Message,
+	waChatJID waTypes.JID, participant, stanzaId string,
+	isReply bool) error {
+
+	updateBytes, err := json.Marshal(c.Update)
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, "Failed to encode the update for the outbound queue", err)
+	}
+
+	msgBytes, err := json.Marshal(msgToForward)
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, "Failed to encode the message for the outbound queue", err)
+	}
+
+	var replyToBytes []byte
+	if msgToReplyTo != nil {
+		replyToBytes, err = json.Marshal(msgToReplyTo)
+		if err != nil {
+			return TgReplyWithErrorByContext(b, c, "Failed to encode the replied message for the outbound queue", err)
+		}
+	}
+
+	// The page is fetched once here, so the retries do not wait on it while holding up the chat
+	var linkPreviewBytes []byte
+	if linkPreview := TgFetchLinkPreview(msgToForward, waChatJID); linkPreview != nil {
+		linkPreviewBytes, _ = json.Marshal(linkPreview)
+	}
+
+	outboundMsg := &database.OutboundMsg{
+		TgChatId:      c.EffectiveChat.Id,
+		TgThreadId:    msgToForward.MessageThreadId,
+		TgMsgId:       msgToForward.MessageId,
+		TgUpdate:      string(updateBytes),
+		TgMessage:     string(msgBytes),
+		TgReplyTo:     string(replyToBytes),
+		TgAlbumId:     msgToForward.MediaGroupId,
+		WaChatId:      waChatJID.String(),
+		ParticipantId: participant,
+		StanzaId:      stanzaId,
+		IsReply:       isReply,
+		LinkPreview:   string(linkPreviewBytes),
+		NextAttemptAt: time.Now(),
+	}
+
+	if outboundMsg.TgAlbumId == "" {
+		if err = database.OutboundMsgAddNew(outboundMsg); err != nil {
+			return TgReplyWithErrorByContext(b, c, "Failed to add the message to the outbound queue", err)
+		}
+
+		OutboundProcessQueue()
+		return nil
+	}
+
+	// Every item of the album holds back the ones before it, later messages to the chat wait
+	// behind them
+	outboundMsg.NextAttemptAt = time.Now().Add(tgAlbumWindow)
+	err = database.OutboundMsgAddNew(outboundMsg)
+	if err == nil {
+		err = database.OutboundMsgHoldAlbum(outboundMsg.TgChatId, outboundMsg.TgAlbumId, outboundMsg.NextAttemptAt)
+	}
+	if err != nil {
+		return TgReplyWithErrorByContext(b, c, "Failed to add the message to the outbound queue", err)
+	}
+
+	time.AfterFunc(tgAlbumWindow, OutboundProcessQueue)
+	return nil
+}
+
+// OutboundProcessQueue sends all the queued messages which are due, each chat on its own. Chats
+// which are busy with a message are left to the run handling them.
+func OutboundProcessQueue() {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	pendingMsgs, err := database.OutboundMsgGetPending()
+	if err != nil {
+		logger.Error("failed to get pending messages from the outbound queue",
+			zap.Error(err),
+		)
+		return
+	}
+
+	seenChats := make(map[string]bool)
+	for _, outboundMsg := range pendingMsgs {
+		chatKey := fmt.Sprintf("%d:%s", outboundMsg.TgChatId, outboundMsg.WaChatId)
+		if seenChats[chatKey] {
+			continue
+		}
+		seenChats[chatKey] = true
+
+		chatLock := outboundChatLock(outboundMsg.TgChatId, outboundMsg.WaChatId)
+		if !chatLock.TryLock() {
+			continue
+		}
+		go func(tgChatId int64, waChatId string) {
+			defer chatLock.Unlock()
+
+			// Messages queued meanwhile are sent by this run too, as the queue skipped the chat
+			for outboundProcessChat(tgChatId, waChatId) {
+			}
+		}(outboundMsg.TgChatId, outboundMsg.WaChatId)
+	}
+}
+
+// outboundProcessChat sends the queued messages to the chat which are due, keeping them in order,
+// so the chat is stopped at as soon as one of its messages has to wait. It returns true if all of
+// the messages were done with, in which case more may have been queued since. The lock of the chat
+// has to be held.
+func outboundProcessChat(tgChatId int64, waChatId string) bool {
+	var (
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	pendingMsgs, err := database.OutboundMsgGetPendingByChat(tgChatId, waChatId)
+	if err != nil {
+		logger.Error("failed to get pending messages from the outbound queue",
+			zap.Int64("tg_chat_id", tgChatId),
+			zap.String("wa_chat_id", waChatId),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	for i := range pendingMsgs {
+		outboundMsg := &pendingMsgs[i]
+
+		// Waiting for the rest of an album is not worth a status
+		if outboundMsg.TgAlbumId != "" &&
+			(outboundMsg.NextAttemptAt.After(time.Now()) || !outboundStartAlbum(pendingMsgs[i:])) {
+			return false
+		}
+
+		if outboundMsg.AwaitingMention != "" || outboundMsg.NextAttemptAt.After(time.Now()) || !outboundAttempt(outboundMsg) {
+			for j := i + 1; j < len(pendingMsgs); j++ {
+				if laterMsg := &pendingMsgs[j]; laterMsg.TgStatusMsgId == 0 {
+					outboundSetStatus(tgBot, laterMsg, "⏳ Queued behind earlier messages to this chat")
+					database.OutboundMsgSave(laterMsg)
+				}
+			}
+			return false
+		}
+	}
+
+	return len(pendingMsgs) > 0
+}
+
+// OutboundUpdateQueued replaces the text and caption of a message which is still waiting in the
+// outbound queue with those of its edited version. It returns false if the message is not queued.
+func OutboundUpdateQueued(editedMsg *gotgbot.Message) (bool, error) {
+	outboundMsg, found, err := database.OutboundMsgGetPendingByTg(editedMsg.Chat.Id, editedMsg.MessageId)
+	if err != nil || !found {
+		return false, err
+	}
+
+	// The message may be being sent, which is waited for
+	chatLock := outboundChatLock(outboundMsg.TgChatId, outboundMsg.WaChatId)
+	chatLock.Lock()
+	defer chatLock.Unlock()
+
+	outboundMsg, found, err = database.OutboundMsgGetPendingByTg(editedMsg.Chat.Id, editedMsg.MessageId)
+	if err != nil || !found {
+		return false, err
+	}
+
+	var queuedMsg gotgbot.Message
+	if err = json.Unmarshal([]byte(outboundMsg.TgMessage), &queuedMsg); err != nil {
+		return false, err
+	}
+
+	queuedMsg.Text = editedMsg.Text
+	queuedMsg.Entities = editedMsg.Entities
+	queuedMsg.Caption = editedMsg.Caption
+	queuedMsg.CaptionEntities = editedMsg.CaptionEntities
+	queuedMsg.EditDate = editedMsg.EditDate
+
+	msgBytes, err := json.Marshal(queuedMsg)
+	if err != nil {
+		return false, err
+	}
+
+	outboundMsg.TgMessage = string(msgBytes)
+	return true, database.OutboundMsgSave(&outboundMsg)
+}
+
+// OutboundPickMention records the member picked for the name the queued message is waiting on,
+// an empty JID meaning no one, and sends the message on
+func OutboundPickMention(id uint, jid string) (bool, error) {
+	outboundMsg, found, err := database.OutboundMsgGet(id)
+	if err != nil || !found {
+		return false, err
+	}
+
+	chatLock := outboundChatLock(outboundMsg.TgChatId, outboundMsg.WaChatId)
+	chatLock.Lock()
+
+	outboundMsg, found, err = database.OutboundMsgGet(id)
+	if err != nil || !found || outboundMsg.AwaitingMention == "" {
+		chatLock.Unlock()
+		return false, err
+	}
+
+	pickedMentions := make(map[string]string)
+	if outboundMsg.Mentions != "" {
+		json.Unmarshal([]byte(outboundMsg.Mentions), &pickedMentions)
+	}
+	pickedMentions[outboundMsg.AwaitingMention] = jid
+
+	mentionsBytes, err := json.Marshal(pickedMentions)
+	if err != nil {
+		chatLock.Unlock()
+		return false, err
+	}
+
+	outboundMsg.Mentions = string(mentionsBytes)
+	outboundMsg.AwaitingMention = ""
+	outboundSetStatus(state.State.TelegramBot, &outboundMsg, "⏳ Sending to WhatsApp")
+	err = database.OutboundMsgSave(&outboundMsg)
+	chatLock.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	OutboundProcessQueue()
+	return true, nil
+}
+
+// OutboundRetry puts a message which was given up on back into the queue, with all its attempts
+func OutboundRetry(id uint) error {
+	// Messages which were given up on are not touched by the queue, so no lock is needed
+	outboundMsg, found, err := database.OutboundMsgGetFailedById(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no unsent message with ID %d", id)
+	}
+
+	outboundMsg.State = "pending"
+	outboundMsg.Attempts = 0
+	outboundMsg.NextAttemptAt = time.Now()
+	outboundSetStatus(state.State.TelegramBot, &outboundMsg, "⏳ Sending to WhatsApp again")
+	if err = database.OutboundMsgSave(&outboundMsg); err != nil {
+		return err
+	}
+
+	OutboundProcessQueue()
+	return nil
+}
+
+// OutboundDiscard drops a message which was given up on, its ❌ status is left as it is
+func OutboundDiscard(id uint) error {
+	_, found, err := database.OutboundMsgGetFailedById(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no unsent message with ID %d", id)
+	}
+
+	return database.OutboundMsgDelete(id)
+}
+
+// outboundAttempt makes one attempt at sending a queued message and returns true if the
+// message is done with, i.e. it was either sent or has permanently failed.
+func outboundAttempt(outboundMsg *database.OutboundMsg) bool {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+
+	var (
+		update       gotgbot.Update
+		msgToForward gotgbot.Message
+		msgToReplyTo *gotgbot.Message
+		linkPreview  *LinkPreview
+	)
+
+	decodeErr := errors.Join(
+		json.Unmarshal([]byte(outboundMsg.TgUpdate), &update),
+		json.Unmarshal([]byte(outboundMsg.TgMessage), &msgToForward),
+	)
+	if outboundMsg.TgReplyTo != "" {
+		msgToReplyTo = &gotgbot.Message{}
+		decodeErr = errors.Join(decodeErr, json.Unmarshal([]byte(outboundMsg.TgReplyTo), msgToReplyTo))
+	}
+	if outboundMsg.LinkPreview != "" {
+		linkPreview = &LinkPreview{}
+		decodeErr = errors.Join(decodeErr, json.Unmarshal([]byte(outboundMsg.LinkPreview), linkPreview))
+	}
+	if decodeErr != nil {
+		logger.Error("failed to decode message from the outbound queue",
+			zap.Uint("id", outboundMsg.ID),
+			zap.Error(decodeErr),
+		)
+		outboundMsg.State = "failed"
+		outboundMsg.LastError = decodeErr.Error()
+		outboundSetStatus(tgBot, outboundMsg, fmt.Sprintf("❌ Failed to decode the queued message:\n\n<code>%s</code>",
+			html.EscapeString(decodeErr.Error())))
+		database.OutboundMsgSave(outboundMsg)
+		return true
+	}
+
+	var (
+		c              = ext.NewContext(tgBot, &update, nil)
+		waChatJID, _   = WaParseJID(outboundMsg.WaChatId)
+		pickedMentions = make(map[string]string)
+	)
+	if outboundMsg.Mentions != "" {
+		json.Unmarshal([]byte(outboundMsg.Mentions), &pickedMentions)
+	}
+
+	err := tgSendToWhatsApp(tgBot, c, &msgToForward, msgToReplyTo, waChatJID,
+		outboundMsg.ParticipantId, outboundMsg.StanzaId, outboundMsg.IsReply, outboundMsg.WaAlbumId, pickedMentions, linkPreview)
+
+	// The message waits, along with the later ones to the chat, until the user picks someone
+	var ambiguousErr *MentionAmbiguousError
+	if errors.As(err, &ambiguousErr) {
+		outboundMsg.AwaitingMention = ambiguousErr.Name
+		keyboard := TgMakeMentionKeyboard(WaAccountByContext(c), outboundMsg.ID, ambiguousErr.Candidates)
+		outboundSetStatusWithKeyboard(tgBot, outboundMsg, fmt.Sprintf("❓ Who did you mean by <b>@%s</b>? It does not exactly match one member of the group, the message is sent once you pick below",
+			html.EscapeString(ambiguousErr.Name)), &keyboard)
+		database.OutboundMsgSave(outboundMsg)
+		return false
+	}
+
+	outboundMsg.Attempts += 1
+
+	var sendErr *WaSendError
+	if !errors.As(err, &sendErr) {
+		// Either the message was sent, or the error has already been reported in the chat
+		if err != nil {
+			logger.Warn("queued message was handled with an error",
+				zap.Uint("id", outboundMsg.ID),
+				zap.Error(err),
+			)
+		}
+		if outboundMsg.TgStatusMsgId != 0 {
+			outboundSetStatus(tgBot, outboundMsg, fmt.Sprintf("✅ Sent to WhatsApp after %d attempts", outboundMsg.Attempts))
+		}
+		database.OutboundMsgDelete(outboundMsg.ID)
+		return true
+	}
+
+	outboundMsg.LastError = sendErr.Error()
+
+	if !sendErr.Retryable || outboundMsg.Attempts >= cfg.Telegram.OutboundQueue.MaxAttempts {
+		logger.Error("giving up on sending queued message to WhatsApp",
+			zap.Uint("id", outboundMsg.ID),
+			zap.Int("attempts", outboundMsg.Attempts),
+			zap.Error(err),
+		)
+		outboundMsg.State = "failed"
+		outboundSetStatus(tgBot, outboundMsg, fmt.Sprintf("❌ %s (after %d attempts):\n\n<code>%s</code>",
+			html.EscapeString(sendErr.Message), outboundMsg.Attempts, html.EscapeString(sendErr.Err.Error())))
+		database.OutboundMsgSave(outboundMsg)
+		return true
+	}
+
+	retryDelay := retryDelay(outboundMsg.Attempts,
+		cfg.Telegram.OutboundQueue.RetryBaseDelaySec, cfg.Telegram.OutboundQueue.RetryMaxDelaySec)
+	outboundMsg.NextAttemptAt = time.Now().Add(retryDelay)
+
+	logger.Info("queued message could not be sent to WhatsApp, will retry",
+		zap.Uint("id", outboundMsg.ID),
+		zap.Int("attempts", outboundMsg.Attempts),
+		zap.Duration("retry_in", retryDelay),
+		zap.Error(err),
+	)
+	outboundSetStatus(tgBot, outboundMsg, fmt.Sprintf("⏳ %s, retrying in %s (attempt %d of %d):\n\n<code>%s</code>",
+		html.EscapeString(sendErr.Message), retryDelay, outboundMsg.Attempts, cfg.Telegram.OutboundQueue.MaxAttempts,
+		html.EscapeString(sendErr.Err.Error())))
+	database.OutboundMsgSave(outboundMsg)
+	return false
+}
+
+// outboundSetStatus sends or edits the reply which shows the state of a queued message
+func outboundSetStatus(b *gotgbot.Bot, outboundMsg *database.OutboundMsg, text string) {
+	outboundSetStatusWithKeyboard(b, outboundMsg, text, nil)
+}
+
+// outboundSetStatusWithKeyboard is outboundSetStatus with buttons under the status, which go
+// away with the next status
+func outboundSetStatusWithKeyboard(b *gotgbot.Bot, outboundMsg *database.OutboundMsg, text string,
+	keyboard *gotgbot.InlineKeyboardMarkup) {
+
+	cfg := state.State.Config
+
+	if outboundMsg.TgStatusMsgId != 0 {
+		editOpts := &gotgbot.EditMessageTextOpts{
+			ChatId:    outboundMsg.TgChatId,
+			MessageId: outboundMsg.TgStatusMsgId,
+		}
+		if keyboard != nil {
+			editOpts.ReplyMarkup = *keyboard
+		}
+		b.EditMessageText(text, editOpts)
+		return
+	}
+
+	sendOpts := &gotgbot.SendMessageOpts{
+		MessageThreadId: outboundMsg.TgThreadId,
+		ReplyParameters: &gotgbot.ReplyParameters{
+			MessageId: outboundMsg.TgMsgId,
+		},
+		DisableNotification: cfg.Telegram.SilentConfirmation,
+	}
+	if keyboard != nil {
+		sendOpts.ReplyMarkup = keyboard
+	}
+
+	statusMsg, err := b.SendMessage(outboundMsg.TgChatId, text, sendOpts)
+	if err == nil {
+		outboundMsg.TgStatusMsgId = statusMsg.MessageId
+	}
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// WaPollOptions returns the names of the options of the poll
+func WaPollOptions(poll *database.WaPoll) []string {
+	var options []string
+	json.Unmarshal([]byte(poll.Options), &options)
+	return options
+}
+
+// WaPollOwnVoterId returns the voter ID which the votes of the account are kept under
+func WaPollOwnVoterId(account *state.WhatsAppAccount) string {
+	return account.Client.Store.ID.ToNonAD().String()
+}
+
+// WaSendPollVote votes in the WhatsApp poll with the given options, replacing the earlier vote
+// of the account. No options take the vote back.
+func WaSendPollVote(account *state.WhatsAppAccount, poll *database.WaPoll, options []string) error {
+	waClient := account.Client
+
+	if !waClient.IsConnected() {
+		return whatsmeow.ErrNotConnected
+	}
+
+	waChatJID, _ := WaParseJID(poll.WaChatId)
+	sender, _ := types.ParseJID(poll.SenderId)
+
+	pollInfo := &types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     waChatJID,
+			Sender:   sender,
+			IsFromMe: sender.User == waClient.Store.ID.User,
+			IsGroup:  waChatJID.Server == types.GroupServer,
+		},
+		ID: poll.WaMsgId,
+	}
+
+	voteMsg, err := waClient.BuildPollVote(context.Background(), pollInfo, options)
+	if err != nil {
+		return err
+	}
+
+	_, err = waClient.SendMessage(context.Background(), waChatJID, voteMsg)
+	return err
+}
+
+// TgRenderWaPoll returns the text showing the poll with the votes so far, and the buttons to vote
+// for each option from Telegram
+func TgRenderWaPoll(account *state.WhatsAppAccount, poll *database.WaPoll, votes []database.WaPollVote) (string, gotgbot.InlineKeyboardMarkup) {
+	var (
+		options  = WaPollOptions(poll)
+		voters   = make([][]string, len(options))
+		ownVote  []string
+		ownVoter = WaPollOwnVoterId(account)
+	)
+
+	for _, vote := range votes {
+		var selected []string
+		json.Unmarshal([]byte(vote.Options), &selected)
+
+		voterName := "You"
+		if vote.VoterId == ownVoter {
+			ownVote = selected
+		} else {
+			voterJID, _ := types.ParseJID(vote.VoterId)
+			voterName = WaGetContactName(account, voterJID)
+		}
+
+		for i, option := range options {
+			if slices.Contains(selected, option) {
+				voters[i] = append(voters[i], voterName)
+			}
+		}
+	}
+
+	var rule string
+	switch poll.SelectableCount {
+	case 0:
+		rule = "Select any number of options"
+	case 1:
+		rule = "Select one option"
+	default:
+		rule = fmt.Sprintf("Select up to %d options", poll.SelectableCount)
+	}
+
+	render := func(withVoters bool) string {
+		text := fmt.Sprintf("%s📊 <b>%s</b>\n<i>%s</i>\n", poll.Header, html.EscapeString(poll.Question), rule)
+		for i, option := range options {
+			text += fmt.Sprintf("\n<b>%s</b> — %d vote(s)\n", html.EscapeString(option), len(voters[i]))
+			if withVoters && len(voters[i]) > 0 {
+				text += "<i>" + html.EscapeString(strings.Join(voters[i], ", ")) + "</i>\n"
+			}
+		}
+		return text
+	}
+
+	text := render(true)
+	if len(text) > 4000 {
+		text = render(false)
+	}
+
+	var keyboard [][]gotgbot.InlineKeyboardButton
+	for i, option := range options {
+		buttonText := fmt.Sprintf("%s (%d)", option, len(voters[i]))
+		if slices.Contains(ownVote, option) {
+			buttonText = "✅ " + buttonText
+		}
+		keyboard = append(keyboard, []gotgbot.InlineKeyboardButton{{
+			Text:         buttonText,
+			CallbackData: fmt.Sprintf("wapoll_%d", i),
+		}})
+	}
+
+	return text, gotgbot.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// TgUpdateWaPoll shows the current votes of the poll on its Telegram message
+func TgUpdateWaPoll(account *state.WhatsAppAccount, poll *database.WaPoll) error {
+	tgBot := state.State.TelegramBot
+
+	tgChatId, _, tgMsgId, err := database.MsgIdGetTgFromWa(account.Name, poll.WaMsgId, poll.WaChatId)
+	if err != nil || tgMsgId == 0 {
+		return err
+	}
+
+	votes, err := database.WaPollVoteGetAll(account.Name, poll.WaMsgId, poll.WaChatId)
+	if err != nil {
+		return err
+	}
+
+	text, keyboard := TgRenderWaPoll(account, poll, votes)
+	_, _, err = tgBot.EditMessageText(text, &gotgbot.EditMessageTextOpts{
+		ChatId:      tgChatId,
+		MessageId:   tgMsgId,
+		ReplyMarkup: keyboard,
+	})
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// TgSetWaReactions sets the most used reaction of the WhatsApp message on its Telegram copy, or
+// removes the reaction if nobody reacts anymore. Bots can set only one reaction and only from
+// the ones Telegram allows, so less used reactions are tried when the top one is not allowed.
+// It returns false if none of the reactions could be set.
+func TgSetWaReactions(account *state.WhatsAppAccount, waMsgId, waChatId string, tgChatId, tgMsgId int64) bool {
+	var (
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	reactions, err := database.WaReactionGetAll(account.Name, waMsgId, waChatId)
+	if err != nil {
+		logger.Error("failed to get reactions from database",
+			zap.String("msg_id", waMsgId),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	emojis := tgRankReactions(reactions)
+	if len(emojis) == 0 {
+		_, err = tgBot.SetMessageReaction(tgChatId, tgMsgId, &gotgbot.SetMessageReactionOpts{
+			Reaction: []gotgbot.ReactionType{},
+		})
+		return err == nil
+	}
+
+	for _, emoji := range emojis {
+		_, err = tgBot.SetMessageReaction(tgChatId, tgMsgId, &gotgbot.SetMessageReactionOpts{
+			Reaction: []gotgbot.ReactionType{gotgbot.ReactionTypeEmoji{Emoji: emoji}},
+		})
+		if err == nil {
+			return true
+		} else if !strings.Contains(err.Error(), "REACTION_INVALID") {
+			break
+		}
+	}
+
+	logger.Warn("failed to set reaction on bridged message",
+		zap.String("msg_id", waMsgId),
+		zap.Int64("tg_msg_id", tgMsgId),
+		zap.Error(err),
+	)
+	return false
+}
+
+// tgRankReactions returns the reactions as Telegram emojis, the most used first and the most
+// recent first among equally used ones
+func tgRankReactions(reactions []database.WaReaction) []string {
+	var (
+		emojis []string
+		counts = make(map[string]int)
+		latest = make(map[string]int)
+	)
+
+	for i, reaction := range reactions {
+		emoji := tgReactionEmoji(reaction.Emoji)
+		if counts[emoji] == 0 {
+			emojis = append(emojis, emoji)
+		}
+		counts[emoji]++
+		latest[emoji] = i
+	}
+
+	slices.SortFunc(emojis, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return latest[b] - latest[a]
+	})
+	return emojis
+}
+
+// tgReactionEmoji drops the variation selectors and skin tones which WhatsApp reactions can
+// have, as Telegram only allows the plain emojis
+func tgReactionEmoji(emoji string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\ufe0f' || (r >= 0x1f3fb && r <= 0x1f3ff) {
+			return -1
+		}
+		return r
+	}, emoji)
+}
+
+// WaSendReaction reacts to the WhatsApp message with the emoji, an empty emoji removes the
+// reaction. The participant is the sender of the message reacted to.
+func WaSendReaction(account *state.WhatsAppAccount, chat types.JID, waMsgId, participantId, emoji string) error {
+	waClient := account.Client
+
+	if !waClient.IsConnected() {
+		return whatsmeow.ErrNotConnected
+	}
+
+	// Telegram has the plain symbols, which WhatsApp would show as text
+	if r, size := utf8.DecodeRuneInString(emoji); emoji != "" && size == len(emoji) && r < 0x10000 {
+		emoji += "\ufe0f"
+	}
+
+	participant, _ := types.ParseJID(participantId)
+	key := &waCommon.MessageKey{
+		RemoteJID: proto.String(chat.String()),
+		FromMe:    proto.Bool(participant.User == waClient.Store.ID.User),
+		ID:        proto.String(waMsgId),
+	}
+	if chat.Server == types.GroupServer && !key.GetFromMe() {
+		key.Participant = proto.String(participant.ToNonAD().String())
+	}
+
+	_, err := waClient.SendMessage(context.Background(), chat, &waE2E.Message{
+		ReactionMessage: &waE2E.ReactionMessage{
+			Key:               key,
+			Text:              proto.String(emoji),
+			SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
+		},
+	})
+	return err
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// Telegram chat chosen for each WhatsApp chat, keyed by "account|jid"
+var routeCache sync.Map
+
+// TgTargetChatForWa returns the Telegram chat which the WhatsApp chat is bridged to, that is the
+// target of the first matching route or the target chat of the account if no route matches
+func TgTargetChatForWa(account *state.WhatsAppAccount, waChat types.JID) int64 {
+	routes := state.State.Config.Telegram.Routes
+	if len(routes) == 0 {
+		return account.TargetChatID
+	}
+
+	waChat = waChat.ToNonAD()
+	cacheKey := account.Name + "|" + waChat.String()
+	if targetChatId, found := routeCache.Load(cacheKey); found {
+		return targetChatId.(int64)
+	}
+
+	targetChatId := account.TargetChatID
+	for i := range routes {
+		if tgRouteMatches(&routes[i], account, waChat) {
+			targetChatId = routes[i].TargetChatID
+			break
+		}
+	}
+
+	routeCache.Store(cacheKey, targetChatId)
+	return targetChatId
+}
+
+// TgForgetRoute drops the cached route of a WhatsApp chat, so that the rules are matched again
+// after its name or labels changed
+func TgForgetRoute(account *state.WhatsAppAccount, waChat types.JID) {
+	routeCache.Delete(account.Name + "|" + waChat.ToNonAD().String())
+}
+
+// TgForgetRoutes drops the cached routes of all the chats of the account
+func TgForgetRoutes(account *state.WhatsAppAccount) {
+	routeCache.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), account.Name+"|") {
+			routeCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// TgChatIsBridged reports whether messages in the Telegram chat should be sent to WhatsApp
+func TgChatIsBridged(tgChatId int64) bool {
+	if WaAccountByTgChat(tgChatId) != nil {
+		return true
+	}
+	return slices.ContainsFunc(state.State.Config.Telegram.Routes, func(route state.TelegramRouteConfig) bool {
+		return route.TargetChatID == tgChatId
+	})
+}
+
+func tgRouteMatches(route *state.TelegramRouteConfig, account *state.WhatsAppAccount, waChat types.JID) bool {
+	isGroup := waChat.Server == types.GroupServer
+
+	if len(route.Accounts) > 0 && !slices.Contains(route.Accounts, account.Name) {
+		return false
+	}
+
+	if (route.ChatType == "group" && !isGroup) || (route.ChatType == "private" && isGroup) {
+		return false
+	}
+
+	if len(route.JIDs) > 0 {
+		chatIds := []string{waChat.User, waChat.String()}
+		if waChat.Server == types.HiddenUserServer {
+			if pn, err := account.Client.Store.LIDs.GetPNForLID(context.Background(), waChat); err == nil && !pn.IsEmpty() {
+				chatIds = append(chatIds, pn.User, pn.String())
+			}
+		}
+		if !slices.ContainsFunc(route.JIDs, func(jid string) bool {
+			return slices.Contains(chatIds, strings.TrimPrefix(jid, "+"))
+		}) {
+			return false
+		}
+	}
+
+	if len(route.Labels) > 0 {
+		labels, err := database.WaChatLabelGetNames(account.Name, waChat.String())
+		if err != nil {
+			state.State.Logger.Error("failed to get labels of chat for routing",
+				zap.String("chat_jid", waChat.String()),
+				zap.Error(err),
+			)
+			return false
+		}
+		if !slices.ContainsFunc(route.Labels, func(label string) bool {
+			return slices.ContainsFunc(labels, func(chatLabel string) bool {
+				return strings.EqualFold(label, chatLabel)
+			})
+		}) {
+			return false
+		}
+	}
+
+	if route.NameRegexp != nil {
+		var name string
+		if isGroup {
+			name = WaGetGroupName(account, waChat)
+		} else {
+			name = WaGetContactName(account, waChat)
+		}
+		if !route.NameRegexp.MatchString(name) {
+			return false
+		}
+	}
+
+	return true
+}
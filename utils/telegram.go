@@ -3,11 +3,10 @@ package utils
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
-	"io"
-	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -19,9 +18,7 @@ import (
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 	goVCard "github.com/emersion/go-vcard"
-	"github.com/forPelevin/gomoji"
 	"go.mau.fi/whatsmeow"
-	"go.mau.fi/whatsmeow/proto/waCommon"
 	"go.mau.fi/whatsmeow/proto/waE2E"
 	waTypes "go.mau.fi/whatsmeow/types"
 	"go.uber.org/zap"
@@ -29,10 +26,8 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-const (
-	DownloadSizeLimit int64  = 20971520
-	UploadSizeLimit   uint64 = 52428800
-)
+// DownloadSizeLimit is the size up to which the Bot API lets bots download files
+const DownloadSizeLimit int64 = 20971520
 
 func TgRegisterBotCommands(ownerId int64, skipMessage bool, b *gotgbot.Bot, commands ...gotgbot.BotCommand) error {
 	hasCommands := len(commands) > 0
@@ -58,8 +53,8 @@ func TgRegisterBotCommands(ownerId int64, skipMessage bool, b *gotgbot.Bot, comm
 	return errors.Join(err, sendErr)
 }
 
-func TgGetOrMakeThreadFromWa_String(waChatIdString string, tgChatId int64, threadName string) (int64, bool, error) {
-	threadId, threadFound, err := database.ChatThreadGetTgFromWa(waChatIdString, tgChatId)
+func TgGetOrMakeThreadFromWa_String(account *state.WhatsAppAccount, waChatIdString string, tgChatId int64, threadName string) (int64, bool, error) {
+	threadId, threadFound, err := database.ChatThreadGetTgFromWa(account.Name, waChatIdString, tgChatId)
 	if err != nil {
 		return 0, threadFound, err
 	}
@@ -70,7 +65,7 @@ func TgGetOrMakeThreadFromWa_String(waChatIdString string, tgChatId int64, threa
 		if err != nil {
 			return 0, threadFound, err
 		}
-		err = database.ChatThreadAddNewPair(waChatIdString, tgChatId, newForum.MessageThreadId)
+		err = database.ChatThreadAddNewPair(account.Name, waChatIdString, tgChatId, newForum.MessageThreadId)
 		if err != nil {
 			return newForum.MessageThreadId, threadFound, err
 		}
@@ -80,17 +75,16 @@ func TgGetOrMakeThreadFromWa_String(waChatIdString string, tgChatId int64, threa
 	return threadId, threadFound, nil
 }
 
-func TgGetOrMakeThreadFromWa(waChatId waTypes.JID, tgChatId int64, threadName string) (int64, bool, error) {
+func TgGetOrMakeThreadFromWa(account *state.WhatsAppAccount, waChatId waTypes.JID, tgChatId int64, threadName string) (int64, bool, error) {
 	if waChatId.Server == waTypes.HiddenUserServer {
-		waClient := state.State.WhatsAppClient
-		pn, err := waClient.Store.LIDs.GetPNForLID(context.Background(), waChatId)
+		pn, err := account.Client.Store.LIDs.GetPNForLID(context.Background(), waChatId)
 		if err != nil {
 			return 0, false, err
 		}
 		waChatId = pn
 	}
 	waChatIdString := waChatId.ToNonAD().String()
-	return TgGetOrMakeThreadFromWa_String(waChatIdString, tgChatId, threadName)
+	return TgGetOrMakeThreadFromWa_String(account, waChatIdString, tgChatId, threadName)
 }
 
 func TgDownloadByFilePath(b *gotgbot.Bot, filePath string) ([]byte, error) {
@@ -98,27 +92,11 @@ func TgDownloadByFilePath(b *gotgbot.Bot, filePath string) ([]byte, error) {
 		return os.ReadFile(filePath)
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/file/bot%s/%s",
-		state.State.Config.Telegram.ApiUrl, b.Token, filePath), nil)
-	if err != nil {
+	var fileBytes bytes.Buffer
+	if err := tgDownloadToWriter(b, filePath, &fileBytes); err != nil {
 		return nil, err
 	}
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("received non-200 status code : %s", res.Status)
-	}
-
-	bodyBytes, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	return bodyBytes, nil
+	return fileBytes.Bytes(), nil
 }
 
 func TgReplyTextByContext(b *gotgbot.Bot, c *ext.Context, text string, buttons *gotgbot.InlineKeyboardMarkup, silent bool) (*gotgbot.Message, error) {
@@ -211,35 +189,75 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 	waChatJID waTypes.JID, participant, stanzaId string,
 	isReply bool) error {
 
+	err := tgSendToWhatsApp(b, c, msgToForward, msgToReplyTo, waChatJID, participant, stanzaId, isReply, "", nil,
+		TgFetchLinkPreview(msgToForward, waChatJID))
+
 	var (
-		cfg      = state.State.Config
-		logger   = state.State.Logger
-		waClient = state.State.WhatsAppClient
-		mentions = []string{}
+		sendErr      *WaSendError
+		ambiguousErr *MentionAmbiguousError
 	)
+	if errors.As(err, &sendErr) {
+		return TgReplyWithErrorByContext(b, c, sendErr.Message, sendErr.Err)
+	} else if errors.As(err, &ambiguousErr) {
+		_, err = TgReplyTextByContext(b, c, fmt.Sprintf("Could not tell who @%s is as it does not exactly match one member of the group, mention them by their number instead",
+			html.EscapeString(ambiguousErr.Name)), nil, false)
+	}
+	return err
+}
+
+// tgMessageMentions returns the JIDs of the WhatsApp users mentioned in the text or caption
+func tgMessageMentions(msg *gotgbot.Message) []string {
+	mentions := []string{}
 
 	var entities []gotgbot.ParsedMessageEntity
-	if len(msgToForward.Entities) > 0 {
-		entities = msgToForward.ParseEntities()
-	} else if len(msgToForward.CaptionEntities) > 0 {
-		entities = msgToForward.ParseCaptionEntities()
+	if len(msg.Entities) > 0 {
+		entities = msg.ParseEntities()
+	} else if len(msg.CaptionEntities) > 0 {
+		entities = msg.ParseCaptionEntities()
 	}
 
 	for _, entity := range entities {
-		if entity.Type == "mention" {
-			username := entity.Text[1:]
-			// Check if its a number
-			for _, c := range username {
-				if !unicode.IsDigit(c) {
-					continue
-				}
-			}
-
-			parsedJID, _ := WaParseJID(username)
+		// Usernames which are not phone numbers are @Name mentions, see tgResolveNameMentions
+		if entity.Type == "mention" && tgIsNumberMention(entity.Text[1:]) {
+			parsedJID, _ := WaParseJID(entity.Text[1:])
 			mentions = append(mentions, parsedJID.String())
 		}
 	}
 
+	return mentions
+}
+
+func tgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
+	msgToForward, msgToReplyTo *gotgbot.Message,
+	waChatJID waTypes.JID, participant, stanzaId string,
+	isReply bool, waAlbumId string, pickedMentions map[string]string, linkPreview *LinkPreview) error {
+
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		account  = WaAccountByContext(c)
+		waClient = account.Client
+		mentions []string
+	)
+
+	if !waClient.IsConnected() {
+		return NewWaSendError("WhatsApp is not connected", whatsmeow.ErrNotConnected, true)
+	}
+
+	mentions = tgMessageMentions(msgToForward)
+
+	resolvedMentions, err := tgResolveNameMentions(account, waChatJID, msgToForward.Text+"\n"+msgToForward.Caption, pickedMentions)
+	if err != nil {
+		return err
+	}
+	text, textMentions := tgSubstituteMentions(TgTextWaMarkup(msgToForward), resolvedMentions)
+	caption, captionMentions := tgSubstituteMentions(TgCaptionWaMarkup(msgToForward), resolvedMentions)
+	for _, jid := range append(textMentions, captionMentions...) {
+		if !slices.Contains(mentions, jid) {
+			mentions = append(mentions, jid)
+		}
+	}
+
 	if cfg.Telegram.SendMyPresenceOnReply {
 		err := waClient.SendPresence(context.Background(), waTypes.PresenceAvailable)
 		if err != nil {
@@ -306,36 +324,22 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			return err
 		}
 
-		imageFile, err := b.GetFile(bestPhoto.FileId, &gotgbot.GetFileOpts{
-			RequestOpts: &gotgbot.RequestOpts{
-				Timeout: -1,
-			},
-		})
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive image file from Telegram", err)
-		}
-
-		imageBytes, err := TgDownloadByFilePath(b, imageFile.FilePath)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download image from Telegram", err)
-		}
-
-		uploadedImage, err := waClient.Upload(context.Background(), imageBytes, whatsmeow.MediaImage)
+		uploadedImage, imageMimetype, err := tgUploadToWhatsApp(b, account, bestPhoto.FileId, bestPhoto.FileSize, whatsmeow.MediaImage, "image")
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload image to WhatsApp", err)
+			return err
 		}
 
 		msgToSend := &waE2E.Message{
 			ImageMessage: &waE2E.ImageMessage{
-				Caption:           proto.String(msgToForward.Caption),
+				Caption:           proto.String(caption),
 				URL:               proto.String(uploadedImage.URL),
 				DirectPath:        proto.String(uploadedImage.DirectPath),
 				MediaKey:          uploadedImage.MediaKey,
 				MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
-				Mimetype:          proto.String(http.DetectContentType(imageBytes)),
+				Mimetype:          proto.String(imageMimetype),
 				FileEncSHA256:     uploadedImage.FileEncSHA256,
 				FileSHA256:        uploadedImage.FileSHA256,
-				FileLength:        proto.Uint64(uint64(len(imageBytes))),
+				FileLength:        proto.Uint64(uploadedImage.FileLength),
 				ViewOnce:          proto.Bool(msgToForward.HasProtectedContent || (msgToForward.HasMediaSpoiler && cfg.Telegram.SpoilerViewOnce)),
 				Height:            proto.Uint32(uint32(bestPhoto.Height)),
 				Width:             proto.Uint32(uint32(bestPhoto.Width)),
@@ -353,16 +357,19 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		if isEphemeral {
 			msgToSend.ImageMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
+		if waAlbumId != "" {
+			msgToSend.MessageContextInfo = waAlbumItemContextInfo(waChatJID, waAlbumId)
+		}
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send image to WhatsApp", err)
+			return NewWaSendError("Failed to send image to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -374,35 +381,37 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			return err
 		}
 
-		videoFile, err := b.GetFile(msgToForward.Video.FileId, &gotgbot.GetFileOpts{
-			RequestOpts: &gotgbot.RequestOpts{
-				Timeout: -1,
-			},
-		})
+		videoFile, err := TgDownloadToMediaFile(b, msgToForward.Video.FileId, msgToForward.Video.FileSize)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive video file from Telegram", err)
+			return NewWaSendError("Failed to download video from Telegram", err, true)
 		}
+		defer videoFile.Close()
 
-		videoBytes, err := TgDownloadByFilePath(b, videoFile.FilePath)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download video from Telegram", err)
+		videoMimetype := msgToForward.Video.MimeType
+		if cfg.VideoTranscoding.Enabled {
+			transcoded, err := tgTranscodeForWhatsApp(c, msgToForward, videoFile)
+			if err != nil {
+				return NewWaSendError("Failed to convert video for WhatsApp", err, false)
+			} else if transcoded {
+				videoMimetype = "video/mp4"
+			}
 		}
 
-		uploadedVideo, err := waClient.Upload(context.Background(), videoBytes, whatsmeow.MediaVideo)
+		uploadedVideo, err := WaUploadMediaFile(account, videoFile, whatsmeow.MediaVideo)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload video to WhatsApp", err)
+			return NewWaSendError("Failed to upload video to WhatsApp", err, true)
 		}
 
 		msgToSend := &waE2E.Message{
 			VideoMessage: &waE2E.VideoMessage{
-				Caption:       proto.String(msgToForward.Caption),
+				Caption:       proto.String(caption),
 				URL:           proto.String(uploadedVideo.URL),
 				DirectPath:    proto.String(uploadedVideo.DirectPath),
 				MediaKey:      uploadedVideo.MediaKey,
-				Mimetype:      proto.String(msgToForward.Video.MimeType),
+				Mimetype:      proto.String(videoMimetype),
 				FileEncSHA256: uploadedVideo.FileEncSHA256,
 				FileSHA256:    uploadedVideo.FileSHA256,
-				FileLength:    proto.Uint64(uint64(len(videoBytes))),
+				FileLength:    proto.Uint64(uploadedVideo.FileLength),
 				ViewOnce:      proto.Bool(msgToForward.HasProtectedContent || (msgToForward.HasMediaSpoiler && cfg.Telegram.SpoilerViewOnce)),
 				Seconds:       proto.Uint32(uint32(msgToForward.Video.Duration)),
 				GifPlayback:   proto.Bool(false),
@@ -422,16 +431,19 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		if isEphemeral {
 			msgToSend.VideoMessage.ContextInfo.Expiration = &ephemeralTimer
 		}
+		if waAlbumId != "" {
+			msgToSend.MessageContextInfo = waAlbumItemContextInfo(waChatJID, waAlbumId)
+		}
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send video to WhatsApp", err)
+			return NewWaSendError("Failed to send video to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -442,35 +454,21 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			return err
 		}
 
-		videoFile, err := b.GetFile(msgToForward.VideoNote.FileId, &gotgbot.GetFileOpts{
-			RequestOpts: &gotgbot.RequestOpts{
-				Timeout: -1,
-			},
-		})
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive video note file from Telegram", err)
-		}
-
-		videoBytes, err := TgDownloadByFilePath(b, videoFile.FilePath)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download video note from Telegram", err)
-		}
-
-		uploadedVideo, err := waClient.Upload(context.Background(), videoBytes, whatsmeow.MediaVideo)
+		uploadedVideo, videoMimetype, err := tgUploadToWhatsApp(b, account, msgToForward.VideoNote.FileId, msgToForward.VideoNote.FileSize, whatsmeow.MediaVideo, "video note")
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload video note to WhatsApp", err)
+			return err
 		}
 
 		msgToSend := &waE2E.Message{
 			PtvMessage: &waE2E.VideoMessage{
-				Caption:       proto.String(msgToForward.Caption),
+				Caption:       proto.String(caption),
 				URL:           proto.String(uploadedVideo.URL),
 				DirectPath:    proto.String(uploadedVideo.DirectPath),
 				MediaKey:      uploadedVideo.MediaKey,
-				Mimetype:      proto.String(http.DetectContentType(videoBytes)),
+				Mimetype:      proto.String(videoMimetype),
 				FileEncSHA256: uploadedVideo.FileEncSHA256,
 				FileSHA256:    uploadedVideo.FileSHA256,
-				FileLength:    proto.Uint64(uint64(len(videoBytes))),
+				FileLength:    proto.Uint64(uploadedVideo.FileLength),
 				ViewOnce:      proto.Bool(msgToForward.HasProtectedContent || (msgToForward.HasMediaSpoiler && cfg.Telegram.SpoilerViewOnce)),
 				Seconds:       proto.Uint32(uint32(msgToForward.VideoNote.Duration)),
 				GifPlayback:   proto.Bool(false),
@@ -491,13 +489,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send video note to WhatsApp", err)
+			return NewWaSendError("Failed to send video note to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -508,28 +506,14 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			return err
 		}
 
-		animationFile, err := b.GetFile(msgToForward.Animation.FileId, &gotgbot.GetFileOpts{
-			RequestOpts: &gotgbot.RequestOpts{
-				Timeout: -1,
-			},
-		})
+		uploadedAnimation, _, err := tgUploadToWhatsApp(b, account, msgToForward.Animation.FileId, msgToForward.Animation.FileSize, whatsmeow.MediaVideo, "animation")
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive animation file from Telegram", err)
-		}
-
-		animationBytes, err := TgDownloadByFilePath(b, animationFile.FilePath)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download animation from Telegram", err)
-		}
-
-		uploadedAnimation, err := waClient.Upload(context.Background(), animationBytes, whatsmeow.MediaVideo)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload animation to WhatsApp", err)
+			return err
 		}
 
 		msgToSend := &waE2E.Message{
 			VideoMessage: &waE2E.VideoMessage{
-				Caption:        proto.String(msgToForward.Caption),
+				Caption:        proto.String(caption),
 				URL:            proto.String(uploadedAnimation.URL),
 				DirectPath:     proto.String(uploadedAnimation.DirectPath),
 				MediaKey:       uploadedAnimation.MediaKey,
@@ -537,7 +521,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				GifPlayback:    proto.Bool(true),
 				FileEncSHA256:  uploadedAnimation.FileEncSHA256,
 				FileSHA256:     uploadedAnimation.FileSHA256,
-				FileLength:     proto.Uint64(uint64(len(animationBytes))),
+				FileLength:     proto.Uint64(uploadedAnimation.FileLength),
 				ViewOnce:       proto.Bool(msgToForward.HasProtectedContent || (msgToForward.HasMediaSpoiler && cfg.Telegram.SpoilerViewOnce)),
 				Height:         proto.Uint32(uint32(msgToForward.Animation.Height)),
 				Width:          proto.Uint32(uint32(msgToForward.Animation.Width)),
@@ -560,13 +544,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send animation to WhatsApp", err)
+			return NewWaSendError("Failed to send animation to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -577,23 +561,9 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			return err
 		}
 
-		audioFile, err := b.GetFile(msgToForward.Audio.FileId, &gotgbot.GetFileOpts{
-			RequestOpts: &gotgbot.RequestOpts{
-				Timeout: -1,
-			},
-		})
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive audio file from Telegram", err)
-		}
-
-		audioBytes, err := TgDownloadByFilePath(b, audioFile.FilePath)
+		uploadedAudio, _, err := tgUploadToWhatsApp(b, account, msgToForward.Audio.FileId, msgToForward.Audio.FileSize, whatsmeow.MediaAudio, "audio")
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download audio from Telegram", err)
-		}
-
-		uploadedAudio, err := waClient.Upload(context.Background(), audioBytes, whatsmeow.MediaAudio)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload audio to WhatsApp", err)
+			return err
 		}
 
 		msgToSend := &waE2E.Message{
@@ -604,7 +574,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				Mimetype:      proto.String(msgToForward.Audio.MimeType),
 				FileEncSHA256: uploadedAudio.FileEncSHA256,
 				FileSHA256:    uploadedAudio.FileSHA256,
-				FileLength:    proto.Uint64(uint64(len(audioBytes))),
+				FileLength:    proto.Uint64(uploadedAudio.FileLength),
 				Seconds:       proto.Uint32(uint32(msgToForward.Audio.Duration)),
 				PTT:           proto.Bool(false),
 				ContextInfo:   &waE2E.ContextInfo{},
@@ -624,13 +594,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send audio to WhatsApp", err)
+			return NewWaSendError("Failed to send audio to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -641,23 +611,17 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			return err
 		}
 
-		voiceFile, err := b.GetFile(msgToForward.Voice.FileId, &gotgbot.GetFileOpts{
-			RequestOpts: &gotgbot.RequestOpts{
-				Timeout: -1,
-			},
-		})
+		voiceFile, err := TgDownloadToMediaFile(b, msgToForward.Voice.FileId, msgToForward.Voice.FileSize)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive voice file from Telegram", err)
+			return NewWaSendError("Failed to download voice from Telegram", err, true)
 		}
+		defer voiceFile.Close()
 
-		voiceBytes, err := TgDownloadByFilePath(b, voiceFile.FilePath)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download voice from Telegram", err)
-		}
+		waveform := VoiceForWhatsApp(voiceFile)
 
-		uploadedVoice, err := waClient.Upload(context.Background(), voiceBytes, whatsmeow.MediaAudio)
+		uploadedVoice, err := WaUploadMediaFile(account, voiceFile, whatsmeow.MediaAudio)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload voice to WhatsApp", err)
+			return NewWaSendError("Failed to upload voice to WhatsApp", err, true)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -665,12 +629,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				URL:           proto.String(uploadedVoice.URL),
 				DirectPath:    proto.String(uploadedVoice.DirectPath),
 				MediaKey:      uploadedVoice.MediaKey,
-				Mimetype:      proto.String("audio/ogg; codecs=opus"),
+				Mimetype:      proto.String(VoiceMimetype),
 				FileEncSHA256: uploadedVoice.FileEncSHA256,
 				FileSHA256:    uploadedVoice.FileSHA256,
-				FileLength:    proto.Uint64(uint64(len(voiceBytes))),
+				FileLength:    proto.Uint64(uploadedVoice.FileLength),
 				Seconds:       proto.Uint32(uint32(msgToForward.Voice.Duration)),
 				PTT:           proto.Bool(true),
+				Waveform:      waveform,
 				ContextInfo:   &waE2E.ContextInfo{},
 			},
 		}
@@ -688,13 +653,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send voice to WhatsApp", err)
+			return NewWaSendError("Failed to send voice to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -705,28 +670,14 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			return err
 		}
 
-		documentFile, err := b.GetFile(msgToForward.Document.FileId, &gotgbot.GetFileOpts{
-			RequestOpts: &gotgbot.RequestOpts{
-				Timeout: -1,
-			},
-		})
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive document file from Telegram", err)
-		}
-
-		documentBytes, err := TgDownloadByFilePath(b, documentFile.FilePath)
+		uploadedDocument, _, err := tgUploadToWhatsApp(b, account, msgToForward.Document.FileId, msgToForward.Document.FileSize, whatsmeow.MediaDocument, "document")
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download document from Telegram", err)
-		}
-
-		uploadedDocument, err := waClient.Upload(context.Background(), documentBytes, whatsmeow.MediaDocument)
-		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload document to WhatsApp", err)
+			return err
 		}
 
 		msgToSend := &waE2E.Message{
 			DocumentMessage: &waE2E.DocumentMessage{
-				Caption:       proto.String(msgToForward.Caption),
+				Caption:       proto.String(caption),
 				Title:         proto.String(msgToForward.Document.FileName),
 				FileName:      proto.String(msgToForward.Document.FileName),
 				URL:           proto.String(uploadedDocument.URL),
@@ -735,7 +686,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				Mimetype:      proto.String(msgToForward.Document.MimeType),
 				FileEncSHA256: uploadedDocument.FileEncSHA256,
 				FileSHA256:    uploadedDocument.FileSHA256,
-				FileLength:    proto.Uint64(uint64(len(documentBytes))),
+				FileLength:    proto.Uint64(uploadedDocument.FileLength),
 				ContextInfo:   &waE2E.ContextInfo{},
 			},
 		}
@@ -753,13 +704,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send document to WhatsApp", err)
+			return NewWaSendError("Failed to send document to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -776,18 +727,18 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 			},
 		})
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to retreive sticker file from Telegram", err)
+			return NewWaSendError("Failed to retreive sticker file from Telegram", err, true)
 		}
 
 		stickerBytes, err := TgDownloadByFilePath(b, stickerFile.FilePath)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to download sticker from Telegram", err)
+			return NewWaSendError("Failed to download sticker from Telegram", err, true)
 		}
 
 		if msgToForward.Sticker.IsAnimated {
 			stickerBytes, err = TGSConvertToWebp(stickerBytes, c.UpdateId)
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to convert TGS sticker to WebP", err)
+				return NewWaSendError("Failed to convert TGS sticker to WebP", err, false)
 			}
 		} else if msgToForward.Sticker.IsVideo && !cfg.Telegram.SkipVideoStickers {
 
@@ -806,7 +757,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 			stickerBytes, err = WebmConvertToWebp(stickerBytes, scale, pad, c.UpdateId)
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to convert WEBM sticker to GIF", err)
+				return NewWaSendError("Failed to convert WEBM sticker to GIF", err, false)
 			}
 		} else if !msgToForward.Sticker.IsAnimated || !msgToForward.Sticker.IsVideo {
 
@@ -821,13 +772,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 			stickerBytes, err = WebpImagePad(stickerBytes, wPad, hPad, c.UpdateId)
 			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to pad WEBP sticker to 512x512", err)
+				return NewWaSendError("Failed to pad WEBP sticker to 512x512", err, false)
 			}
 		}
 
 		uploadedSticker, err := waClient.Upload(context.Background(), stickerBytes, whatsmeow.MediaImage)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to upload sticker to WhatsApp", err)
+			return NewWaSendError("Failed to upload sticker to WhatsApp", err, true)
 		}
 
 		msgToSend := &waE2E.Message{
@@ -858,13 +809,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send sticker to WhatsApp", err)
+			return NewWaSendError("Failed to send sticker to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -890,7 +841,17 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				FamilyName: contact.LastName,
 				GivenName:  contact.FirstName,
 			})
-			card.SetValue(goVCard.FieldTelephone, contact.PhoneNumber)
+			// waid lets WhatsApp offer to message the contact
+			telephone := &goVCard.Field{Value: contact.PhoneNumber, Params: goVCard.Params{goVCard.ParamType: {"CELL"}}}
+			if waId := strings.Map(func(r rune) rune {
+				if unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, contact.PhoneNumber); waId != "" {
+				telephone.Params.Set("waid", waId)
+			}
+			card.Add(goVCard.FieldTelephone, telephone)
 			card.SetValue(goVCard.FieldFormattedName, displayName)
 			card.SetValue(goVCard.FieldVersion, "3.0")
 
@@ -921,13 +882,13 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send sticker to WhatsApp", err)
+			return NewWaSendError("Failed to send contact to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -939,14 +900,9 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		msgToSend := &waE2E.Message{}
 		if isLive {
-			// TODO: make this live
-			msgToSend.LiveLocationMessage = &waE2E.LiveLocationMessage{
-				DegreesLatitude:                   &location.Latitude,
-				DegreesLongitude:                  &location.Longitude,
-				AccuracyInMeters:                  proto.Uint32(uint32(location.HorizontalAccuracy)),
-				DegreesClockwiseFromMagneticNorth: proto.Uint32(uint32(location.Heading)),
-				ContextInfo:                       &waE2E.ContextInfo{},
-			}
+			// The position is moved as the Telegram message gets edited
+			msgToSend.LiveLocationMessage = waLiveLocationFromTg(msgToForward)
+			msgToSend.LiveLocationMessage.ContextInfo = &waE2E.ContextInfo{}
 			if isReply {
 				msgToSend.LiveLocationMessage.ContextInfo.StanzaID = proto.String(stanzaId)
 				msgToSend.LiveLocationMessage.ContextInfo.Participant = proto.String(participant)
@@ -963,6 +919,10 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				AccuracyInMeters:                  proto.Uint32(uint32(location.HorizontalAccuracy)),
 				ContextInfo:                       &waE2E.ContextInfo{},
 			}
+			if venue := msgToForward.Venue; venue != nil {
+				msgToSend.LocationMessage.Name = proto.String(venue.Title)
+				msgToSend.LocationMessage.Address = proto.String(venue.Address)
+			}
 			if isReply {
 				msgToSend.LocationMessage.ContextInfo.StanzaID = proto.String(stanzaId)
 				msgToSend.LocationMessage.ContextInfo.Participant = proto.String(participant)
@@ -975,52 +935,103 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send sticker to WhatsApp", err)
+			return NewWaSendError("Failed to send location to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
 
-	} else if msgToForward.Text != "" {
+	} else if msgToForward.Poll != nil {
 
-		if emojis := gomoji.CollectAll(msgToForward.Text); isReply && len(emojis) == 1 && gomoji.RemoveEmojis(msgToForward.Text) == "" {
-			_, err := waClient.SendMessage(context.Background(), waChatJID, &waE2E.Message{
-				ReactionMessage: &waE2E.ReactionMessage{
-					Text:              proto.String(msgToForward.Text),
-					SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
-					Key: &waCommon.MessageKey{
-						RemoteJID: proto.String(waChatJID.String()),
-						FromMe:    proto.Bool(msgToReplyTo != nil && msgToReplyTo.From.Id != b.Id),
-						ID:        proto.String(stanzaId),
-					},
-				},
-			})
-			if err != nil {
-				return TgReplyWithErrorByContext(b, c, "Failed to send reaction to WhatsApp", err)
-			}
-			if cfg.Telegram.ConfirmationType != "none" {
-				msg, err := TgReplyTextByContext(b, c, "Successfully reacted", nil, cfg.Telegram.SilentConfirmation)
-
-				if err == nil {
-					go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
-						time.Sleep(15 * time.Second)
-						_b.DeleteMessage(_m.Chat.Id, _m.MessageId, &gotgbot.DeleteMessageOpts{})
-					}(b, msg)
-				}
-				return err
-			}
-			return err
+		poll := msgToForward.Poll
+
+		var options []string
+		for _, option := range poll.Options {
+			options = append(options, option.Text)
+		}
+
+		// WhatsApp takes 0 as any number of options
+		selectableCount := 1
+		if poll.AllowsMultipleAnswers {
+			selectableCount = 0
 		}
 
+		msgToSend := waClient.BuildPollCreation(poll.Question, options, selectableCount)
+		msgToSend.PollCreationMessage.ContextInfo = &waE2E.ContextInfo{}
+		if isReply {
+			msgToSend.PollCreationMessage.ContextInfo.StanzaID = proto.String(stanzaId)
+			msgToSend.PollCreationMessage.ContextInfo.Participant = proto.String(participant)
+			msgToSend.PollCreationMessage.ContextInfo.QuotedMessage = &waE2E.Message{Conversation: proto.String("")}
+		}
+		if isEphemeral {
+			msgToSend.PollCreationMessage.ContextInfo.Expiration = &ephemeralTimer
+		}
+
+		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
+		if err != nil {
+			return NewWaSendError("Failed to send poll to WhatsApp", err, true)
+		}
+		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
+		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
+
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
+		if err != nil {
+			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
+		}
+
+		// Kept to count the votes coming from WhatsApp for /pollresults
+		optionsJson, _ := json.Marshal(options)
+		err = database.WaPollAddNew(&database.WaPoll{
+			Account:         account.Name,
+			WaMsgId:         sentMsg.ID,
+			WaChatId:        waChatJID.String(),
+			SenderId:        waClient.Store.ID.String(),
+			Question:        poll.Question,
+			Options:         string(optionsJson),
+			SelectableCount: uint32(selectableCount),
+			FromTelegram:    true,
+		})
+		if err != nil {
+			return TgReplyWithErrorByContext(b, c, "Failed to add poll to database", err)
+		}
+
+	} else if msgToForward.Text != "" {
+
 		msgToSend := &waE2E.Message{}
-		if isReply || len(mentions) > 0 || isEphemeral {
+		if linkPreview != nil {
+			msgToSend.ExtendedTextMessage = &waE2E.ExtendedTextMessage{
+				Text:        proto.String(text),
+				MatchedText: proto.String(linkPreview.URL),
+				Title:       proto.String(linkPreview.Title),
+				Description: proto.String(linkPreview.Description),
+				PreviewType: waE2E.ExtendedTextMessage_NONE.Enum(),
+				ContextInfo: &waE2E.ContextInfo{},
+			}
+			if linkPreview.Thumbnail != nil {
+				msgToSend.ExtendedTextMessage.JPEGThumbnail = linkPreview.Thumbnail
+				msgToSend.ExtendedTextMessage.ThumbnailWidth = proto.Uint32(uint32(linkPreview.ThumbnailWidth))
+				msgToSend.ExtendedTextMessage.ThumbnailHeight = proto.Uint32(uint32(linkPreview.ThumbnailHeight))
+			}
+			if isReply {
+				msgToSend.ExtendedTextMessage.ContextInfo.StanzaID = proto.String(stanzaId)
+				msgToSend.ExtendedTextMessage.ContextInfo.Participant = proto.String(participant)
+				msgToSend.ExtendedTextMessage.ContextInfo.QuotedMessage = &waE2E.Message{Conversation: proto.String("")}
+			}
+			if len(mentions) > 0 {
+				msgToSend.ExtendedTextMessage.ContextInfo.MentionedJID = mentions
+			}
+			if isEphemeral {
+				msgToSend.ExtendedTextMessage.ContextInfo.Expiration = &ephemeralTimer
+			}
+		} else if isReply || len(mentions) > 0 || isEphemeral {
 			msgToSend.ExtendedTextMessage = &waE2E.ExtendedTextMessage{
-				Text: proto.String(msgToForward.Text),
+				Text: proto.String(text),
 				ContextInfo: &waE2E.ContextInfo{
 					StanzaID:      proto.String(stanzaId),
 					Participant:   proto.String(participant),
@@ -1034,18 +1045,18 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				msgToSend.ExtendedTextMessage.ContextInfo.Expiration = &ephemeralTimer
 			}
 		} else {
-			msgToSend.Conversation = proto.String(msgToForward.Text)
+			msgToSend.Conversation = proto.String(text)
 		}
 
 		sentMsg, err := waClient.SendMessage(context.Background(), waChatJID, msgToSend)
 		if err != nil {
-			return TgReplyWithErrorByContext(b, c, "Failed to send message to WhatsApp", err)
+			return NewWaSendError("Failed to send message to WhatsApp", err, true)
 		}
 		revokeKeyboard := TgMakeRevokeKeyboard(sentMsg.ID, waChatJID.String(), false)
 		SendMessageConfirmation(b, c, cfg, msgToForward, revokeKeyboard)
 
-		err = database.MsgIdAddNewPair(sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
-			cfg.Telegram.TargetChatID, msgToForward.MessageId, msgToForward.MessageThreadId)
+		err = database.MsgIdAddNewPair(account.Name, sentMsg.ID, waClient.Store.ID.String(), waChatJID.String(),
+			c.EffectiveChat.Id, msgToForward.MessageId, msgToForward.MessageThreadId)
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Failed to add to database", err)
 		}
@@ -1053,14 +1064,14 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 		{
 			textSplit := strings.Fields(strings.ToLower(msgToForward.Text))
 			if slices.Contains(textSplit, "@all") || slices.Contains(textSplit, "@everyone") {
-				WaTagAll(waChatJID, msgToSend, sentMsg.ID, waClient.Store.ID.String(), true)
+				WaTagAll(account, waChatJID, msgToSend, sentMsg.ID, waClient.Store.ID.String(), true)
 			}
 		}
 
 	}
 
 	if cfg.Telegram.SendReadReceiptsOnReply {
-		unreadMsgs, err := database.MsgIdGetUnread(waChatJID.String())
+		unreadMsgs, err := database.MsgIdGetUnread(account.Name, waChatJID.String())
 		if err != nil {
 			return TgReplyWithErrorByContext(b, c, "Message sent but failed to get unread messages to mark them read", err)
 		}
@@ -1077,7 +1088,7 @@ func TgSendToWhatsApp(b *gotgbot.Bot, c *ext.Context,
 				)
 			} else {
 				for _, msgId := range msgIds {
-					database.MsgIdMarkRead(waChatJID.String(), msgId)
+					database.MsgIdMarkRead(account.Name, waChatJID.String(), msgId)
 				}
 			}
 		}
@@ -1113,6 +1124,70 @@ func TgMakeRevokeKeyboard(msgId, chatId string, confirm bool) *gotgbot.InlineKey
 	}
 }
 
+func TgMakeEditHistoryKeyboard(showingHistory bool) gotgbot.InlineKeyboardMarkup {
+
+	if showingHistory {
+		return gotgbot.InlineKeyboardMarkup{
+			InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+				Text:         "Back to current version",
+				CallbackData: "edithistory_hide",
+			}}},
+		}
+	}
+
+	return gotgbot.InlineKeyboardMarkup{
+		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+			Text:         "📝 Edit history",
+			CallbackData: "edithistory_show",
+		}}},
+	}
+}
+
+// TgRenderEditHistory lists the versions of a message, the oldest ones are left out when they do
+// not fit in the given length
+func TgRenderEditHistory(versions []database.MsgVersion, maxLength int) string {
+	var (
+		cfg     = state.State.Config
+		entries []string
+		length  = 100
+	)
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+
+		// Numbering starts at 1 also when the original was not recorded
+		editNumber := i
+		if versions[0].IsEdit {
+			editNumber = i + 1
+		}
+
+		title := "Original"
+		if version.IsEdit {
+			title = fmt.Sprintf("Edit %d", editNumber)
+		}
+
+		text := version.Text
+		if len(text) > 300 {
+			text = SubString(text, 0, 300) + "..."
+		}
+
+		entry := fmt.Sprintf("<i>%s, %s</i>\n<blockquote>%s</blockquote>\n",
+			title,
+			html.EscapeString(version.CreatedAt.In(state.State.LocalLocation).Format(cfg.TimeFormat)),
+			html.EscapeString(text),
+		)
+		if length+len(entry) > maxLength {
+			entries = append(entries, "<i>Older versions are not shown</i>\n")
+			break
+		}
+		length += len(entry)
+		entries = append(entries, entry)
+	}
+
+	slices.Reverse(entries)
+	return "<b>Edit history</b>\n\n" + strings.Join(entries, "")
+}
+
 func TgBuildUrlButton(text, url string) gotgbot.InlineKeyboardMarkup {
 	return gotgbot.InlineKeyboardMarkup{
 		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
@@ -1128,6 +1203,26 @@ func SendMessageConfirmation(
 	cfg *state.Config,
 	msgToForward *gotgbot.Message,
 	revokeKeyboard *gotgbot.InlineKeyboardMarkup,
+) {
+	sendConfirmation(b, c, cfg, msgToForward, "Successfully sent", revokeKeyboard)
+}
+
+func SendEditConfirmation(
+	b *gotgbot.Bot,
+	c *ext.Context,
+	cfg *state.Config,
+	editedMsg *gotgbot.Message,
+) {
+	sendConfirmation(b, c, cfg, editedMsg, "Successfully edited", nil)
+}
+
+func sendConfirmation(
+	b *gotgbot.Bot,
+	c *ext.Context,
+	cfg *state.Config,
+	msgToForward *gotgbot.Message,
+	confirmationText string,
+	revokeKeyboard *gotgbot.InlineKeyboardMarkup,
 ) {
 	switch cfg.Telegram.ConfirmationType {
 	case "emoji":
@@ -1137,7 +1232,7 @@ func SendMessageConfirmation(
 			&gotgbot.SetMessageReactionOpts{Reaction: []gotgbot.ReactionType{gotgbot.ReactionTypeEmoji{Emoji: "👍"}}},
 		)
 	case "text":
-		msg, err := TgReplyTextByContext(b, c, "Successfully sent", revokeKeyboard, cfg.Telegram.SilentConfirmation)
+		msg, err := TgReplyTextByContext(b, c, confirmationText, revokeKeyboard, cfg.Telegram.SilentConfirmation)
 		if err == nil {
 			go func(_b *gotgbot.Bot, _m *gotgbot.Message) {
 				time.Sleep(15 * time.Second)
@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const (
+	// Audio bitrates of transcoded videos, the lower one for videos which have to be squeezed
+	transcodeAudioBitrate    = 128_000
+	transcodeLowAudioBitrate = 64_000
+
+	// Below this video bitrate the result is not worth watching
+	transcodeMinVideoBitrate = 150_000
+
+	// How often the status message is edited while transcoding
+	transcodeStatusInterval = 3 * time.Second
+)
+
+var transcodeStreamRegex = regexp.MustCompile(`Stream #\d+:\d+.*?: (Video|Audio): (\w+)`)
+
+// VideoNeedsTranscode reports whether the video has to be re-encoded for WhatsApp, which only
+// plays H.264 videos with AAC audio in MP4 files
+func VideoNeedsTranscode(mediaFile *MediaFile, mimetype string) bool {
+	if mimetype != "video/mp4" {
+		return true
+	}
+
+	// Without an output file ffmpeg only prints what the input has, and exits with an error
+	output, _ := exec.Command(state.State.Config.FfmpegExecutable, "-hide_banner", "-i", mediaFile.Name()).CombinedOutput()
+	for _, match := range transcodeStreamRegex.FindAllStringSubmatch(string(output), -1) {
+		if (match[1] == "Video" && match[2] != "h264") || (match[1] == "Audio" && match[2] != "aac") {
+			return true
+		}
+	}
+	return false
+}
+
+// VideoTranscode re-encodes the video to H.264 and AAC in place. If maxBytes is set, the bitrate
+// is picked to fit the video under it, which needs the duration of the video. The progress
+// function gets the finished fraction of the video while ffmpeg runs.
+func VideoTranscode(mediaFile *MediaFile, durationSec float64, maxBytes int64, progress func(float64)) error {
+	cfg := state.State.Config
+
+	tempDir, err := MediaTempDir()
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.CreateTemp(tempDir, "transcode-*.mp4")
+	if err != nil {
+		return err
+	}
+	outputPath := outputFile.Name()
+	outputFile.Close()
+	defer os.Remove(outputPath)
+
+	args := []string{"-hide_banner", "-nostats", "-y", "-i", mediaFile.Name(),
+		"-c:v", "libx264", "-preset", cfg.VideoTranscoding.Preset, "-pix_fmt", "yuv420p",
+		"-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", cfg.VideoTranscoding.MaxHeight),
+		"-c:a", "aac", "-movflags", "+faststart", "-progress", "pipe:1",
+	}
+
+	if maxBytes > 0 {
+		if durationSec <= 0 {
+			return fmt.Errorf("the duration of the video is not known, so it cannot be fitted into %d bytes", maxBytes)
+		}
+
+		// Some room is left for the container
+		totalBitrate := int64(float64(maxBytes) * 8 * 0.95 / durationSec)
+		audioBitrate := int64(transcodeAudioBitrate)
+		if totalBitrate < 4*transcodeAudioBitrate {
+			audioBitrate = transcodeLowAudioBitrate
+		}
+		videoBitrate := totalBitrate - audioBitrate
+		if videoBitrate < transcodeMinVideoBitrate {
+			return fmt.Errorf("the video is too long to fit into %d bytes", maxBytes)
+		}
+
+		args = append(args,
+			"-b:v", strconv.FormatInt(videoBitrate, 10),
+			"-maxrate", strconv.FormatInt(videoBitrate, 10),
+			"-bufsize", strconv.FormatInt(2*videoBitrate, 10),
+			"-b:a", strconv.FormatInt(audioBitrate, 10),
+		)
+	} else {
+		args = append(args, "-crf", "23", "-b:a", strconv.Itoa(transcodeAudioBitrate))
+	}
+	args = append(args, outputPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.VideoTranscoding.TimeoutMin)*time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cfg.FfmpegExecutable, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("failed to execute ffmpeg command: %s", err)
+	}
+
+	// ffmpeg reports the time reached in the output as key=value lines
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		if key != "out_time_us" || durationSec <= 0 || progress == nil {
+			continue
+		}
+		if outTime, err := strconv.ParseInt(value, 10, 64); err == nil {
+			progress(min(float64(outTime)/1e6/durationSec, 1))
+		}
+	}
+
+	if err = cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to execute ffmpeg command: %s: %s", err, lastLine(stderr.String()))
+	}
+
+	// The original is kept if the video could not be made to fit, it can still be sent otherwise
+	if outputInfo, err := os.Stat(outputPath); err != nil {
+		return err
+	} else if maxBytes > 0 && outputInfo.Size() > maxBytes {
+		return fmt.Errorf("the transcoded video is still %d bytes, more than %d bytes", outputInfo.Size(), maxBytes)
+	}
+
+	return mediaFile.replaceWith(outputPath)
+}
+
+// lastLine returns the last line of the output of a command, where ffmpeg says what went wrong
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
+
+// replaceWith makes the file at the path the contents of the media file, in place of the old ones
+func (f *MediaFile) replaceWith(path string) error {
+	tempDir, err := MediaTempDir()
+	if err != nil {
+		return err
+	}
+
+	newFile, err := os.CreateTemp(tempDir, "media-*")
+	if err != nil {
+		return err
+	}
+	newFile.Close()
+
+	if err = os.Rename(path, newFile.Name()); err != nil {
+		os.Remove(newFile.Name())
+		return err
+	}
+
+	if newFile, err = os.Open(newFile.Name()); err != nil {
+		return err
+	}
+
+	f.File.Close()
+	if f.owned {
+		os.Remove(f.Name())
+	}
+
+	f.File = newFile
+	f.owned = true
+	return f.finish()
+}
+
+// TranscodeStatus is a Telegram message showing how far the transcoding of a video is
+type TranscodeStatus struct {
+	text        string
+	msg         *gotgbot.Message
+	lastEdit    time.Time
+	lastPercent int
+}
+
+// NewTranscodeStatus sends the status message, replying to the given message if any. Failing to
+// send it does not stop the transcoding.
+func NewTranscodeStatus(chatId, threadId, replyToMsgId int64, text string) *TranscodeStatus {
+	status := &TranscodeStatus{text: text, lastEdit: time.Now()}
+
+	status.msg, _ = state.State.TelegramBot.SendMessage(chatId, text+"…", &gotgbot.SendMessageOpts{
+		MessageThreadId:     threadId,
+		ReplyParameters:     &gotgbot.ReplyParameters{MessageId: replyToMsgId},
+		DisableNotification: true,
+	})
+	return status
+}
+
+// Update shows the progress, editing the message at most every few seconds
+func (s *TranscodeStatus) Update(progress float64) {
+	percent := int(progress * 100)
+	if s.msg == nil || percent == s.lastPercent || time.Since(s.lastEdit) < transcodeStatusInterval {
+		return
+	}
+
+	s.lastEdit = time.Now()
+	s.lastPercent = percent
+	s.msg.EditText(state.State.TelegramBot, fmt.Sprintf("%s… %d%%", s.text, percent), nil)
+}
+
+// Done shows the final text, or removes the status message if there is nothing to say
+func (s *TranscodeStatus) Done(text string) {
+	if s.msg == nil {
+		return
+	}
+
+	if text == "" {
+		s.msg.Delete(state.State.TelegramBot, nil)
+	} else {
+		s.msg.EditText(state.State.TelegramBot, text, nil)
+	}
+}
+
+// tgTranscodeForWhatsApp re-encodes the Telegram video if WhatsApp cannot play it or it is too
+// big for WhatsApp, showing the progress in a reply to it. It returns whether it was re-encoded.
+func tgTranscodeForWhatsApp(c *ext.Context, msg *gotgbot.Message, videoFile *MediaFile) (bool, error) {
+	var (
+		cfg      = state.State.Config
+		maxBytes = cfg.VideoTranscoding.WhatsAppLimitMB * 1024 * 1024
+		tooBig   = videoFile.Size > maxBytes
+	)
+
+	if !tooBig && !VideoNeedsTranscode(videoFile, msg.Video.MimeType) {
+		return false, nil
+	} else if !tooBig {
+		// Only the format has to change
+		maxBytes = 0
+	}
+
+	status := NewTranscodeStatus(c.EffectiveChat.Id, msg.MessageThreadId, msg.MessageId, "🎞 Converting the video for WhatsApp")
+	defer status.Done("")
+
+	return true, VideoTranscode(videoFile, float64(msg.Video.Duration), maxBytes, status.Update)
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.uber.org/zap"
+)
+
+// WaUndecryptableExpire gives up on the messages which were not decrypted in time, their
+// placeholders say so and they are counted as failed
+func WaUndecryptableExpire() {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	before := time.Now().Add(-time.Duration(cfg.WhatsApp.UndecryptableTimeoutMin) * time.Minute)
+	undecryptables, err := database.WaUndecryptableGetWaitingBefore(before)
+	if err != nil {
+		logger.Error("failed to get undecryptable messages from database",
+			zap.Error(err),
+		)
+		return
+	}
+
+	for _, undecryptable := range undecryptables {
+		_, _, err = tgBot.EditMessageText(
+			undecryptable.Header+"\n<i>❌ This message could not be decrypted, please check in your official WhatsApp application</i>",
+			&gotgbot.EditMessageTextOpts{
+				ChatId:    undecryptable.TgChatId,
+				MessageId: undecryptable.TgMsgId,
+			})
+		if err != nil {
+			logger.Warn("failed to edit placeholder of undecryptable message",
+				zap.String("wa_msg_id", undecryptable.WaMsgId),
+				zap.Int64("tg_msg_id", undecryptable.TgMsgId),
+				zap.Error(err),
+			)
+		}
+
+		err = database.WaUndecryptableSetState(undecryptable.Account, undecryptable.WaMsgId, undecryptable.WaChatId, "failed")
+		if err != nil {
+			logger.Error("failed to mark undecryptable message as failed",
+				zap.String("wa_msg_id", undecryptable.WaMsgId),
+				zap.Error(err),
+			)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+
+	"watgbridge/state"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// WhatsApp draws voice notes from this many loudness samples, each from 0 to 100
+	voiceWaveformSamples = 64
+
+	// Sample rate the voice is decoded at to measure its loudness, and the window measured at once
+	voiceWaveformRate   = 8000
+	voiceWaveformWindow = voiceWaveformRate / 20
+)
+
+// VoiceMimetype is the MIME type of voice notes, which both WhatsApp and Telegram show as voice bubbles
+const VoiceMimetype = "audio/ogg; codecs=opus"
+
+// VoiceToOpus re-encodes the audio in place to mono Opus in an OGG file
+func VoiceToOpus(mediaFile *MediaFile) error {
+	tempDir, err := MediaTempDir()
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.CreateTemp(tempDir, "voice-*.ogg")
+	if err != nil {
+		return err
+	}
+	outputPath := outputFile.Name()
+	outputFile.Close()
+	defer os.Remove(outputPath)
+
+	cmd := exec.Command(state.State.Config.FfmpegExecutable,
+		"-hide_banner", "-y", "-i", mediaFile.Name(),
+		"-vn", "-ac", "1", "-ar", "48000",
+		"-c:a", "libopus", "-b:a", "32k", "-application", "voip",
+		"-f", "ogg", outputPath,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to execute ffmpeg command: %s: %s", err, lastLine(string(output)))
+	}
+
+	return mediaFile.replaceWith(outputPath)
+}
+
+// VoiceWaveform measures the loudness of the audio over its length, the way WhatsApp wants it to
+// draw the voice note
+func VoiceWaveform(mediaFile *MediaFile) ([]byte, error) {
+	cmd := exec.Command(state.State.Config.FfmpegExecutable,
+		"-hide_banner", "-i", mediaFile.Name(),
+		"-vn", "-ac", "1", "-ar", fmt.Sprint(voiceWaveformRate),
+		"-f", "s16le", "pipe:1",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to execute ffmpeg command: %s", err)
+	}
+
+	// The loudness of every window is kept, which is little even for long voice notes
+	var (
+		window  = make([]byte, 2*voiceWaveformWindow)
+		windows []float64
+	)
+	for {
+		n, err := io.ReadFull(stdout, window)
+		if n >= 2 {
+			var sum float64
+			for i := 0; i+1 < n; i += 2 {
+				sample := float64(int16(binary.LittleEndian.Uint16(window[i:])))
+				sum += sample * sample
+			}
+			windows = append(windows, math.Sqrt(sum/float64(n/2)))
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, err
+		}
+	}
+
+	if err = cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to execute ffmpeg command: %s", err)
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("the audio has no samples")
+	}
+
+	samples := make([]float64, voiceWaveformSamples)
+	for i := range samples {
+		start := i * len(windows) / voiceWaveformSamples
+		end := max((i+1)*len(windows)/voiceWaveformSamples, start+1)
+		for _, loudness := range windows[start:min(end, len(windows))] {
+			samples[i] = max(samples[i], loudness)
+		}
+	}
+
+	loudest := 0.0
+	for _, sample := range samples {
+		loudest = max(loudest, sample)
+	}
+
+	waveform := make([]byte, voiceWaveformSamples)
+	if loudest > 0 {
+		for i, sample := range samples {
+			waveform[i] = byte(math.Round(sample / loudest * 100))
+		}
+	}
+	return waveform, nil
+}
+
+// VoiceForWhatsApp converts the voice note so that WhatsApp shows it as one, and returns its
+// waveform. Without ffmpeg, or if it fails, the voice note is sent as it is without a waveform.
+func VoiceForWhatsApp(voiceFile *MediaFile) []byte {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	if state.State.Config.FfmpegExecutable == "" {
+		return nil
+	}
+
+	if err := VoiceToOpus(voiceFile); err != nil {
+		logger.Warn("failed to convert voice note to Opus",
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	waveform, err := VoiceWaveform(voiceFile)
+	if err != nil {
+		logger.Warn("failed to measure the waveform of voice note",
+			zap.Error(err),
+		)
+		return nil
+	}
+	return waveform
+}
@@ -10,6 +10,7 @@ import (
 	"watgbridge/database"
 	"watgbridge/state"
 
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 	"github.com/lithammer/fuzzysearch/fuzzy"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/proto/waE2E"
@@ -17,6 +18,65 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// WaAccountByTgChat returns the WhatsApp account whose chats are bridged to the given
+// Telegram chat, or nil if there is none. For a chat shared by several accounts it is the
+// first of them, their topics are told apart with ChatThreadGetAccountFromTg.
+func WaAccountByTgChat(tgChatId int64) *state.WhatsAppAccount {
+	for _, account := range state.State.WhatsAppAccounts {
+		if account.TargetChatID == tgChatId {
+			return account
+		}
+	}
+	return nil
+}
+
+// WaAccountByContext returns the WhatsApp account bridged to the chat of the update. The topic
+// decides first, as a supergroup used by routes can hold chats of several accounts. Commands
+// sent in other chats, e.g. in private to the bot, act on the first account.
+func WaAccountByContext(c *ext.Context) *state.WhatsAppAccount {
+	if c.EffectiveMessage != nil && c.EffectiveMessage.IsTopicMessage {
+		accountName, err := database.ChatThreadGetAccountFromTg(c.EffectiveChat.Id, c.EffectiveMessage.MessageThreadId)
+		if err == nil && accountName != "" {
+			if account := WaAccountByName(accountName); account != nil {
+				return account
+			}
+		}
+	}
+
+	if account := WaAccountByTgChat(c.EffectiveChat.Id); account != nil {
+		return account
+	}
+
+	for _, route := range state.State.Config.Telegram.Routes {
+		if route.TargetChatID == c.EffectiveChat.Id && len(route.Accounts) == 1 {
+			if account := WaAccountByName(route.Accounts[0]); account != nil {
+				return account
+			}
+		}
+	}
+
+	return state.State.WhatsAppAccounts[0]
+}
+
+// WaAccountByName returns the WhatsApp account with the given name, or nil if there is none
+func WaAccountByName(name string) *state.WhatsAppAccount {
+	for _, account := range state.State.WhatsAppAccounts {
+		if account.Name == name {
+			return account
+		}
+	}
+	return nil
+}
+
+// WaAccountTitle returns how the account is called in messages to the owner, the name is only
+// mentioned when more than one account is bridged
+func WaAccountTitle(accountName string) string {
+	if len(state.State.WhatsAppAccounts) > 1 || len(state.State.Config.WhatsApp.Accounts) > 1 {
+		return fmt.Sprintf("WhatsApp (account '%s')", accountName)
+	}
+	return "WhatsApp"
+}
+
 func WaParseJID(s string) (types.JID, bool) {
 	if s[0] == '+' {
 		s = SubString(s, 1, len(s)-1)
@@ -96,8 +156,8 @@ func WaFuzzyFindContacts(query string) (map[string]string, int, error) {
 	return results, resultsCount, nil
 }
 
-func WaGetGroupName(jid types.JID) string {
-	waClient := state.State.WhatsAppClient
+func WaGetGroupName(account *state.WhatsAppAccount, jid types.JID) string {
+	waClient := account.Client
 
 	groupInfo, err := waClient.GetGroupInfo(context.Background(), jid)
 	if err != nil {
@@ -106,13 +166,14 @@ func WaGetGroupName(jid types.JID) string {
 	return groupInfo.Name
 }
 
-func WaGetContactName(jid types.JID) string {
-	if jid.ToNonAD() == state.State.WhatsAppClient.Store.ID.ToNonAD() {
+func WaGetContactName(account *state.WhatsAppAccount, jid types.JID) string {
+	waClient := account.Client
+
+	if jid.ToNonAD() == waClient.Store.ID.ToNonAD() {
 		return "You"
 	}
 
 	var name string
-	waClient := state.State.WhatsAppClient
 
 	var (
 		pn           types.JID
@@ -167,10 +228,9 @@ func WaGetContactName(jid types.JID) string {
 	return name
 }
 
-func WaTagAll(group types.JID, msg *waE2E.Message, msgId, msgSender string, msgIsFromMe bool) {
+func WaTagAll(account *state.WhatsAppAccount, group types.JID, msg *waE2E.Message, msgId, msgSender string, msgIsFromMe bool) {
 	var (
-		cfg      = state.State.Config
-		waClient = state.State.WhatsAppClient
+		waClient = account.Client
 		tgBot    = state.State.TelegramBot
 	)
 
@@ -211,21 +271,21 @@ func WaTagAll(group types.JID, msg *waE2E.Message, msgId, msgSender string, msgI
 	}
 
 	if !msgIsFromMe {
-		tagsThreadId, _, err := TgGetOrMakeThreadFromWa_String("mentions", cfg.Telegram.TargetChatID, "Mentions")
+		tagsThreadId, _, err := TgGetOrMakeThreadFromWa_String(account, "mentions", account.TargetChatID, "Mentions")
 		if err != nil {
-			TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "Failed to create/retreive corresponding thread id for status/calls/tags", err)
+			TgSendErrorById(tgBot, account.TargetChatID, 0, "Failed to create/retreive corresponding thread id for status/calls/tags", err)
 			return
 		}
 
 		bridgedText := fmt.Sprintf("#tagall\n\nEveryone was mentioned in a group\n\n👥: <i>%s</i>",
 			html.EscapeString(groupInfo.Name))
 
-		TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tagsThreadId, bridgedText)
+		TgSendTextById(tgBot, account.TargetChatID, tagsThreadId, bridgedText)
 	}
 }
 
-func WaSendText(chat types.JID, text, stanzaId, participantId string, quotedMsg *waE2E.Message, isReply bool) (whatsmeow.SendResponse, error) {
-	waClient := state.State.WhatsAppClient
+func WaSendText(account *state.WhatsAppAccount, chat types.JID, text, stanzaId, participantId string, quotedMsg *waE2E.Message, isReply bool) (whatsmeow.SendResponse, error) {
+	waClient := account.Client
 
 	msgToSend := &waE2E.Message{}
 	if isReply {
@@ -0,0 +1,214 @@
+package whatsapp
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+const (
+	// How long to wait for more items of an album after the last one arrived
+	waAlbumWindow = 2 * time.Second
+
+	// Telegram allows 2 to 10 items in a media group
+	tgMediaGroupLimit = 10
+)
+
+// waAlbumItem is a photo or video of an album waiting to be sent with the rest of the album
+type waAlbumItem struct {
+	m          *bridgeMessage
+	isVideo    bool
+	fileName   string
+	file       *utils.MediaFile
+	caption    string // Caption of the item, without the header
+	hasSpoiler bool
+	send       func(ctx context.Context) (*gotgbot.Message, error) // Sends the item on its own
+}
+
+// waAlbum collects the items of a WhatsApp album, which arrive as separate messages
+type waAlbum struct {
+	expected int
+	items    []waAlbumItem
+	timer    *time.Timer
+}
+
+var (
+	waAlbums     = make(map[string]*waAlbum)
+	waAlbumsLock sync.Mutex
+)
+
+func waAlbumKey(m *bridgeMessage, parentId string) string {
+	return m.account.Name + "|" + m.info.Chat.String() + "|" + parentId
+}
+
+// waAlbumParentId returns the ID of the album the message belongs to, if any
+func waAlbumParentId(msg *waE2E.Message) string {
+	association := msg.GetMessageContextInfo().GetMessageAssociation()
+	if association.GetAssociationType() != waE2E.MessageAssociation_MEDIA_ALBUM {
+		return ""
+	}
+	return association.GetParentMessageKey().GetID()
+}
+
+// renderWaAlbum notes how many items the album has, so that it can be sent as soon as all of
+// them arrive. The items themselves are rendered as they arrive.
+func renderWaAlbum(m *bridgeMessage, v *events.Message) {
+	albumMsg := v.Message.GetAlbumMessage()
+
+	waAlbumsLock.Lock()
+	defer waAlbumsLock.Unlock()
+
+	key := waAlbumKey(m, m.msgId)
+	album := waAlbums[key]
+	if album == nil {
+		album = &waAlbum{}
+		waAlbums[key] = album
+		album.timer = time.AfterFunc(waAlbumWindow, func() { waFlushAlbum(key, album) })
+	}
+
+	album.expected = int(albumMsg.GetExpectedImageCount() + albumMsg.GetExpectedVideoCount())
+	if album.expected > 0 && len(album.items) >= album.expected {
+		album.timer.Stop()
+		delete(waAlbums, key)
+		go sendWaAlbum(album)
+	}
+}
+
+// addToAlbum holds the item back until the rest of the album arrives, or until no more items
+// arrive for a while
+func (m *bridgeMessage) addToAlbum(parentId string, item waAlbumItem) {
+	waAlbumsLock.Lock()
+	defer waAlbumsLock.Unlock()
+
+	key := waAlbumKey(m, parentId)
+	album := waAlbums[key]
+	if album == nil {
+		album = &waAlbum{}
+		waAlbums[key] = album
+	} else {
+		album.timer.Stop()
+	}
+
+	album.items = append(album.items, item)
+	if album.expected > 0 && len(album.items) >= album.expected {
+		delete(waAlbums, key)
+		go sendWaAlbum(album)
+		return
+	}
+
+	album.timer = time.AfterFunc(waAlbumWindow, func() { waFlushAlbum(key, album) })
+}
+
+func waFlushAlbum(key string, album *waAlbum) {
+	waAlbumsLock.Lock()
+	if waAlbums[key] != album {
+		// Already sent when its last item arrived
+		waAlbumsLock.Unlock()
+		return
+	}
+	delete(waAlbums, key)
+	waAlbumsLock.Unlock()
+
+	sendWaAlbum(album)
+}
+
+// sendWaAlbum sends the items as Telegram media groups, items which cannot be sent in a group
+// are sent on their own
+func sendWaAlbum(album *waAlbum) {
+	defer func() {
+		for _, item := range album.items {
+			item.file.Close()
+		}
+	}()
+
+	slices.SortStableFunc(album.items, func(a, b waAlbumItem) int {
+		return a.m.info.Timestamp.Compare(b.m.info.Timestamp)
+	})
+
+	for chunk := range slices.Chunk(album.items, tgMediaGroupLimit) {
+		if len(chunk) < 2 || !sendWaAlbumChunk(chunk) {
+			for _, item := range chunk {
+				item.m.deliver(item.send)
+			}
+		}
+	}
+}
+
+// sendWaAlbumChunk sends the items as one media group, with the header only on the first item,
+// and maps every item to its Telegram message
+func sendWaAlbumChunk(chunk []waAlbumItem) bool {
+	var (
+		logger   = state.State.Logger
+		tgBot    = state.State.TelegramBot
+		first    = chunk[0].m
+		media    = make([]gotgbot.InputMedia, 0, len(chunk))
+		captions = make([]string, 0, len(chunk))
+	)
+	defer logger.Sync()
+
+	for i, item := range chunk {
+		caption := item.m.bodyHtml(item.caption, 1020, nil)
+		if i == 0 {
+			caption = item.m.header + caption
+		}
+		captions = append(captions, caption)
+
+		file := &gotgbot.FileReader{Name: item.fileName, Data: item.file.Reader()}
+		if item.isVideo {
+			media = append(media, gotgbot.InputMediaVideo{
+				Media:      file,
+				Caption:    caption,
+				ParseMode:  gotgbot.ParseModeHTML,
+				HasSpoiler: item.hasSpoiler,
+			})
+		} else {
+			media = append(media, gotgbot.InputMediaPhoto{
+				Media:      file,
+				Caption:    caption,
+				ParseMode:  gotgbot.ParseModeHTML,
+				HasSpoiler: item.hasSpoiler,
+			})
+		}
+	}
+
+	sentMsgs, err := tgBot.SendMediaGroup(first.targetChatId, media, &gotgbot.SendMediaGroupOpts{
+		ReplyParameters: first.replyParameters(),
+		MessageThreadId: first.threadId,
+	})
+	if err != nil || len(sentMsgs) != len(chunk) {
+		logger.Warn("failed to send WhatsApp album as media group, sending the items on their own",
+			zap.String("event_id", first.info.ID),
+			zap.Int("items", len(chunk)),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	for i, item := range chunk {
+		database.MsgIdAddNewPair(item.m.account.Name, item.m.msgId, item.m.info.MessageSource.Sender.String(),
+			item.m.info.Chat.String(), sentMsgs[i].Chat.Id, sentMsgs[i].MessageId, sentMsgs[i].MessageThreadId)
+
+		item.m.keepVersion(item.caption, captions[i], true)
+		if item.m.version != nil {
+			if err := database.MsgVersionAddNew(item.m.version); err != nil {
+				logger.Error("failed to add message version to database",
+					zap.String("event_id", item.m.info.ID),
+					zap.Error(err),
+				)
+			}
+			item.m.version = nil
+		}
+	}
+
+	return true
+}
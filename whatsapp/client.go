@@ -8,6 +8,7 @@ import (
 	"os"
 
 	"watgbridge/state"
+	"watgbridge/utils"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	_ "github.com/jackc/pgx/v5"
@@ -47,7 +48,8 @@ func (wl whatsmeowLogger) Sub(module string) waLog.Logger {
 	return whatsmeowLogger{logger: wl.logger.Named(module)}
 }
 
-func NewWhatsAppClient() error {
+// NewWhatsAppClients logs into all the WhatsApp accounts from the config file
+func NewWhatsAppClients() error {
 
 	var (
 		cfg    = state.State.Config
@@ -72,9 +74,6 @@ func NewWhatsAppClient() error {
 	logger = logger.Named("WaTgBridge")
 	defer logger.Sync()
 
-	waDatabaseLogger := &whatsmeowLogger{logger: logger.Sugar().Named("WhatsMeow_Database")}
-	waClientLogger := &whatsmeowLogger{logger: logger.Sugar().Named("WhatsMeow_Client")}
-
 	store.DeviceProps.Os = proto.String(state.State.Config.WhatsApp.SessionName)
 	store.DeviceProps.RequireFullSync = proto.Bool(false)
 	store.DeviceProps.PlatformType = waCompanionReg.DeviceProps_PlatformType(waCompanionReg.DeviceProps_PlatformType_value[state.State.Config.WhatsApp.BrowserName]).Enum()
@@ -88,29 +87,79 @@ func NewWhatsAppClient() error {
 		SupportCagReactionsAndPolls:    proto.Bool(false),
 	}
 
-	container, err := sqlstore.New(context.Background(), state.State.Config.WhatsApp.LoginDatabase.Type,
-		state.State.Config.WhatsApp.LoginDatabase.URL, waDatabaseLogger)
-	if err != nil {
-		return fmt.Errorf("could not initialize sqlstore for Whatsapp : %s", err)
+	// Accounts sharing a login database share the container too
+	containers := make(map[state.LoginDatabaseConfig]*sqlstore.Container)
+
+	for _, accountCfg := range cfg.WhatsApp.Accounts {
+		container, found := containers[accountCfg.LoginDatabase]
+		if !found {
+			waDatabaseLogger := &whatsmeowLogger{logger: logger.Sugar().Named("WhatsMeow_Database")}
+			container, err = sqlstore.New(context.Background(), accountCfg.LoginDatabase.Type,
+				accountCfg.LoginDatabase.URL, waDatabaseLogger)
+			if err != nil {
+				return fmt.Errorf("could not initialize sqlstore for Whatsapp account '%s' : %s", accountCfg.Name, err)
+			}
+			containers[accountCfg.LoginDatabase] = container
+		}
+
+		account, err := newWhatsAppClient(accountCfg, container, logger)
+		if err != nil {
+			return err
+		}
+		state.State.WhatsAppAccounts = append(state.State.WhatsAppAccounts, account)
 	}
 
-	deviceStore, err := container.GetFirstDevice(context.Background())
+	return nil
+}
+
+func newWhatsAppClient(accountCfg state.WhatsAppAccountConfig, container *sqlstore.Container,
+	logger *zap.Logger) (*state.WhatsAppAccount, error) {
+
+	var (
+		cfg        = state.State.Config
+		err        error
+		loginTitle = utils.WaAccountTitle(accountCfg.Name)
+	)
+
+	var deviceStore *store.Device
+	if accountCfg.PhoneNumber != "" {
+		var devices []*store.Device
+		devices, err = container.GetAllDevices(context.Background())
+		for _, device := range devices {
+			if device.ID != nil && device.ID.User == accountCfg.PhoneNumber {
+				deviceStore = device
+				break
+			}
+		}
+		if err == nil && deviceStore == nil {
+			deviceStore = container.NewDevice()
+		}
+	} else {
+		deviceStore, err = container.GetFirstDevice(context.Background())
+	}
 	if err != nil {
-		return fmt.Errorf("could not initialize device store for Whatsapp : %s", err)
+		return nil, fmt.Errorf("could not initialize device store for Whatsapp account '%s' : %s", accountCfg.Name, err)
 	}
 
+	waClientLogger := &whatsmeowLogger{logger: logger.Sugar().Named("WhatsMeow_Client").Named(accountCfg.Name)}
+
 	client := whatsmeow.NewClient(deviceStore, waClientLogger)
-	state.State.WhatsAppClient = client
+	account := &state.WhatsAppAccount{
+		Name:         accountCfg.Name,
+		Client:       client,
+		TargetChatID: accountCfg.TargetChatID,
+	}
 
 	if client.Store.ID == nil {
 		qrChan, _ := client.GetQRChannel(context.Background())
 		err = client.Connect()
 		if err != nil {
-			return fmt.Errorf("could not connect to Whatsapp for login : %s", err)
+			return nil, fmt.Errorf("could not connect to Whatsapp for login : %s", err)
 		}
 		for evt := range qrChan {
 			if evt.Event == "code" {
 				// print to terminal
+				fmt.Printf("Scan this QR code to login to %s\n", loginTitle)
 				qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
 
 				if !state.State.Config.WhatsApp.SkipQrCodeSend {
@@ -121,8 +170,8 @@ func NewWhatsAppClient() error {
 							state.State.TelegramBot.SendMessage(
 								state.State.Config.Telegram.OwnerID,
 								fmt.Sprintf(
-									"Please check your terminal and scan the QR code to login to WhatsApp. Failed to encode to PNG and send here:\n<code>%s</code>",
-									html.EscapeString(err.Error()),
+									"Please check your terminal and scan the QR code to login to %s. Failed to encode to PNG and send here:\n<code>%s</code>",
+									html.EscapeString(loginTitle), html.EscapeString(err.Error()),
 								),
 								&gotgbot.SendMessageOpts{},
 							)
@@ -131,7 +180,7 @@ func NewWhatsAppClient() error {
 								state.State.Config.Telegram.OwnerID,
 								gotgbot.InputFileByReader("qrcode.png", bytes.NewReader(qrCodePNG)),
 								&gotgbot.SendPhotoOpts{
-									Caption: "Scan the above QR code to login to WhatsApp.",
+									Caption: fmt.Sprintf("Scan the above QR code to login to %s.", html.EscapeString(loginTitle)),
 								},
 							)
 						}
@@ -139,6 +188,7 @@ func NewWhatsAppClient() error {
 				}
 			} else {
 				logger.Info("received WhatsApp login event",
+					zap.String("account", accountCfg.Name),
 					zap.Any("event", evt.Event),
 				)
 			}
@@ -146,18 +196,31 @@ func NewWhatsAppClient() error {
 	} else {
 		err = client.Connect()
 		if err != nil {
-			return fmt.Errorf("could not connect to Whatsapp : %s", err)
+			return nil, fmt.Errorf("could not connect to Whatsapp account '%s' : %s", accountCfg.Name, err)
 		}
 	}
 
+	if client.Store.ID == nil {
+		return nil, fmt.Errorf("could not login to Whatsapp account '%s'", accountCfg.Name)
+	} else if accountCfg.PhoneNumber != "" && client.Store.ID.User != accountCfg.PhoneNumber {
+		logger.Warn("logged into a different phone number than the one in config",
+			zap.String("account", accountCfg.Name),
+			zap.String("phone_number", accountCfg.PhoneNumber),
+			zap.String("jid", client.Store.ID.String()),
+		)
+	}
+
 	logger.Info("successfully logged into WhatsApp",
+		zap.String("account", accountCfg.Name),
 		zap.String("push_name", client.Store.PushName),
 		zap.String("jid", client.Store.ID.String()),
 	)
 
 	if !cfg.WhatsApp.SkipStartupMessage {
-		state.State.TelegramBot.SendMessage(cfg.Telegram.OwnerID, "Successfully logged into WhatsApp from Coco_WaTgBridge", &gotgbot.SendMessageOpts{})
+		state.State.TelegramBot.SendMessage(cfg.Telegram.OwnerID,
+			fmt.Sprintf("Successfully logged into %s from Coco_WaTgBridge", html.EscapeString(loginTitle)),
+			&gotgbot.SendMessageOpts{})
 	}
 
-	return nil
+	return account, nil
 }
@@ -0,0 +1,125 @@
+package whatsapp
+
+import (
+	"strings"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// renderWaEdit applies a WhatsApp edit to the bridged Telegram message. It returns false if
+// that is not possible, e.g. when Telegram no longer allows editing the message, so that the
+// edit is sent as a new message instead.
+func renderWaEdit(m *bridgeMessage, v *events.Message, tgMsgId int64) bool {
+	var (
+		cfg       = state.State.Config
+		logger    = state.State.Logger
+		tgBot     = state.State.TelegramBot
+		editedMsg = v.Message.GetProtocolMessage().GetEditedMessage()
+	)
+	defer logger.Sync()
+
+	text, isCaption := waEditedContent(editedMsg)
+	if text == "" {
+		return false
+	}
+
+	versions, err := database.MsgVersionGetAll(m.account.Name, m.msgId, m.info.Chat.String())
+	if err != nil {
+		logger.Error("failed to get versions of edited message from database",
+			zap.String("msg_id", m.msgId),
+			zap.Error(err),
+		)
+	} else if len(versions) > 0 {
+		// The bridged message decides, a caption edit of a skipped photo still edits a text message
+		isCaption = versions[0].IsCaption
+	}
+
+	var (
+		rendered    string
+		previewHtml = waLinkPreviewHtml(editedMsg.GetExtendedTextMessage())
+	)
+	if isCaption {
+		rendered = m.header + m.bodyHtml(text, max(1020-len([]rune(previewHtml)), 100), nil) + previewHtml
+	} else {
+		rendered = m.header + m.bodyHtml(text, 4000, editedMsg.GetExtendedTextMessage().GetContextInfo().GetMentionedJID()) + previewHtml
+	}
+
+	replyMarkup := gotgbot.InlineKeyboardMarkup{InlineKeyboard: [][]gotgbot.InlineKeyboardButton{}}
+	if !cfg.WhatsApp.SkipEditHistory {
+		replyMarkup = utils.TgMakeEditHistoryKeyboard(false)
+	}
+
+	if isCaption {
+		_, _, err = tgBot.EditMessageCaption(&gotgbot.EditMessageCaptionOpts{
+			ChatId:      m.targetChatId,
+			MessageId:   tgMsgId,
+			Caption:     rendered,
+			ReplyMarkup: replyMarkup,
+		})
+	} else {
+		_, _, err = tgBot.EditMessageText(rendered, &gotgbot.EditMessageTextOpts{
+			ChatId:             m.targetChatId,
+			MessageId:          tgMsgId,
+			ReplyMarkup:        replyMarkup,
+			LinkPreviewOptions: waLinkPreviewOptions(editedMsg.GetExtendedTextMessage()),
+		})
+	}
+
+	if err != nil {
+		if strings.Contains(err.Error(), "message is not modified") {
+			// The same edit was received again
+			return true
+		}
+		logger.Warn("failed to edit bridged message, sending the edit as a new message",
+			zap.String("msg_id", m.msgId),
+			zap.Int64("tg_msg_id", tgMsgId),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	if !cfg.WhatsApp.SkipEditHistory {
+		err = database.MsgVersionAddNew(&database.MsgVersion{
+			Account:   m.account.Name,
+			WaMsgId:   m.msgId,
+			WaChatId:  m.info.Chat.String(),
+			Text:      text,
+			Rendered:  rendered,
+			IsCaption: isCaption,
+			IsEdit:    true,
+			CreatedAt: v.Info.Timestamp,
+		})
+		if err != nil {
+			logger.Error("failed to add message version to database",
+				zap.String("msg_id", m.msgId),
+				zap.Error(err),
+			)
+		}
+	}
+
+	return true
+}
+
+// waEditedContent returns the new text of an edited message, or its new caption for media
+func waEditedContent(editedMsg *waE2E.Message) (string, bool) {
+	switch {
+	case editedMsg.GetExtendedTextMessage().GetText() != "":
+		return editedMsg.GetExtendedTextMessage().GetText(), false
+	case editedMsg.GetConversation() != "":
+		return editedMsg.GetConversation(), false
+	case editedMsg.GetImageMessage() != nil:
+		return editedMsg.GetImageMessage().GetCaption(), true
+	case editedMsg.GetVideoMessage() != nil:
+		return editedMsg.GetVideoMessage().GetCaption(), true
+	case editedMsg.GetDocumentMessage() != nil:
+		return editedMsg.GetDocumentMessage().GetCaption(), true
+	}
+	return "", false
+}
@@ -13,7 +13,6 @@ import (
 	"watgbridge/utils"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
-	goVCard "github.com/emersion/go-vcard"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/appstate"
 	"go.mau.fi/whatsmeow/proto/waE2E"
@@ -24,48 +23,69 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-func WhatsAppEventHandler(evt interface{}) {
+func WhatsAppEventHandler(account *state.WhatsAppAccount, evt interface{}) {
 
 	cfg := state.State.Config
 
 	switch v := evt.(type) {
 
 	case *events.Connected:
-		ConnectedHandler()
+		ConnectedHandler(account)
 
 	case *events.PairSuccess:
-		PairSuccessHandler(v)
+		PairSuccessHandler(account, v)
 
 	case *events.AppStateSyncComplete:
-		AppStateSyncHandler(v)
+		AppStateSyncHandler(account, v)
 
 	case *events.LoggedOut:
-		LogoutHandler(v)
+		LogoutHandler(account, v)
 
 	case *events.Receipt:
-		ReceiptEventHandler(v)
+		ReceiptEventHandler(account, v)
 
 	case *events.Picture:
 		if !cfg.WhatsApp.SkipProfilePictureUpdates {
-			PictureEventHandler(v)
+			PictureEventHandler(account, v)
 		}
 
 	case *events.GroupInfo:
 		if !cfg.WhatsApp.SkipGroupSettingsUpdates {
-			GroupInfoEventHandler(v)
+			GroupInfoEventHandler(account, v)
 		}
+		if v.Name != nil {
+			// Routes matching on the group name are decided again for the next message
+			utils.TgForgetRoute(account, v.JID)
+		}
+
+	case *events.LabelEdit:
+		LabelEditEventHandler(account, v)
+
+	case *events.LabelAssociationChat:
+		LabelAssociationChatEventHandler(account, v)
 
 	case *events.PushName:
-		PushNameEventHandler(v)
+		PushNameEventHandler(account, v)
 
 	case *events.UserAbout:
-		UserAboutEventHandler(v)
+		UserAboutEventHandler(account, v)
 
 	case *events.CallOffer:
-		CallOfferEventHandler(v)
+		CallOfferEventHandler(account, v)
+
+	case *events.UndecryptableMessage:
+		UndecryptableMessageEventHandler(account, v)
 
 	case *events.Message:
 
+		if child := v.Message.GetAssociatedChildMessage().GetMessage(); child != nil {
+			// Items of albums can come wrapped, with the album they belong to outside the wrapper
+			if child.MessageContextInfo == nil {
+				child.MessageContextInfo = v.Message.GetMessageContextInfo()
+			}
+			v.Message = child
+		}
+
 		isEdited := false
 		if protoMsg := v.Message.GetProtocolMessage(); protoMsg != nil &&
 			protoMsg.GetType() == waE2E.ProtocolMessage_MESSAGE_EDIT {
@@ -74,7 +94,12 @@ func WhatsAppEventHandler(evt interface{}) {
 
 		if protoMsg := v.Message.GetProtocolMessage(); protoMsg != nil &&
 			protoMsg.GetType() == waE2E.ProtocolMessage_REVOKE {
-			RevokedMessageEventHandler(v)
+			RevokedMessageEventHandler(account, v)
+			return
+		}
+
+		if v.Message.GetPollUpdateMessage() != nil {
+			PollVoteEventHandler(account, v)
 			return
 		}
 
@@ -106,15 +131,15 @@ func WhatsAppEventHandler(evt interface{}) {
 		}
 
 		if v.Info.IsFromMe {
-			MessageFromMeEventHandler(text, v, isEdited)
+			MessageFromMeEventHandler(account, text, v, isEdited)
 		} else {
-			MessageFromOthersEventHandler(text, v, isEdited)
+			MessageFromOthersEventHandler(account, text, v, isEdited)
 		}
 	}
 
 }
 
-func PairSuccessHandler(event *events.PairSuccess) {
+func PairSuccessHandler(account *state.WhatsAppAccount, event *events.PairSuccess) {
 	// TODO - nothing is done here for now - this needs to be re-worked from the original coco_database changes
 	//// save me into the database
 	//_, found := database.FindCocoContact(event.ID, event.LID)
@@ -139,27 +164,34 @@ func PairSuccessHandler(event *events.PairSuccess) {
 	//_ = database.CocoContactUpdatePushName(event.ID, event.LID, "You")
 }
 
-func ConnectedHandler() {
+func ConnectedHandler(account *state.WhatsAppAccount) {
 	var (
 		logger = state.State.Logger
 		cfg    = state.State.Config
 	)
 	defer logger.Sync()
 
-	logger.Info("successfully connected to whatsapp")
+	logger.Info("successfully connected to whatsapp",
+		zap.String("account", account.Name),
+	)
+
+	// Flush the messages which were queued while WhatsApp was disconnected
+	go utils.OutboundProcessQueue()
 
 	if !cfg.WhatsApp.SkipStartupMessage {
-		state.State.TelegramBot.SendMessage(cfg.Telegram.OwnerID, "Successfully connected to WhatsApp from Coco_WaTgBridge", &gotgbot.SendMessageOpts{})
+		state.State.TelegramBot.SendMessage(cfg.Telegram.OwnerID,
+			fmt.Sprintf("Successfully connected to %s from Coco_WaTgBridge", html.EscapeString(utils.WaAccountTitle(account.Name))),
+			&gotgbot.SendMessageOpts{})
 	}
 }
 
-func AppStateSyncHandler(event *events.AppStateSyncComplete) {
+func AppStateSyncHandler(account *state.WhatsAppAccount, event *events.AppStateSyncComplete) {
 	if event.Name == appstate.WAPatchCriticalUnblockLow && !state.State.Config.WhatsApp.SkipInitialSync {
-		InitialSyncContactsHandler()
+		InitialSyncContactsHandler(account)
 	}
 }
 
-func SendProfilePictureToNewThread(threadFound bool, threadId int64, waTargetChat waTypes.JID) {
+func SendProfilePictureToNewThread(account *state.WhatsAppAccount, tgChatId int64, threadFound bool, threadId int64, waTargetChat waTypes.JID) {
 	var (
 		cfg    = state.State.Config
 		logger = state.State.Logger
@@ -167,7 +199,7 @@ func SendProfilePictureToNewThread(threadFound bool, threadId int64, waTargetCha
 	)
 
 	if !threadFound && !cfg.WhatsApp.SkipInitialPhotoSend {
-		pictureInfo, err := state.State.WhatsAppClient.GetProfilePictureInfo(
+		pictureInfo, err := account.Client.GetProfilePictureInfo(
 			context.Background(),
 			waTargetChat,
 			&whatsmeow.GetProfilePictureParams{
@@ -178,7 +210,7 @@ func SendProfilePictureToNewThread(threadFound bool, threadId int64, waTargetCha
 			logger.Error("failed to get profile picture info", zap.Error(err), zap.String("group", waTargetChat.String()))
 
 			tgBot.SendMessage(
-				cfg.Telegram.TargetChatID,
+				tgChatId,
 				"failed to get profile picture info",
 				&gotgbot.SendMessageOpts{MessageThreadId: threadId},
 			)
@@ -187,19 +219,19 @@ func SendProfilePictureToNewThread(threadFound bool, threadId int64, waTargetCha
 			if err != nil {
 				logger.Error("failed to download profile picture", zap.Error(err), zap.String("group", waTargetChat.String()))
 				tgBot.SendMessage(
-					cfg.Telegram.TargetChatID,
+					tgChatId,
 					"failed to download profile picture",
 					&gotgbot.SendMessageOpts{MessageThreadId: threadId},
 				)
 			}
 
-			_, err = tgBot.SendPhoto(cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
+			_, err = tgBot.SendPhoto(tgChatId, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
 				MessageThreadId: threadId,
 				Caption:         fmt.Sprintf("This user's current profile picture"),
 			})
 			if err != nil {
 				tgBot.SendMessage(
-					cfg.Telegram.TargetChatID,
+					tgChatId,
 					"failed to send the profile picture here",
 					&gotgbot.SendMessageOpts{MessageThreadId: threadId},
 				)
@@ -207,7 +239,7 @@ func SendProfilePictureToNewThread(threadFound bool, threadId int64, waTargetCha
 		} else {
 			logger.Error("failed to get profile picture info, received null", zap.String("group", waTargetChat.String()))
 			tgBot.SendMessage(
-				cfg.Telegram.TargetChatID,
+				tgChatId,
 				"failed to get profile picture info, received null",
 				&gotgbot.SendMessageOpts{MessageThreadId: threadId},
 			)
@@ -215,7 +247,7 @@ func SendProfilePictureToNewThread(threadFound bool, threadId int64, waTargetCha
 	}
 }
 
-func MessageFromMeEventHandler(text string, v *events.Message, isEdited bool) {
+func MessageFromMeEventHandler(account *state.WhatsAppAccount, text string, v *events.Message, isEdited bool) {
 	logger := state.State.Logger
 	defer logger.Sync()
 
@@ -225,1380 +257,269 @@ func MessageFromMeEventHandler(text string, v *events.Message, isEdited bool) {
 		if v.Info.IsGroup &&
 			(slices.Contains(textSplit, "@all") || slices.Contains(textSplit, "@everyone")) {
 
-			utils.WaTagAll(v.Info.Chat, v.Message, v.Info.ID, v.Info.MessageSource.Sender.String(), true)
-		}
-	}
-
-	var msgId string
-	if isEdited {
-		msgId = v.Message.GetProtocolMessage().GetKey().GetID()
-	} else {
-		msgId = v.Info.ID
-	}
-
-	// Get ID of the current chat
-	if text == ".id" {
-		waClient := state.State.WhatsAppClient
-
-		_, err := waClient.SendMessage(context.Background(), v.Info.Chat, &waE2E.Message{
-			ExtendedTextMessage: &waE2E.ExtendedTextMessage{
-				Text: proto.String(fmt.Sprintf("The ID of the current chat is:\n\n```%s```", v.Info.Chat.String())),
-				ContextInfo: &waE2E.ContextInfo{
-					StanzaID:      proto.String(msgId),
-					Participant:   proto.String(v.Info.MessageSource.Sender.String()),
-					QuotedMessage: v.Message,
-				},
-			},
-		})
-		if err != nil {
-			logger.Error("failed to reply to .id command",
-				zap.String("event_id", v.Info.ID),
-				zap.Error(err),
-			)
-		}
-	}
-
-	if !isEdited {
-		// Tag everyone in the group
-		textSplit := strings.Fields(strings.ToLower(text))
-		if v.Info.IsGroup &&
-			(slices.Contains(textSplit, "@all") || slices.Contains(textSplit, "@everyone")) {
-
-			utils.WaTagAll(v.Info.Chat, v.Message, msgId, v.Info.MessageSource.Sender.String(), true)
-		}
-	}
-
-	if state.State.Config.WhatsApp.SendMyMessagesFromOtherDevices {
-		MessageFromOthersEventHandler(text, v, isEdited)
-	}
-}
-
-func MessageFromOthersEventHandler(text string, v *events.Message, isEdited bool) {
-	var (
-		cfg         = state.State.Config
-		logger      = state.State.Logger
-		tgBot       = state.State.TelegramBot
-		waClient    = state.State.WhatsAppClient
-		mediaSuffix = "_" + v.Info.Timestamp.Format(cfg.MediaTimeFormat) + "_"
-	)
-	defer logger.Sync()
-
-	var msgId string
-	if isEdited {
-		msgId = v.Message.GetProtocolMessage().GetKey().GetID()
-	} else {
-		msgId = v.Info.ID
-	}
-
-	if !isEdited {
-		// Return if duplicate event is emitted
-		tgChatId, _, _, _ := database.MsgIdGetTgFromWa(msgId, v.Info.Chat.String())
-		if tgChatId == cfg.Telegram.TargetChatID {
-			logger.Debug("returning because duplicate event id emitted",
-				zap.String("event_id", v.Info.ID),
-				zap.String("chat_jid", v.Info.Chat.String()),
-			)
-			return
-		}
-	}
-
-	if v.Info.Chat.String() == "status@broadcast" &&
-		(cfg.WhatsApp.SkipStatus ||
-			slices.Contains(cfg.WhatsApp.StatusIgnoredChats, v.Info.MessageSource.Sender.User)) {
-		// Return if status is from ignored chat
-		logger.Debug("returning because status from a ignored chat",
-			zap.String("event_id", v.Info.ID),
-			zap.String("chat_jid", v.Info.Chat.String()),
-		)
-		return
-	} else if slices.Contains(cfg.WhatsApp.IgnoreChats, v.Info.Chat.User) {
-		// Return if the chat is ignored
-		logger.Debug("returning because message from an ignored chat",
-			zap.String("event_id", v.Info.ID),
-			zap.String("chat_jid", v.Info.Chat.String()),
-		)
-		return
-	}
-
-	replyMarkup := utils.TgBuildUrlButton(utils.WaGetContactName(v.Info.Sender), fmt.Sprintf("https://wa.me/%s", v.Info.MessageSource.Sender.ToNonAD().User))
-	if !isEdited {
-		if lowercaseText := strings.ToLower(text); !v.Info.IsFromMe && v.Info.IsGroup && slices.Contains(cfg.WhatsApp.TagAllAllowedGroups, v.Info.Chat.User) &&
-			(strings.Contains(lowercaseText, "@all") || strings.Contains(lowercaseText, "@everyone")) {
-			logger.Debug("usage of @all/@everyone command from your account",
-				zap.String("event_id", v.Info.ID),
-				zap.String("chat_jid", v.Info.Chat.String()),
-			)
-			utils.WaTagAll(v.Info.Chat, v.Message, msgId, v.Info.MessageSource.Sender.String(), false)
-		}
-	}
-
-	var bridgedText string
-	if cfg.WhatsApp.SkipChatDetails {
-		logger.Debug("skipping to add chat details as configured",
-			zap.String("event_id", v.Info.ID),
-		)
-		if v.Info.IsIncomingBroadcast() {
-			bridgedText += "👥: <b>(Broadcast)</b>\n"
-		} else if v.Info.IsFromMe {
-			bridgedText += "🧑: <b>You [other device]</b>\n"
-		} else if v.Info.IsGroup {
-			bridgedText += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
-		}
-
-	} else {
-
-		if v.Info.IsFromMe {
-			bridgedText += "🧑: <b>You [other device]</b>\n"
-		} else {
-			bridgedText += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
-		}
-		if v.Info.IsIncomingBroadcast() {
-			bridgedText += "👥: <b>(Broadcast)</b>\n"
-		} else if v.Info.IsGroup {
-			bridgedText += fmt.Sprintf("👥: <b>%s</b>\n", html.EscapeString(utils.WaGetGroupName(v.Info.Chat)))
-		} else {
-			bridgedText += "👥: <b>(PVT)</b>\n"
-		}
-
-	}
-
-	if isEdited {
-		bridgedText += "<i>Edited</i>\n"
-	}
-
-	if time.Since(v.Info.Timestamp).Seconds() > 60 {
-		bridgedText += fmt.Sprintf("🕛: <b>%s</b>\n",
-			html.EscapeString(v.Info.Timestamp.In(state.State.LocalLocation).Format(cfg.TimeFormat)))
-	}
-
-	var (
-		replyToMsgId  int64
-		threadId      int64
-		threadIdFound bool
-	)
-
-	if isEdited {
-
-		tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(
-			v.Message.GetProtocolMessage().GetKey().GetID(),
-			v.Info.Chat.String(),
-		)
-		if err == nil && tgChatId == cfg.Telegram.TargetChatID {
-			replyToMsgId = tgMsgId
-			threadId = tgThreadId
-			threadIdFound = true
-		}
-
-	} else {
-
-		logger.Debug("trying to retrieve context info from Message",
-			zap.String("event_id", v.Info.ID),
-		)
-		var contextInfo *waE2E.ContextInfo = nil
-
-		if v.Message.GetExtendedTextMessage().GetContextInfo() != nil {
-			logger.Debug("taking context info from ExtendedTextMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetExtendedTextMessage().GetContextInfo()
-		} else if v.Message.GetImageMessage() != nil {
-			logger.Debug("taking context info from ImageMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetImageMessage().GetContextInfo()
-		} else if v.Message.GetVideoMessage() != nil {
-			logger.Debug("taking context info from VideoMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetVideoMessage().GetContextInfo()
-		} else if v.Message.GetPtvMessage() != nil {
-			logger.Debug("taking context info from PtvMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetPtvMessage().GetContextInfo()
-		} else if v.Message.GetAudioMessage() != nil {
-			logger.Debug("taking context info from AudioMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetAudioMessage().GetContextInfo()
-		} else if v.Message.GetDocumentMessage() != nil {
-			logger.Debug("taking context info from DocumentMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetDocumentMessage().GetContextInfo()
-		} else if v.Message.GetStickerMessage() != nil {
-			logger.Debug("taking context info from StickerMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetStickerMessage().GetContextInfo()
-		} else if v.Message.GetContactMessage() != nil {
-			logger.Debug("taking context info from ContactMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetContactMessage().GetContextInfo()
-		} else if v.Message.GetContactsArrayMessage() != nil {
-			logger.Debug("taking context info from ContactsArrayMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetContactsArrayMessage().GetContextInfo()
-		} else if v.Message.GetLocationMessage() != nil {
-			logger.Debug("taking context info from LocationMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetLocationMessage().GetContextInfo()
-		} else if v.Message.GetLiveLocationMessage() != nil {
-			logger.Debug("taking context info from LiveLocationMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetLiveLocationMessage().GetContextInfo()
-		} else if v.Message.GetPollCreationMessage() != nil {
-			logger.Debug("taking context info from PollCreationMessage",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetPollCreationMessage().GetContextInfo()
-		} else if v.Message.GetPollCreationMessageV2() != nil {
-			logger.Debug("taking context info from PollCreationMessageV2",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetPollCreationMessageV2().GetContextInfo()
-		} else if v.Message.GetPollCreationMessageV3() != nil {
-			logger.Debug("taking context info from PollCreationMessageV3",
-				zap.String("event_id", v.Info.ID),
-			)
-			contextInfo = v.Message.GetPollCreationMessageV3().GetContextInfo()
-		} else {
-			logger.Debug("no context info found in any kind of messages",
-				zap.String("event_id", v.Info.ID),
-			)
-		}
-
-		if contextInfo != nil {
-
-			if contextInfo.GetIsForwarded() {
-				bridgedText += fmt.Sprintf("⏩: Forwarded %v times\n", contextInfo.GetForwardingScore())
-			}
-
-			logger.Debug("checking if your account is mentioned in the message",
-				zap.String("event_id", v.Info.ID),
-			)
-			if mentioned := contextInfo.GetMentionedJID(); v.Info.IsGroup && mentioned != nil {
-				for _, jid := range mentioned {
-					parsedJid, _ := utils.WaParseJID(jid)
-					if parsedJid.User == waClient.Store.ID.User {
-
-						tagInfoText := "#mentions\n\n" + bridgedText + fmt.Sprintf("\n<i>You were tagged in %s</i>",
-							html.EscapeString(utils.WaGetGroupName(v.Info.Chat)))
-
-						threadId, _, err := utils.TgGetOrMakeThreadFromWa_String("mentions", cfg.Telegram.TargetChatID, "Mentions")
-						if err != nil {
-							utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "failed to create/find thread id for 'mentions'", err)
-						} else {
-							tgBot.SendMessage(cfg.Telegram.TargetChatID, tagInfoText, &gotgbot.SendMessageOpts{
-								MessageThreadId: threadId,
-								ReplyMarkup:     replyMarkup,
-							})
-						}
-
-						break
-					}
-				}
-			}
-
-			logger.Debug("trying to retrieve mapped Message in Telegram",
-				zap.String("event_id", v.Info.ID),
-			)
-			stanzaId := contextInfo.GetStanzaID()
-			tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(stanzaId, v.Info.Chat.String())
-			if err == nil && tgChatId == cfg.Telegram.TargetChatID {
-				replyToMsgId = tgMsgId
-				threadId = tgThreadId
-				threadIdFound = true
-			}
-		}
-	}
-
-	if !strings.HasSuffix(bridgedText, "\n\n") {
-		bridgedText += "\n"
-	}
-
-	if !threadIdFound {
-		var err error
-		if v.Info.Chat.String() == "status@broadcast" {
-			threadId, _, err = utils.TgGetOrMakeThreadFromWa_String("status@broadcast", cfg.Telegram.TargetChatID,
-				"Status")
-			if err != nil {
-				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "failed to create/find thread id for 'status@broadcast'", err)
-				return
-			}
-		} else if v.Info.IsIncomingBroadcast() {
-			if (v.Info.MessageSource.AddressingMode == waTypes.AddressingModePN) || v.Info.MessageSource.SenderAlt.IsEmpty() {
-				threadId, _, err = utils.TgGetOrMakeThreadFromWa(v.Info.MessageSource.Sender.ToNonAD(), cfg.Telegram.TargetChatID,
-					utils.WaGetContactName(v.Info.MessageSource.Sender.ToNonAD()))
-			} else {
-				threadId, _, err = utils.TgGetOrMakeThreadFromWa(v.Info.MessageSource.SenderAlt.ToNonAD(), cfg.Telegram.TargetChatID,
-					utils.WaGetContactName(v.Info.MessageSource.SenderAlt.ToNonAD()))
-			}
-			if err != nil {
-				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, fmt.Sprintf("failed to create/find thread id for '%s'",
-					v.Info.MessageSource.Sender.ToNonAD().String()), err)
-				return
-			}
-		} else if v.Info.IsGroup {
-			var threadFound = false
-			threadId, threadFound, err = utils.TgGetOrMakeThreadFromWa(v.Info.Chat, cfg.Telegram.TargetChatID,
-				utils.WaGetGroupName(v.Info.Chat))
-			if err != nil {
-				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, fmt.Sprintf("failed to create/find thread id for '%s'",
-					v.Info.Chat.String()), err)
-				return
-			}
-			if !threadFound && !cfg.WhatsApp.SkipInitialPhotoSend {
-				pictureInfo, err := state.State.WhatsAppClient.GetProfilePictureInfo(
-					context.Background(),
-					v.Info.Chat,
-					&whatsmeow.GetProfilePictureParams{
-						Preview: false,
-					},
-				)
-				if err != nil {
-					logger.Error("failed to get profile picture info", zap.Error(err), zap.String("group", v.Info.Chat.String()))
-
-					tgBot.SendMessage(
-						cfg.Telegram.TargetChatID,
-						"failed to get profile picture info",
-						&gotgbot.SendMessageOpts{MessageThreadId: threadId},
-					)
-				} else if pictureInfo != nil {
-					newPictureBytes, err := utils.DownloadFileBytesByURL(pictureInfo.URL)
-					if err != nil {
-						logger.Error("failed to download profile picture", zap.Error(err), zap.String("group", v.Info.Chat.String()))
-						tgBot.SendMessage(
-							cfg.Telegram.TargetChatID,
-							"failed to download profile picture",
-							&gotgbot.SendMessageOpts{MessageThreadId: threadId},
-						)
-					}
-
-					_, err = tgBot.SendPhoto(cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
-						MessageThreadId: threadId,
-						Caption:         fmt.Sprintf("This user's current profile picture"),
-					})
-					if err != nil {
-						tgBot.SendMessage(
-							cfg.Telegram.TargetChatID,
-							"failed to send the profile picture here",
-							&gotgbot.SendMessageOpts{MessageThreadId: threadId},
-						)
-					}
-				} else {
-					logger.Error("failed to get profile picture info, received null", zap.String("group", v.Info.Chat.String()))
-					tgBot.SendMessage(
-						cfg.Telegram.TargetChatID,
-						"failed to get profile picture info, received null",
-						&gotgbot.SendMessageOpts{MessageThreadId: threadId},
-					)
-				}
-			}
-		} else {
-			target_chat_jid := v.Info.Chat.ToNonAD()
-
-			var threadFound = false
-			threadId, threadFound, err = utils.TgGetOrMakeThreadFromWa(target_chat_jid, cfg.Telegram.TargetChatID, utils.WaGetContactName(target_chat_jid))
-			if err != nil {
-				utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, fmt.Sprintf("failed to create/find thread id for '%s'",
-					target_chat_jid.String()), err)
-				return
-			}
-			SendProfilePictureToNewThread(threadFound, threadId, v.Info.Chat)
-		}
-	}
-
-	if v.Message.GetImageMessage() != nil {
-
-		imageMsg := v.Message.GetImageMessage()
-		if imageMsg.GetURL() == "" {
-			return
-		}
-
-		if cfg.WhatsApp.SkipImages {
-			bridgedText += "\n<i>Skipping image because 'skip_images' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else if !cfg.Telegram.SelfHostedAPI && imageMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\n<i>Couldn't send the photo as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else {
-			imageBytes, err := waClient.Download(context.Background(), imageMsg)
-			if err != nil {
-				bridgedText += "\n<i>Couldn't download the photo due to some errors</i>"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
-				return
-			}
-
-			if caption := imageMsg.GetCaption(); caption != "" {
-				if len(caption) > 1020 {
-					bridgedText += html.EscapeString(utils.SubString(caption, 0, 1020)) + "..."
-				} else {
-					bridgedText += html.EscapeString(caption)
-				}
-			}
-
-			sentMsg, _ := tgBot.SendPhoto(cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(imageBytes)}, &gotgbot.SendPhotoOpts{
-				Caption: bridgedText,
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				HasSpoiler:      imageMsg.GetViewOnce(),
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		}
-
-	} else if v.Message.GetVideoMessage() != nil && v.Message.GetVideoMessage().GetGifPlayback() {
-
-		gifMsg := v.Message.GetVideoMessage()
-		if gifMsg.GetURL() == "" {
-			return
-		}
-
-		if cfg.WhatsApp.SkipGIFs {
-			bridgedText += "\n<i>Skipping GIF because 'skip_gifs' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else if !cfg.Telegram.SelfHostedAPI && gifMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\n<i>Couldn't send the GIF as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else {
-			gifBytes, err := waClient.Download(context.Background(), gifMsg)
-			if err != nil {
-				bridgedText += "\n<i>Couldn't download the GIF due to some errors</i>"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
-				return
-			}
-
-			if caption := gifMsg.GetCaption(); caption != "" {
-				if len(caption) > 1020 {
-					bridgedText += html.EscapeString(utils.SubString(caption, 0, 1020)) + "..."
-				} else {
-					bridgedText += html.EscapeString(caption)
-				}
-			}
-
-			fileToSend := gotgbot.FileReader{
-				Name: "animation" + mediaSuffix + ".gif",
-				Data: bytes.NewReader(gifBytes),
-			}
-
-			sentMsg, _ := tgBot.SendAnimation(cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendAnimationOpts{
-				Caption: bridgedText,
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		}
-
-	} else if v.Message.GetVideoMessage() != nil || v.Message.GetPtvMessage() != nil {
-
-		var videoMsg *waE2E.VideoMessage = nil
-		isPtvMsg := false
-		if v.Message.GetVideoMessage() != nil {
-			videoMsg = v.Message.GetVideoMessage()
-		} else {
-			videoMsg = v.Message.GetPtvMessage()
-			isPtvMsg = true
-		}
-
-		if videoMsg.GetURL() == "" {
-			return
-		}
-
-		if cfg.WhatsApp.SkipVideos {
-			bridgedText += "\n<i>Skipping video because 'skip_videos' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else if !cfg.Telegram.SelfHostedAPI && videoMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\n<i>Couldn't send the video as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else {
-			videoBytes, err := waClient.Download(context.Background(), videoMsg)
-			if err != nil {
-				bridgedText += "\n<i>Couldn't download the video due to some errors</i>"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
-				return
-			}
-
-			if caption := videoMsg.GetCaption(); caption != "" {
-				if len(caption) > 1020 {
-					bridgedText += html.EscapeString(utils.SubString(caption, 0, 1020)) + "..."
-				} else {
-					bridgedText += html.EscapeString(caption)
-				}
-			}
-
-			fileToSend := gotgbot.FileReader{
-				Name: "video" + mediaSuffix + "." + strings.Split(videoMsg.GetMimetype(), "/")[1],
-				Data: bytes.NewReader(videoBytes),
-			}
-
-			var sentMsg *gotgbot.Message = nil
-			if isPtvMsg {
-				sentMsg, _ = tgBot.SendVideoNote(cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendVideoNoteOpts{
-					ReplyMarkup: replyMarkup,
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-				})
-			} else {
-				sentMsg, _ = tgBot.SendVideo(cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendVideoOpts{
-					Caption: bridgedText,
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					HasSpoiler:      videoMsg.GetViewOnce(),
-					MessageThreadId: threadId,
-				})
-			}
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		}
-
-	} else if v.Message.GetAudioMessage() != nil && v.Message.GetAudioMessage().GetPTT() {
-
-		audioMsg := v.Message.GetAudioMessage()
-		if audioMsg.GetURL() == "" {
-			return
-		}
-
-		if cfg.WhatsApp.SkipVoiceNotes {
-			bridgedText += "\n<i>Skipping voice note because 'skip_voice_notes' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else if !cfg.Telegram.SelfHostedAPI && audioMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\n<i>Couldn't send the audio as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else {
-			audioBytes, err := waClient.Download(context.Background(), audioMsg)
-			if err != nil {
-				bridgedText += "\n<i>Couldn't download the audio due to some errors</i>"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
-				return
-			}
-
-			fileToSend := gotgbot.FileReader{
-				Name: "audio" + mediaSuffix + ".ogg",
-				Data: bytes.NewReader(audioBytes),
-			}
-
-			sentMsg, _ := tgBot.SendAudio(cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendAudioOpts{
-				Caption:  bridgedText,
-				Duration: int64(audioMsg.GetSeconds()),
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		}
-
-	} else if v.Message.GetAudioMessage() != nil {
-
-		audioMsg := v.Message.GetAudioMessage()
-		if audioMsg.GetURL() == "" {
-			return
-		}
-
-		if cfg.WhatsApp.SkipAudios {
-			bridgedText += "\n<i>Skipping audio because 'skip_audios' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else if !cfg.Telegram.SelfHostedAPI && audioMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\n<i>Couldn't send the audio as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else {
-			audioBytes, err := waClient.Download(context.Background(), audioMsg)
-			if err != nil {
-				bridgedText += "\n<i>Couldn't download the audio due to some errors</i>"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
-				return
-			}
-
-			fileToSend := gotgbot.FileReader{
-				Name: "audio" + mediaSuffix + ".m4a",
-				Data: bytes.NewReader(audioBytes),
-			}
-
-			sentMsg, _ := tgBot.SendAudio(cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendAudioOpts{
-				Caption:  bridgedText,
-				Duration: int64(audioMsg.GetSeconds()),
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		}
-
-	} else if v.Message.GetDocumentMessage() != nil {
-
-		documentMsg := v.Message.GetDocumentMessage()
-		if documentMsg.GetURL() == "" {
-			return
-		}
-
-		if cfg.WhatsApp.SkipDocuments {
-			bridgedText += "\n<i>Skipping document because 'skip_documents' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else if !cfg.Telegram.SelfHostedAPI && documentMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\n<i>Couldn't send the document as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else {
-			documentBytes, err := waClient.Download(context.Background(), documentMsg)
-			if err != nil {
-				bridgedText += "\n<i>Couldn't download the document due to some errors</i>"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
-				return
-			}
-
-			if caption := documentMsg.GetCaption(); caption != "" {
-				if len(caption) > 1020 {
-					bridgedText += html.EscapeString(utils.SubString(caption, 0, 1020)) + "..."
-				} else {
-					bridgedText += html.EscapeString(caption)
-				}
-			}
-
-			fileToSend := gotgbot.FileReader{
-				Name: documentMsg.GetFileName(),
-				Data: bytes.NewReader(documentBytes),
-			}
-
-			sentMsg, _ := tgBot.SendDocument(cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendDocumentOpts{
-				Caption: bridgedText,
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		}
-
-	} else if v.Message.GetStickerMessage() != nil {
-
-		stickerMsg := v.Message.GetStickerMessage()
-		if stickerMsg.GetURL() == "" {
-			return
-		}
-
-		if cfg.WhatsApp.SkipStickers {
-			bridgedText += "\n<i>Skipping sticker because 'skip_stickers' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else if !cfg.Telegram.SelfHostedAPI && stickerMsg.GetFileLength() > utils.UploadSizeLimit {
-			bridgedText += "\n<i>Couldn't send the sticker as it exceeds Telegram size restrictions.</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		} else {
-			stickerBytes, err := waClient.Download(context.Background(), stickerMsg)
-			if err != nil {
-				bridgedText += "\n<i>Couldn't download the sticker due to some errors</i>"
-				sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
-				return
-			}
-			if stickerMsg.GetIsAnimated() || stickerMsg.GetIsAvatar() {
-				gifBytes, err := utils.AnimatedWebpConvertToGif(stickerBytes, v.Info.ID)
-				if err != nil {
-					goto WEBP_TO_GIF_FAILED
-				}
-
-				fileToSend := gotgbot.FileReader{
-					Name: "animation.gif",
-					Data: bytes.NewReader(gifBytes),
-				}
-
-				sentMsg, _ := tgBot.SendAnimation(cfg.Telegram.TargetChatID, &fileToSend, &gotgbot.SendAnimationOpts{
-					Caption: bridgedText,
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-					ReplyMarkup:     replyMarkup,
-				})
-				if sentMsg.MessageId != 0 {
-					database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-						cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-				}
-				return
-
-			}
-		WEBP_TO_GIF_FAILED:
-			sentMsg, _ := tgBot.SendSticker(cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(stickerBytes)}, &gotgbot.SendStickerOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-				ReplyMarkup:     replyMarkup,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-		}
-
-	} else if v.Message.GetContactMessage() != nil {
-		contactMsg := v.Message.GetContactMessage()
-
-		if cfg.WhatsApp.SkipContacts {
-			bridgedText += "\n<i>Skipping contact because 'skip_contacts' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		}
-
-		decoder := goVCard.NewDecoder(bytes.NewReader([]byte(contactMsg.GetVcard())))
-		card, err := decoder.Decode()
-		if err != nil {
-			bridgedText += "\n<i>Couldn't send the vCard as failed to parse it</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
-		}
-
-		sentMsg, _ := tgBot.SendContact(cfg.Telegram.TargetChatID, card.PreferredValue(goVCard.FieldTelephone), contactMsg.GetDisplayName(),
-			&gotgbot.SendContactOpts{
-				Vcard: contactMsg.GetVcard(),
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-				ReplyMarkup:     replyMarkup,
-			})
-		if sentMsg.MessageId != 0 {
-			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+			utils.WaTagAll(account, v.Info.Chat, v.Message, v.Info.ID, v.Info.MessageSource.Sender.String(), true)
 		}
-		return
+	}
 
-	} else if v.Message.GetContactsArrayMessage() != nil {
+	var msgId string
+	if isEdited {
+		msgId = v.Message.GetProtocolMessage().GetKey().GetID()
+	} else {
+		msgId = v.Info.ID
+	}
 
-		contactsMsg := v.Message.GetContactsArrayMessage()
+	// Get ID of the current chat
+	if text == ".id" {
+		waClient := account.Client
 
-		if cfg.WhatsApp.SkipContacts {
-			bridgedText += "\n<i>Skipping contact array because 'skip_contacts' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
+		_, err := waClient.SendMessage(context.Background(), v.Info.Chat, &waE2E.Message{
+			ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+				Text: proto.String(fmt.Sprintf("The ID of the current chat is:\n\n```%s```", v.Info.Chat.String())),
+				ContextInfo: &waE2E.ContextInfo{
+					StanzaID:      proto.String(msgId),
+					Participant:   proto.String(v.Info.MessageSource.Sender.String()),
+					QuotedMessage: v.Message,
 				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
+			},
+		})
+		if err != nil {
+			logger.Error("failed to reply to .id command",
+				zap.String("event_id", v.Info.ID),
+				zap.Error(err),
+			)
 		}
-		for _, contactMsg := range contactsMsg.Contacts {
-			decoder := goVCard.NewDecoder(bytes.NewReader([]byte(contactMsg.GetVcard())))
-			card, err := decoder.Decode()
-			if err != nil {
-				tgBot.SendMessage(cfg.Telegram.TargetChatID, "Couldn't send the vCard as failed to parse it",
-					&gotgbot.SendMessageOpts{
-						ReplyParameters: &gotgbot.ReplyParameters{
-							MessageId: replyToMsgId,
-						},
-						MessageThreadId: threadId,
-					})
-				continue
-			}
+	}
 
-			sentMsg, _ := tgBot.SendContact(cfg.Telegram.TargetChatID, card.PreferredValue(goVCard.FieldTelephone), contactMsg.GetDisplayName(),
-				&gotgbot.SendContactOpts{
-					Vcard: contactMsg.GetVcard(),
-					ReplyParameters: &gotgbot.ReplyParameters{
-						MessageId: replyToMsgId,
-					},
-					MessageThreadId: threadId,
-					ReplyMarkup:     replyMarkup,
-				})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+	if !isEdited {
+		// Tag everyone in the group
+		textSplit := strings.Fields(strings.ToLower(text))
+		if v.Info.IsGroup &&
+			(slices.Contains(textSplit, "@all") || slices.Contains(textSplit, "@everyone")) {
+
+			utils.WaTagAll(account, v.Info.Chat, v.Message, msgId, v.Info.MessageSource.Sender.String(), true)
 		}
-		return
+	}
+
+	if state.State.Config.WhatsApp.SendMyMessagesFromOtherDevices {
+		MessageFromOthersEventHandler(account, text, v, isEdited)
+	}
+}
+
+func MessageFromOthersEventHandler(account *state.WhatsAppAccount, text string, v *events.Message, isEdited bool) {
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		tgBot    = state.State.TelegramBot
+		waClient = account.Client
+	)
+	defer logger.Sync()
 
-	} else if v.Message.GetLocationMessage() != nil {
+	var msgId string
+	if isEdited {
+		msgId = v.Message.GetProtocolMessage().GetKey().GetID()
+	} else {
+		msgId = v.Info.ID
+	}
 
-		locationMsg := v.Message.GetLocationMessage()
+	targetChatId := waTargetChatId(account, v.Info)
 
-		if cfg.WhatsApp.SkipLocations {
-			bridgedText += "\n<i>Skipping location because 'skip_locations' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
+	if !isEdited {
+		// Return if duplicate event is emitted
+		tgChatId, _, _, _ := database.MsgIdGetTgFromWa(account.Name, msgId, v.Info.Chat.String())
+		if tgChatId == targetChatId {
+			logger.Debug("returning because duplicate event id emitted",
+				zap.String("event_id", v.Info.ID),
+				zap.String("chat_jid", v.Info.Chat.String()),
+			)
 			return
 		}
-		sentMsg, _ := tgBot.SendLocation(cfg.Telegram.TargetChatID, locationMsg.GetDegreesLatitude(), locationMsg.GetDegreesLongitude(),
-			&gotgbot.SendLocationOpts{
-				HorizontalAccuracy: float64(locationMsg.GetAccuracyInMeters()),
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-		if sentMsg.MessageId != 0 {
-			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-		}
+	}
 
+	if v.Info.Chat.String() == "status@broadcast" &&
+		(cfg.WhatsApp.SkipStatus ||
+			slices.Contains(cfg.WhatsApp.StatusIgnoredChats, v.Info.MessageSource.Sender.User)) {
+		// Return if status is from ignored chat
+		logger.Debug("returning because status from a ignored chat",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+		)
 		return
+	} else if slices.Contains(cfg.WhatsApp.IgnoreChats, v.Info.Chat.User) {
+		// Return if the chat is ignored
+		logger.Debug("returning because message from an ignored chat",
+			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
+		)
+		return
+	}
 
-	} else if v.Message.GetLiveLocationMessage() != nil {
-
-		bridgedText += "\n<i>Shared their live location with you</i>"
+	m := newBridgeMessage(account, v.Info, msgId, text, isEdited, targetChatId)
 
-		if cfg.WhatsApp.SkipLocations {
-			bridgedText += "\n<i>Skipping live location because 'skip_locations' set in config file</i>"
-			sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-				ReplyParameters: &gotgbot.ReplyParameters{
-					MessageId: replyToMsgId,
-				},
-				MessageThreadId: threadId,
-			})
-			if sentMsg.MessageId != 0 {
-				database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-					cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-			}
-			return
+	if !isEdited {
+		if lowercaseText := strings.ToLower(text); !v.Info.IsFromMe && v.Info.IsGroup && slices.Contains(cfg.WhatsApp.TagAllAllowedGroups, v.Info.Chat.User) &&
+			(strings.Contains(lowercaseText, "@all") || strings.Contains(lowercaseText, "@everyone")) {
+			logger.Debug("usage of @all/@everyone command from your account",
+				zap.String("event_id", v.Info.ID),
+				zap.String("chat_jid", v.Info.Chat.String()),
+			)
+			utils.WaTagAll(account, v.Info.Chat, v.Message, msgId, v.Info.MessageSource.Sender.String(), false)
 		}
+	}
 
-		sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-			ReplyParameters: &gotgbot.ReplyParameters{
-				MessageId: replyToMsgId,
-			},
-			MessageThreadId: threadId,
-		})
-		if sentMsg.MessageId != 0 {
-			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-		}
-		return
+	var (
+		threadIdFound bool
+		editedTgMsgId int64
+	)
 
-	} else if v.Message.GetPollCreationMessage() != nil || v.Message.GetPollCreationMessageV2() != nil || v.Message.GetPollCreationMessageV3() != nil {
+	if isEdited {
 
-		var pollMsg *waE2E.PollCreationMessage
-		if i := v.Message.GetPollCreationMessage(); i != nil {
-			pollMsg = i
-		} else if i := v.Message.GetPollCreationMessageV2(); i != nil {
-			pollMsg = i
-		} else if i := v.Message.GetPollCreationMessageV3(); i != nil {
-			pollMsg = i
+		tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(account.Name, msgId, v.Info.Chat.String())
+		if err == nil && tgChatId == targetChatId {
+			m.replyToMsgId = tgMsgId
+			m.threadId = tgThreadId
+			threadIdFound = true
+			editedTgMsgId = tgMsgId
 		}
 
-		bridgedText += "\n<i>It was the following poll:</i>\n\n"
-		bridgedText += fmt.Sprintf("<b>%s</b>: (%v options selectable)\n\n",
-			html.EscapeString(pollMsg.GetName()), pollMsg.GetSelectableOptionsCount())
-		for optionNum, option := range pollMsg.GetOptions() {
-			if len(bridgedText) > 4000 {
-				bridgedText += "\n... <i>Plus some other options</i>"
-				break
-			}
-			bridgedText += fmt.Sprintf("%v. %s\n", optionNum+1, html.EscapeString(option.GetOptionName()))
-		}
+	} else if contextInfo := waMessageContextInfo(v.Message); contextInfo != nil {
 
-		sentMsg, _ := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-			ReplyParameters: &gotgbot.ReplyParameters{
-				MessageId: replyToMsgId,
-			},
-			MessageThreadId: threadId,
-		})
-		if sentMsg.MessageId != 0 {
-			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+		if contextInfo.GetIsForwarded() {
+			m.header += fmt.Sprintf("⏩: Forwarded %v times\n", contextInfo.GetForwardingScore())
 		}
-		return
-
-	} else {
-		if text == "" {
-			if reactionMsg := v.Message.GetReactionMessage(); cfg.Telegram.Reactions && reactionMsg != nil {
-				// Resolve LID to PN for private chats using new WhatsApp LID system
-				waChatIdForLookup := v.Info.Chat.String()
-				if v.Info.Chat.Server == waTypes.HiddenUserServer {
-					pn, err := waClient.Store.LIDs.GetPNForLID(context.Background(), v.Info.Chat.ToNonAD())
-					if err != nil {
-						logger.Warn(
-							"failed to get PN for LID when handling reaction",
-							zap.Error(err),
-							zap.String("lid", v.Info.Chat.String()),
-						)
-					} else {
-						waChatIdForLookup = pn.String()
-					}
-				}
 
-				tgChatId, _, tgMsgId, err := database.MsgIdGetTgFromWa(reactionMsg.Key.GetID(), waChatIdForLookup)
-				if err != nil {
-					logger.Error(
-						"failed to get message ID mapping from database",
-						zap.Error(err),
-						zap.String("stanza_id", reactionMsg.Key.GetID()),
-						zap.String("chat_id", waChatIdForLookup),
-					)
-				} else if tgChatId == cfg.Telegram.TargetChatID {
-
-					if *reactionMsg.Text != "" {
-						text = fmt.Sprintf(
-							"<code>Reacted to this message with %s</code>",
-							html.EscapeString(*reactionMsg.Text),
-						)
-					} else {
-						text = "<code>Revoked their reaction to this message</code>"
-					}
+		logger.Debug("checking if your account is mentioned in the message",
+			zap.String("event_id", v.Info.ID),
+		)
+		if mentioned := contextInfo.GetMentionedJID(); v.Info.IsGroup && mentioned != nil {
+			for _, jid := range mentioned {
+				parsedJid, _ := utils.WaParseJID(jid)
+				if parsedJid.User == waClient.Store.ID.User {
 
-					bridgedText += text
+					tagInfoText := "#mentions\n\n" + m.header + fmt.Sprintf("\n<i>You were tagged in %s</i>",
+						html.EscapeString(utils.WaGetGroupName(account, v.Info.Chat)))
 
-					sentMsg, err := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-						ReplyParameters: &gotgbot.ReplyParameters{
-							MessageId: tgMsgId,
-						},
-						MessageThreadId: threadId,
-					})
+					threadId, _, err := utils.TgGetOrMakeThreadFromWa_String(account, "mentions", account.TargetChatID, "Mentions")
 					if err != nil {
-						panic(fmt.Errorf("failed to send telegram message: %s", err))
-					}
-					if sentMsg.MessageId != 0 {
-						database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), waChatIdForLookup,
-							cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
+						utils.TgSendErrorById(tgBot, account.TargetChatID, 0, "failed to create/find thread id for 'mentions'", err)
+					} else {
+						tgBot.SendMessage(account.TargetChatID, tagInfoText, &gotgbot.SendMessageOpts{
+							MessageThreadId: threadId,
+							ReplyMarkup:     m.replyMarkup,
+						})
 					}
-				}
 
+					break
+				}
 			}
-
-			return
 		}
 
-		if len(text) > 4000 {
-			bridgedText += html.EscapeString(utils.SubString(text, 0, 4000)) + "..."
-		} else {
-			bridgedText += html.EscapeString(text)
+		logger.Debug("trying to retrieve mapped Message in Telegram",
+			zap.String("event_id", v.Info.ID),
+		)
+		tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(account.Name, contextInfo.GetStanzaID(), v.Info.Chat.String())
+		if err == nil && tgChatId == targetChatId {
+			m.replyToMsgId = tgMsgId
+			m.threadId = tgThreadId
+			threadIdFound = true
+		} else if contextInfo.GetStanzaID() != "" && contextInfo.GetQuotedMessage() != nil {
+			// The quoted message was never bridged, so it is shown along with the reply
+			m.header += m.quoteHtml(contextInfo)
 		}
+	}
 
-		if mentioned := v.Message.GetExtendedTextMessage().GetContextInfo().GetMentionedJID(); mentioned != nil {
-			for _, jid := range mentioned {
-				parsedJid, _ := utils.WaParseJID(jid)
-				name := utils.WaGetContactName(parsedJid)
-				// text = strings.ReplaceAll(text, "@"+parsedJid.User, "@("+html.EscapeString(name)+")")
-				bridgedText = strings.ReplaceAll(
-					bridgedText, "@"+parsedJid.User,
-					fmt.Sprintf(
-						"<a href=\"https://wa.me/%s\">@%s</a>",
-						parsedJid.User, html.EscapeString(name),
-					),
-				)
-			}
-		}
-		sentMsg, err := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-			ReplyParameters: &gotgbot.ReplyParameters{
-				MessageId: replyToMsgId,
-			},
-			MessageThreadId: threadId,
-		})
-		if err != nil {
-			panic(fmt.Errorf("failed to send telegram message: %s", err))
-		}
-		if sentMsg.MessageId != 0 {
-			database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-				cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-		}
+	if !strings.HasSuffix(m.header, "\n\n") {
+		m.header += "\n"
+	}
+
+	if editedTgMsgId != 0 && renderWaEdit(m, v, editedTgMsgId) {
+		return
+	}
+
+	if !isEdited && renderWaDecrypted(m, v) {
+		return
+	}
+
+	if !threadIdFound && !m.findThread() {
 		return
 	}
+
+	renderWaMessage(m, v)
 }
 
-func UndecryptableMessageEventHandler(v *events.UndecryptableMessage) {
+func UndecryptableMessageEventHandler(account *state.WhatsAppAccount, v *events.UndecryptableMessage) {
 	var (
 		cfg    = state.State.Config
 		logger = state.State.Logger
-		tgBot  = state.State.TelegramBot
 		msgId  = v.Info.ID
 	)
 	defer logger.Sync()
 
-	if v.UnavailableType != events.UnavailableTypeViewOnce {
+	targetChatId := waTargetChatId(account, v.Info)
+
+	if v.DecryptFailMode == events.DecryptFailHide {
+		// Such messages are not shown by WhatsApp either, like reactions and poll votes
 		return
-	} else if slices.Contains(cfg.WhatsApp.IgnoreChats, v.Info.Chat.User) {
-		logger.Debug("returning because message from an ignored chat",
+	} else if v.Info.IsFromMe && !cfg.WhatsApp.SendMyMessagesFromOtherDevices {
+		return
+	} else if v.Info.Chat.String() == "status@broadcast" &&
+		(cfg.WhatsApp.SkipStatus ||
+			slices.Contains(cfg.WhatsApp.StatusIgnoredChats, v.Info.MessageSource.Sender.User)) {
+		logger.Debug("returning because status from a ignored chat",
 			zap.String("event_id", v.Info.ID),
 			zap.String("chat_jid", v.Info.Chat.String()),
 		)
 		return
-	}
-
-	var bridgedText string
-	if cfg.WhatsApp.SkipChatDetails {
-		logger.Debug("skipping to add chat details as configured",
+	} else if slices.Contains(cfg.WhatsApp.IgnoreChats, v.Info.Chat.User) {
+		logger.Debug("returning because message from an ignored chat",
 			zap.String("event_id", v.Info.ID),
+			zap.String("chat_jid", v.Info.Chat.String()),
 		)
-		if v.Info.IsIncomingBroadcast() {
-			bridgedText += "👥: <b>(Broadcast)</b>\n"
-		} else if v.Info.IsFromMe {
-			bridgedText += "🧑: <b>You [other device]</b>\n"
-		} else if v.Info.IsGroup {
-			bridgedText += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
-		}
-
-	} else {
-
-		if v.Info.IsFromMe {
-			bridgedText += "🧑: <b>You [other device]</b>\n"
-		} else {
-			bridgedText += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(v.Info.MessageSource.Sender)))
-		}
-		if v.Info.IsIncomingBroadcast() {
-			bridgedText += "👥: <b>(Broadcast)</b>\n"
-		} else if v.Info.IsGroup {
-			bridgedText += fmt.Sprintf("👥: <b>%s</b>\n", html.EscapeString(utils.WaGetGroupName(v.Info.Chat)))
-		} else {
-			bridgedText += "👥: <b>(PVT)</b>\n"
-		}
-
+		return
 	}
 
-	if time.Since(v.Info.Timestamp).Seconds() > 60 {
-		bridgedText += fmt.Sprintf("🕛: <b>%s</b>\n",
-			html.EscapeString(v.Info.Timestamp.In(state.State.LocalLocation).Format(cfg.TimeFormat)))
+	m := newBridgeMessage(account, v.Info, msgId, "", false, targetChatId)
+	if !m.findThread() {
+		return
 	}
 
-	bridgedText += "\n<i>It is a View Once message.\nPlease check in your official WhatsApp application</i>"
-
-	var threadId int64
-
-	var err error
-	if v.Info.Chat.String() == "status@broadcast" {
-		threadId, _, err = utils.TgGetOrMakeThreadFromWa_String("status@broadcast", cfg.Telegram.TargetChatID,
-			"Status")
-		if err != nil {
-			utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "failed to create/find thread id for 'status@broadcast'", err)
-			return
-		}
-	} else if v.Info.IsIncomingBroadcast() {
-		if (v.Info.MessageSource.AddressingMode == waTypes.AddressingModePN) || v.Info.MessageSource.SenderAlt.IsEmpty() {
-			threadId, _, err = utils.TgGetOrMakeThreadFromWa(v.Info.MessageSource.Sender.ToNonAD(), cfg.Telegram.TargetChatID,
-				utils.WaGetContactName(v.Info.MessageSource.Sender.ToNonAD()))
-		} else {
-			threadId, _, err = utils.TgGetOrMakeThreadFromWa(v.Info.MessageSource.SenderAlt.ToNonAD(), cfg.Telegram.TargetChatID,
-				utils.WaGetContactName(v.Info.MessageSource.SenderAlt.ToNonAD()))
-		}
-
-		if err != nil {
-			utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, fmt.Sprintf("failed to create/find thread id for '%s'",
-				v.Info.MessageSource.Sender.ToNonAD().String()), err)
-			return
-		}
-	} else if v.Info.IsGroup {
-		threadId, _, err = utils.TgGetOrMakeThreadFromWa(v.Info.Chat, cfg.Telegram.TargetChatID,
-			utils.WaGetGroupName(v.Info.Chat))
-		if err != nil {
-			utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, fmt.Sprintf("failed to create/find thread id for '%s'",
-				v.Info.Chat.String()), err)
-			return
-		}
-	} else {
-		target_chat_jid := v.Info.Chat.ToNonAD()
-
-		threadId, _, err = utils.TgGetOrMakeThreadFromWa(target_chat_jid, cfg.Telegram.TargetChatID, utils.WaGetContactName(target_chat_jid))
-		if err != nil {
-			utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, fmt.Sprintf("failed to create/find thread id for '%s'",
-				target_chat_jid.String()), err)
-			return
-		}
+	if v.UnavailableType == events.UnavailableTypeViewOnce {
+		m.sendNotice("It is a View Once message.\nPlease check in your official WhatsApp application")
+		return
 	}
 
-	sentMsg, err := tgBot.SendMessage(cfg.Telegram.TargetChatID, bridgedText, &gotgbot.SendMessageOpts{
-		MessageThreadId: threadId,
-	})
-	if err != nil {
-		panic(fmt.Errorf("failed to send telegram message: %s", err))
-	}
-	if sentMsg.MessageId != 0 {
-		database.MsgIdAddNewPair(msgId, v.Info.MessageSource.Sender.String(), v.Info.Chat.String(),
-			cfg.Telegram.TargetChatID, sentMsg.MessageId, sentMsg.MessageThreadId)
-	}
+	m.sendUndecryptablePlaceholder()
 }
 
-func CallOfferEventHandler(v *events.CallOffer) {
+func CallOfferEventHandler(account *state.WhatsAppAccount, v *events.CallOffer) {
 	var (
 		cfg   = state.State.Config
 		tgBot = state.State.TelegramBot
 	)
 
 	// TODO : Check and handle group calls
-	callerName := utils.WaGetContactName(v.CallCreator)
+	callerName := utils.WaGetContactName(account, v.CallCreator)
 
-	callThreadId, _, err := utils.TgGetOrMakeThreadFromWa_String("calls", cfg.Telegram.TargetChatID, "Calls")
+	callThreadId, _, err := utils.TgGetOrMakeThreadFromWa_String(account, "calls", account.TargetChatID, "Calls")
 	if err != nil {
-		utils.TgSendErrorById(tgBot, cfg.Telegram.TargetChatID, 0, "Failed to create/retreive corresponding thread id for calls", err)
+		utils.TgSendErrorById(tgBot, account.TargetChatID, 0, "Failed to create/retreive corresponding thread id for calls", err)
 		return
 	}
 
 	bridgeText := fmt.Sprintf("#calls\n\n🧑: <b>%s</b>\n🕛: <b>%s</b>\n\n<i>You received a new call</i>",
 		html.EscapeString(callerName), html.EscapeString(v.Timestamp.In(state.State.LocalLocation).Format(cfg.TimeFormat)))
 
-	utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, callThreadId, bridgeText)
+	utils.TgSendTextById(tgBot, account.TargetChatID, callThreadId, bridgeText)
 }
 
-func ReceiptEventHandler(v *events.Receipt) {
+func ReceiptEventHandler(account *state.WhatsAppAccount, v *events.Receipt) {
 	if v.Type == waTypes.ReceiptTypeReadSelf {
 		for _, msgId := range v.MessageIDs {
-			database.MsgIdMarkRead(v.Chat.String(), msgId)
+			database.MsgIdMarkRead(account.Name, v.Chat.String(), msgId)
 		}
 	}
 }
 
-func PushNameEventHandler(v *events.PushName) {
+func PushNameEventHandler(account *state.WhatsAppAccount, v *events.PushName) {
 	logger := state.State.Logger
 	defer logger.Sync()
 
@@ -1611,11 +532,12 @@ func PushNameEventHandler(v *events.PushName) {
 	database.ContactUpdatePushName(v.JID.User, v.JID.Server, v.NewPushName)
 }
 
-func UserAboutEventHandler(v *events.UserAbout) {
+func UserAboutEventHandler(account *state.WhatsAppAccount, v *events.UserAbout) {
 	var (
-		cfg    = state.State.Config
-		logger = state.State.Logger
-		tgBot  = state.State.TelegramBot
+		cfg          = state.State.Config
+		logger       = state.State.Logger
+		tgBot        = state.State.TelegramBot
+		targetChatId = utils.TgTargetChatForWa(account, v.JID)
 	)
 
 	if cfg.WhatsApp.SkipUserAboutUpdates {
@@ -1635,19 +557,19 @@ func UserAboutEventHandler(v *events.UserAbout) {
 		zap.Time("updated_at", v.Timestamp),
 	)
 
-	SendUserAboutMessageUpdateToInfoThread(v, cfg, logger, tgBot, false)
+	SendUserAboutMessageUpdateToInfoThread(account, v, cfg, logger, tgBot, false)
 
-	tgThreadId, threadFound, err := utils.TgGetOrMakeThreadFromWa(v.JID.ToNonAD(), cfg.Telegram.TargetChatID, utils.WaGetGroupName(v.JID))
+	tgThreadId, threadFound, err := utils.TgGetOrMakeThreadFromWa(account, v.JID.ToNonAD(), targetChatId, utils.WaGetGroupName(account, v.JID))
 	if err != nil {
 		logger.Warn(
 			"failed to create a new thread for a WhatsApp chat (handling UserAbout event)",
 			zap.String("chat", v.JID.String()),
 			zap.Error(err),
 		)
-		SendUserAboutMessageUpdateToInfoThread(v, cfg, logger, tgBot, true)
+		SendUserAboutMessageUpdateToInfoThread(account, v, cfg, logger, tgBot, true)
 		return
 	}
-	SendProfilePictureToNewThread(threadFound, tgThreadId, v.JID.ToNonAD())
+	SendProfilePictureToNewThread(account, targetChatId, threadFound, tgThreadId, v.JID.ToNonAD())
 
 	updateMessageText := "User's about message was updated"
 	if time.Since(v.Timestamp).Seconds() > 60 {
@@ -1666,22 +588,23 @@ func UserAboutEventHandler(v *events.UserAbout) {
 	updateMessageText += fmt.Sprintf("<code>%s</code>", html.EscapeString(v.Status))
 
 	tgBot.SendMessage(
-		cfg.Telegram.TargetChatID,
+		targetChatId,
 		updateMessageText,
 		&gotgbot.SendMessageOpts{MessageThreadId: tgThreadId},
 	)
 }
 
-func SendUserAboutMessageUpdateToInfoThread(v *events.UserAbout, cfg *state.Config, logger *zap.Logger, tgBot *gotgbot.Bot, override bool) bool {
+func SendUserAboutMessageUpdateToInfoThread(account *state.WhatsAppAccount, v *events.UserAbout, cfg *state.Config, logger *zap.Logger, tgBot *gotgbot.Bot, override bool) bool {
 	if cfg.WhatsApp.CreateThreadForInfoUpdates || override {
 		var updateMessageText string
 
-		changer := utils.WaGetContactName(v.JID.ToNonAD())
+		targetChatId := utils.TgTargetChatForWa(account, v.JID)
+		changer := utils.WaGetContactName(account, v.JID.ToNonAD())
 
 		if override {
 			updateMessageText += "<b>User About Override/No Thread Found</b> \n\n"
 		}
-		tgThreadId, _, err := utils.TgGetOrMakeThreadFromWa(v.JID.ToNonAD(), cfg.Telegram.TargetChatID, utils.WaGetGroupName(v.JID))
+		tgThreadId, _, err := utils.TgGetOrMakeThreadFromWa(account, v.JID.ToNonAD(), targetChatId, utils.WaGetGroupName(account, v.JID))
 		if err != nil {
 			logger.Warn(
 				"failed to create a new thread for a WhatsApp chat (handling UserAbout event)",
@@ -1708,7 +631,7 @@ func SendUserAboutMessageUpdateToInfoThread(v *events.UserAbout, cfg *state.Conf
 		updateMessageText += fmt.Sprintf("<code>%s</code>", html.EscapeString(v.Status))
 
 		tgBot.SendMessage(
-			cfg.Telegram.TargetChatID,
+			targetChatId,
 			updateMessageText,
 			&gotgbot.SendMessageOpts{MessageThreadId: tgThreadId},
 		)
@@ -1717,7 +640,7 @@ func SendUserAboutMessageUpdateToInfoThread(v *events.UserAbout, cfg *state.Conf
 	return false
 }
 
-func RevokedMessageEventHandler(v *events.Message) {
+func RevokedMessageEventHandler(account *state.WhatsAppAccount, v *events.Message) {
 	var (
 		cfg         = state.State.Config
 		tgBot       = state.State.TelegramBot
@@ -1726,7 +649,7 @@ func RevokedMessageEventHandler(v *events.Message) {
 		waChatId    = v.Info.Chat.String()
 	)
 
-	if !cfg.WhatsApp.SkipRevokedMessage {
+	if cfg.WhatsApp.SkipRevokedMessage {
 		return
 	}
 
@@ -1736,18 +659,28 @@ func RevokedMessageEventHandler(v *events.Message) {
 	if v.Info.IsFromMe {
 		deleterName = "you"
 	} else {
-		deleterName = utils.WaGetContactName(deleter)
+		deleterName = utils.WaGetContactName(account, deleter)
 	}
 
-	tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(waMsgId, waChatId)
+	tgChatId, tgThreadId, tgMsgId, err := database.MsgIdGetTgFromWa(account.Name, waMsgId, waChatId)
 	if err != nil || tgChatId == 0 || tgThreadId == 0 || tgMsgId == 0 {
 		return
 	}
 
-	tgBot.SendMessage(tgChatId, fmt.Sprintf(
-		"<i>This message was revoked by %s</i>",
-		html.EscapeString(deleterName),
-	), &gotgbot.SendMessageOpts{
+	note := fmt.Sprintf("<i>This message was revoked by %s</i>", html.EscapeString(deleterName))
+
+	switch waRevokePolicy(account, v.Info.Chat) {
+	case state.RevokePolicyStrike:
+		if waRevokeByStrike(account, waMsgId, waChatId, tgChatId, tgMsgId, note) {
+			return
+		}
+	case state.RevokePolicyDelete:
+		if waRevokeByDelete(account, v.Info.Chat, tgChatId, tgMsgId, note) {
+			return
+		}
+	}
+
+	tgBot.SendMessage(tgChatId, note, &gotgbot.SendMessageOpts{
 		MessageThreadId: tgThreadId,
 		ReplyParameters: &gotgbot.ReplyParameters{
 			MessageId: tgMsgId,
@@ -1755,12 +688,56 @@ func RevokedMessageEventHandler(v *events.Message) {
 	})
 }
 
-func PictureEventHandler(v *events.Picture) {
+func LabelEditEventHandler(account *state.WhatsAppAccount, v *events.LabelEdit) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	var err error
+	if v.Action.GetDeleted() {
+		err = database.WaLabelDelete(account.Name, v.LabelID)
+	} else {
+		err = database.WaLabelAddOrUpdate(account.Name, v.LabelID, v.Action.GetName())
+	}
+	if err != nil {
+		logger.Error("failed to update label in database",
+			zap.String("label_id", v.LabelID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	utils.TgForgetRoutes(account)
+}
+
+func LabelAssociationChatEventHandler(account *state.WhatsAppAccount, v *events.LabelAssociationChat) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	var err error
+	if v.Action.GetLabeled() {
+		err = database.WaChatLabelAdd(account.Name, v.JID.ToNonAD().String(), v.LabelID)
+	} else {
+		err = database.WaChatLabelDelete(account.Name, v.JID.ToNonAD().String(), v.LabelID)
+	}
+	if err != nil {
+		logger.Error("failed to update label of chat in database",
+			zap.String("chat", v.JID.String()),
+			zap.String("label_id", v.LabelID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	utils.TgForgetRoute(account, v.JID)
+}
+
+func PictureEventHandler(account *state.WhatsAppAccount, v *events.Picture) {
 	var (
-		cfg      = state.State.Config
-		logger   = state.State.Logger
-		tgBot    = state.State.TelegramBot
-		waClient = state.State.WhatsAppClient
+		cfg          = state.State.Config
+		logger       = state.State.Logger
+		tgBot        = state.State.TelegramBot
+		waClient     = account.Client
+		targetChatId = utils.TgTargetChatForWa(account, v.JID)
 	)
 	defer logger.Sync()
 
@@ -1774,10 +751,10 @@ func PictureEventHandler(v *events.Picture) {
 	if v.JID.Server == waTypes.HiddenUserServer {
 		pn, err = waClient.Store.LIDs.GetPNForLID(context.Background(), v.JID.ToNonAD())
 		if err == nil {
-			tgThreadId, threadFound, err = database.ChatThreadGetTgFromWa(pn.String(), cfg.Telegram.TargetChatID)
+			tgThreadId, threadFound, err = database.ChatThreadGetTgFromWa(account.Name, pn.String(), targetChatId)
 		}
 	} else {
-		tgThreadId, threadFound, err = database.ChatThreadGetTgFromWa(v.JID.ToNonAD().String(), cfg.Telegram.TargetChatID)
+		tgThreadId, threadFound, err = database.ChatThreadGetTgFromWa(account.Name, v.JID.ToNonAD().String(), targetChatId)
 	}
 	if err != nil {
 		logger.Warn(
@@ -1798,7 +775,7 @@ func PictureEventHandler(v *events.Picture) {
 	}
 
 	if v.JID.Server == waTypes.GroupServer {
-		tgThreadId, _, err = utils.TgGetOrMakeThreadFromWa(v.JID.ToNonAD(), cfg.Telegram.TargetChatID, utils.WaGetGroupName(v.JID))
+		tgThreadId, _, err = utils.TgGetOrMakeThreadFromWa(account, v.JID.ToNonAD(), targetChatId, utils.WaGetGroupName(account, v.JID))
 		if err != nil {
 			logger.Warn(
 				"failed to create a new thread for a WhatsApp chat (handling Picture event)",
@@ -1807,11 +784,11 @@ func PictureEventHandler(v *events.Picture) {
 			)
 			return
 		}
-		changer := utils.WaGetContactName(v.Author)
+		changer := utils.WaGetContactName(account, v.Author)
 		if v.Remove {
 			updateText := fmt.Sprintf("The profile picture was removed by %s", html.EscapeString(changer))
 			err = utils.TgSendTextById(
-				tgBot, cfg.Telegram.TargetChatID, tgThreadId,
+				tgBot, targetChatId, tgThreadId,
 				updateText,
 			)
 			if err != nil {
@@ -1841,7 +818,7 @@ func PictureEventHandler(v *events.Picture) {
 				return
 			}
 
-			_, err = tgBot.SendPhoto(cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
+			_, err = tgBot.SendPhoto(targetChatId, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
 				MessageThreadId: tgThreadId,
 				Caption:         fmt.Sprintf("The profile picture was updated by %s", html.EscapeString(changer)),
 			})
@@ -1851,7 +828,7 @@ func PictureEventHandler(v *events.Picture) {
 			}
 		}
 	} else if v.JID.Server == waTypes.DefaultUserServer {
-		tgThreadId, _, err = utils.TgGetOrMakeThreadFromWa(v.JID.ToNonAD(), cfg.Telegram.TargetChatID, utils.WaGetContactName(v.JID.ToNonAD()))
+		tgThreadId, _, err = utils.TgGetOrMakeThreadFromWa(account, v.JID.ToNonAD(), targetChatId, utils.WaGetContactName(account, v.JID.ToNonAD()))
 		if err != nil {
 			logger.Warn(
 				"failed to create a new thread for a WhatsApp chat (handling Picture event)",
@@ -1863,7 +840,7 @@ func PictureEventHandler(v *events.Picture) {
 		if v.Remove {
 			updateText := "The profile picture was removed"
 			err = utils.TgSendTextById(
-				tgBot, cfg.Telegram.TargetChatID, tgThreadId,
+				tgBot, targetChatId, tgThreadId,
 				updateText,
 			)
 			if err != nil {
@@ -1893,7 +870,7 @@ func PictureEventHandler(v *events.Picture) {
 				return
 			}
 
-			_, err = tgBot.SendPhoto(cfg.Telegram.TargetChatID, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
+			_, err = tgBot.SendPhoto(targetChatId, &gotgbot.FileReader{Data: bytes.NewReader(newPictureBytes)}, &gotgbot.SendPhotoOpts{
 				MessageThreadId: tgThreadId,
 				Caption:         "The profile picture was updated",
 			})
@@ -1910,12 +887,13 @@ func PictureEventHandler(v *events.Picture) {
 	}
 }
 
-func GroupInfoEventHandler(v *events.GroupInfo) {
+func GroupInfoEventHandler(account *state.WhatsAppAccount, v *events.GroupInfo) {
 	var (
-		cfg      = state.State.Config
-		logger   = state.State.Logger
-		tgBot    = state.State.TelegramBot
-		waClient = state.State.WhatsAppClient
+		cfg          = state.State.Config
+		logger       = state.State.Logger
+		tgBot        = state.State.TelegramBot
+		waClient     = account.Client
+		targetChatId = utils.TgTargetChatForWa(account, v.JID)
 	)
 	defer logger.Sync()
 
@@ -1929,10 +907,10 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 	if v.JID.Server == waTypes.HiddenUserServer {
 		pn, err = waClient.Store.LIDs.GetPNForLID(context.Background(), v.JID.ToNonAD())
 		if err == nil {
-			tgThreadId, threadFound, err = database.ChatThreadGetTgFromWa(pn.String(), cfg.Telegram.TargetChatID)
+			tgThreadId, threadFound, err = database.ChatThreadGetTgFromWa(account.Name, pn.String(), targetChatId)
 		}
 	} else {
-		tgThreadId, threadFound, err = database.ChatThreadGetTgFromWa(v.JID.ToNonAD().String(), cfg.Telegram.TargetChatID)
+		tgThreadId, threadFound, err = database.ChatThreadGetTgFromWa(account.Name, v.JID.ToNonAD().String(), targetChatId)
 	}
 	if err != nil {
 		logger.Warn(
@@ -1948,7 +926,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 			zap.String("chat", v.JID.String()),
 		)
 		if cfg.WhatsApp.CreateThreadForInfoUpdates {
-			tgThreadId, _, err = utils.TgGetOrMakeThreadFromWa(v.JID.ToNonAD(), cfg.Telegram.TargetChatID, utils.WaGetGroupName(v.JID))
+			tgThreadId, _, err = utils.TgGetOrMakeThreadFromWa(account, v.JID.ToNonAD(), targetChatId, utils.WaGetGroupName(account, v.JID))
 			if err != nil {
 				logger.Warn(
 					"failed to create a new thread for a WhatsApp chat (handling GroupInfo event)",
@@ -1965,7 +943,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 	if v.Announce != nil {
 		var authorInfo string
 		if v.Sender != nil {
-			authorName := utils.WaGetContactName(*v.Sender)
+			authorName := utils.WaGetContactName(account, *v.Sender)
 			authorInfo = fmt.Sprintf(" by %s", html.EscapeString(authorName))
 		}
 
@@ -1975,7 +953,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 		} else {
 			updateText = fmt.Sprintf("Group settings have been changed%s, everybody can send messages now", authorInfo)
 		}
-		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, updateText)
+		err = utils.TgSendTextById(tgBot, targetChatId, tgThreadId, updateText)
 		if err != nil {
 			logger.Error("failed to send message", zap.Error(err))
 		}
@@ -1984,7 +962,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 	if v.Ephemeral != nil {
 		var authorInfo string
 		if v.Sender != nil {
-			authorName := utils.WaGetContactName(*v.Sender)
+			authorName := utils.WaGetContactName(account, *v.Sender)
 			authorInfo = fmt.Sprintf(" by %s", html.EscapeString(authorName))
 		}
 
@@ -2003,7 +981,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 				updateText += fmt.Sprintf("Failed to save to DB: %s", html.EscapeString(err.Error()))
 			}
 		}
-		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, updateText)
+		err = utils.TgSendTextById(tgBot, targetChatId, tgThreadId, updateText)
 		if err != nil {
 			logger.Error("failed to send message", zap.Error(err))
 		}
@@ -2012,7 +990,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 	if v.Delete != nil {
 		var authorInfo string
 		if v.Sender != nil {
-			authorName := utils.WaGetContactName(*v.Sender)
+			authorName := utils.WaGetContactName(account, *v.Sender)
 			authorInfo = fmt.Sprintf(" by %s", html.EscapeString(authorName))
 		}
 
@@ -2024,7 +1002,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 			)
 		}
 		err = utils.TgSendTextById(
-			tgBot, cfg.Telegram.TargetChatID, tgThreadId,
+			tgBot, targetChatId, tgThreadId,
 			"The group has been deleted",
 		)
 		if err != nil {
@@ -2035,12 +1013,12 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 	if len(v.Join) > 0 {
 		var adderName string
 		if v.Sender != nil {
-			adderName = utils.WaGetContactName(*v.Sender)
+			adderName = utils.WaGetContactName(account, *v.Sender)
 		}
 
 		var updateText string
 		if len(v.Join) == 1 {
-			newMemName := utils.WaGetContactName(v.Join[0])
+			newMemName := utils.WaGetContactName(account, v.Join[0])
 			if v.Sender != nil && *v.Sender != v.Join[0] {
 				updateText = fmt.Sprintf("%s was added by %s to the group\n", html.EscapeString(newMemName), html.EscapeString(adderName))
 			} else {
@@ -2049,7 +1027,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 		} else {
 			updateText = "The following people joined the group:\n"
 			for _, newMem := range v.Join {
-				newMemName := utils.WaGetContactName(newMem)
+				newMemName := utils.WaGetContactName(account, newMem)
 				if v.Sender != nil && *v.Sender != newMem {
 					updateText += fmt.Sprintf("- %s (added by %s)\n", html.EscapeString(newMemName), html.EscapeString(adderName))
 				} else {
@@ -2060,7 +1038,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 		if v.JoinReason != "" {
 			updateText += fmt.Sprintf("\nReason: %s", html.EscapeString(v.JoinReason))
 		}
-		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, updateText)
+		err = utils.TgSendTextById(tgBot, targetChatId, tgThreadId, updateText)
 		if err != nil {
 			logger.Error("failed to send message", zap.Error(err))
 		}
@@ -2069,12 +1047,12 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 	if len(v.Leave) > 0 {
 		var removerName string
 		if v.Sender != nil {
-			removerName = utils.WaGetContactName(*v.Sender)
+			removerName = utils.WaGetContactName(account, *v.Sender)
 		}
 
 		var updateText string
 		if len(v.Leave) == 1 {
-			oldMemName := utils.WaGetContactName(v.Leave[0])
+			oldMemName := utils.WaGetContactName(account, v.Leave[0])
 			if v.Sender != nil && *v.Sender == v.Leave[0] {
 				updateText = fmt.Sprintf("%s left the group\n", html.EscapeString(oldMemName))
 			} else {
@@ -2083,7 +1061,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 		} else {
 			updateText = "The following people left the group:\n"
 			for _, oldMem := range v.Leave {
-				oldMemName := utils.WaGetContactName(oldMem)
+				oldMemName := utils.WaGetContactName(account, oldMem)
 				if v.Sender != nil && *v.Sender != oldMem {
 					updateText += fmt.Sprintf("- %s (kicked by %s)\n", html.EscapeString(oldMemName), html.EscapeString(removerName))
 				} else {
@@ -2091,7 +1069,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 				}
 			}
 		}
-		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, updateText)
+		err = utils.TgSendTextById(tgBot, targetChatId, tgThreadId, updateText)
 		if err != nil {
 			logger.Error("failed to send message", zap.Error(err))
 		}
@@ -2102,11 +1080,11 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 
 		var demoterName string
 		if v.Sender != nil {
-			demoterName = utils.WaGetContactName(*v.Sender)
+			demoterName = utils.WaGetContactName(account, *v.Sender)
 		}
 
 		if len(v.Demote) == 1 {
-			demotedMemName := utils.WaGetContactName(v.Demote[0])
+			demotedMemName := utils.WaGetContactName(account, v.Demote[0])
 			updateText = fmt.Sprintf("%s was demoted in the group", html.EscapeString(demotedMemName))
 			if demoterName != "" {
 				updateText += fmt.Sprintf(" by %s", html.EscapeString(demoterName))
@@ -2119,11 +1097,11 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 			}
 			updateText += ":\n"
 			for _, demotedMem := range v.Demote {
-				demotedMemName := utils.WaGetContactName(demotedMem)
+				demotedMemName := utils.WaGetContactName(account, demotedMem)
 				updateText += fmt.Sprintf("- %s\n", demotedMemName)
 			}
 		}
-		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, updateText)
+		err = utils.TgSendTextById(tgBot, targetChatId, tgThreadId, updateText)
 		if err != nil {
 			logger.Error("failed to send message", zap.Error(err))
 		}
@@ -2134,11 +1112,11 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 
 		var promoterName string
 		if v.Sender != nil {
-			promoterName = utils.WaGetContactName(*v.Sender)
+			promoterName = utils.WaGetContactName(account, *v.Sender)
 		}
 
 		if len(v.Promote) == 1 {
-			promotedMemName := utils.WaGetContactName(v.Promote[0])
+			promotedMemName := utils.WaGetContactName(account, v.Promote[0])
 			updateText = fmt.Sprintf("%s was promoted in the group", html.EscapeString(promotedMemName))
 			if promoterName != "" {
 				updateText += fmt.Sprintf(" by %s", html.EscapeString(promoterName))
@@ -2151,24 +1129,24 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 			}
 			updateText += ":\n"
 			for _, promotedMem := range v.Promote {
-				promotedMemName := utils.WaGetContactName(promotedMem)
+				promotedMemName := utils.WaGetContactName(account, promotedMem)
 				updateText += fmt.Sprintf("- %s\n", html.EscapeString(promotedMemName))
 			}
 		}
-		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, updateText)
+		err = utils.TgSendTextById(tgBot, targetChatId, tgThreadId, updateText)
 		if err != nil {
 			logger.Error("failed to send message", zap.Error(err))
 		}
 	}
 
 	if v.Topic != nil {
-		changer := utils.WaGetContactName(v.Topic.TopicSetBy)
+		changer := utils.WaGetContactName(account, v.Topic.TopicSetBy)
 		updateText := fmt.Sprintf(
 			"The group description was changed by <b>%s</b>:\n\n<code>%s</code>",
 			html.EscapeString(changer),
 			html.EscapeString(v.Topic.Topic),
 		)
-		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, updateText)
+		err = utils.TgSendTextById(tgBot, targetChatId, tgThreadId, updateText)
 		if err != nil {
 			logger.Error("failed to send message", zap.Error(err))
 		}
@@ -2176,7 +1154,7 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 
 	if v.Name != nil {
 		_, err = tgBot.EditForumTopic(
-			cfg.Telegram.TargetChatID, tgThreadId,
+			targetChatId, tgThreadId,
 			&gotgbot.EditForumTopicOpts{
 				Name: v.Name.Name,
 			},
@@ -2190,20 +1168,20 @@ func GroupInfoEventHandler(v *events.GroupInfo) {
 			)
 			return
 		}
-		changer := utils.WaGetContactName(v.Name.NameSetBy)
+		changer := utils.WaGetContactName(account, v.Name.NameSetBy)
 		updateText := fmt.Sprintf(
 			"The group name was changed by <b>%s</b>:\n\n<code>%s</code>",
 			html.EscapeString(changer),
 			html.EscapeString(v.Name.Name),
 		)
-		err = utils.TgSendTextById(tgBot, cfg.Telegram.TargetChatID, tgThreadId, updateText)
+		err = utils.TgSendTextById(tgBot, targetChatId, tgThreadId, updateText)
 		if err != nil {
 			logger.Error("failed to send message", zap.Error(err))
 		}
 	}
 }
 
-func LogoutHandler(v *events.LoggedOut) {
+func LogoutHandler(account *state.WhatsAppAccount, v *events.LoggedOut) {
 	var (
 		cfg    = state.State.Config
 		logger = state.State.Logger
@@ -2211,14 +1189,14 @@ func LogoutHandler(v *events.LoggedOut) {
 	)
 	defer logger.Sync()
 
-	updateText := "You have been logged out from WhatsApp:\n\n"
+	updateText := fmt.Sprintf("You have been logged out from %s:\n\n", html.EscapeString(utils.WaAccountTitle(account.Name)))
 	updateText += fmt.Sprintf("<b>Reason:</b> %s", html.EscapeString(v.Reason.String()))
 
 	utils.TgSendTextById(tgBot, cfg.Telegram.OwnerID, 0, updateText)
 }
 
-func InitialSyncContactsHandler() {
-	waClient := state.State.WhatsAppClient
+func InitialSyncContactsHandler(account *state.WhatsAppAccount) {
+	waClient := account.Client
 	waClient.IsConnected()
 	logger := state.State.Logger
 
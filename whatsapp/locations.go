@@ -0,0 +1,103 @@
+package whatsapp
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// WhatsApp shares live locations for 8 hours at most, the Telegram live location lasts as long
+const waLiveLocationPeriod = 8 * time.Hour
+
+// renderWaLiveLocation sends the live location to Telegram, or moves the one already sent while
+// the sender keeps sharing it
+func renderWaLiveLocation(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg          = state.State.Config
+		logger       = state.State.Logger
+		tgBot        = state.State.TelegramBot
+		liveLocation = v.Message.GetLiveLocationMessage()
+		senderId     = m.info.Sender.ToNonAD().String()
+	)
+	defer logger.Sync()
+
+	if cfg.WhatsApp.SkipLocations {
+		m.sendText("\n<i>Shared their live location with you</i>" +
+			"\n<i>Skipping live location because 'skip_locations' set in config file</i>")
+		return
+	}
+
+	share, found, err := database.WaLiveLocationGet(m.account.Name, m.info.Chat.String(), senderId)
+	if err != nil {
+		logger.Error("failed to get live location from database",
+			zap.String("sender", senderId),
+			zap.Error(err),
+		)
+	}
+
+	if found && time.Now().Before(share.ExpiresAt) {
+		if liveLocation.GetSequenceNumber() != 0 && liveLocation.GetSequenceNumber() <= share.SequenceNumber {
+			return
+		}
+
+		_, _, err = tgBot.EditMessageLiveLocation(liveLocation.GetDegreesLatitude(), liveLocation.GetDegreesLongitude(),
+			&gotgbot.EditMessageLiveLocationOpts{
+				ChatId:             share.TgChatId,
+				MessageId:          share.TgMsgId,
+				HorizontalAccuracy: float64(liveLocation.GetAccuracyInMeters()),
+				Heading:            int64(liveLocation.GetDegreesClockwiseFromMagneticNorth()),
+			})
+		if err == nil || strings.Contains(err.Error(), "message is not modified") {
+			share.SequenceNumber = liveLocation.GetSequenceNumber()
+			if err = database.WaLiveLocationSet(&share); err != nil {
+				logger.Error("failed to update live location in database",
+					zap.String("sender", senderId),
+					zap.Error(err),
+				)
+			}
+			return
+		}
+
+		logger.Warn("failed to move bridged live location, sending a new one",
+			zap.Int64("tg_msg_id", share.TgMsgId),
+			zap.Error(err),
+		)
+	}
+
+	sentMsg, err := m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendLocationWithContext(ctx, m.targetChatId, liveLocation.GetDegreesLatitude(), liveLocation.GetDegreesLongitude(),
+			&gotgbot.SendLocationOpts{
+				LivePeriod:         int64(waLiveLocationPeriod.Seconds()),
+				HorizontalAccuracy: float64(liveLocation.GetAccuracyInMeters()),
+				Heading:            int64(liveLocation.GetDegreesClockwiseFromMagneticNorth()),
+				ReplyParameters:    m.replyParameters(),
+				MessageThreadId:    m.threadId,
+			})
+	})
+	if err != nil || sentMsg == nil {
+		return
+	}
+
+	err = database.WaLiveLocationSet(&database.WaLiveLocation{
+		Account:        m.account.Name,
+		WaChatId:       m.info.Chat.String(),
+		SenderId:       senderId,
+		TgChatId:       sentMsg.Chat.Id,
+		TgMsgId:        sentMsg.MessageId,
+		SequenceNumber: liveLocation.GetSequenceNumber(),
+		ExpiresAt:      time.Now().Add(waLiveLocationPeriod),
+	})
+	if err != nil {
+		logger.Error("failed to add live location to database",
+			zap.String("sender", senderId),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,492 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// bridgeMessage is a WhatsApp message reduced to what the Telegram renderers need, the
+// renderers only have to add the content itself
+type bridgeMessage struct {
+	account  *state.WhatsAppAccount
+	info     waTypes.MessageInfo
+	msgId    string // ID of the message, or of the original message for edits
+	text     string
+	isEdited bool
+
+	header       string // 🧑/👥/🕛 lines which precede the content
+	targetChatId int64
+	threadId     int64
+	replyToMsgId int64
+	replyMarkup  gotgbot.InlineKeyboardMarkup // Links to the sender on WhatsApp
+	mediaSuffix  string
+
+	version *database.MsgVersion // Kept for the edit history once the message is delivered
+}
+
+// waMediaMessage is implemented by all the WhatsApp messages carrying a file
+type waMediaMessage interface {
+	whatsmeow.DownloadableMessage
+	GetURL() string
+	GetFileLength() uint64
+	GetMimetype() string
+}
+
+func newBridgeMessage(account *state.WhatsAppAccount, info waTypes.MessageInfo, msgId, text string, isEdited bool,
+	targetChatId int64) *bridgeMessage {
+
+	cfg := state.State.Config
+
+	return &bridgeMessage{
+		account:      account,
+		info:         info,
+		msgId:        msgId,
+		text:         text,
+		isEdited:     isEdited,
+		header:       waMessageHeader(account, info, isEdited),
+		targetChatId: targetChatId,
+		replyMarkup: utils.TgBuildUrlButton(utils.WaGetContactName(account, info.Sender),
+			fmt.Sprintf("https://wa.me/%s", info.MessageSource.Sender.ToNonAD().User)),
+		mediaSuffix: "_" + info.Timestamp.Format(cfg.MediaTimeFormat) + "_",
+	}
+}
+
+// waTargetChatId returns the Telegram chat the message is routed to, messages to broadcast
+// lists are routed like the private chat with their sender
+func waTargetChatId(account *state.WhatsAppAccount, info waTypes.MessageInfo) int64 {
+	if info.IsIncomingBroadcast() {
+		return utils.TgTargetChatForWa(account, info.MessageSource.Sender)
+	}
+	return utils.TgTargetChatForWa(account, info.Chat)
+}
+
+// waMessageHeader returns the lines telling who sent the message where and when
+func waMessageHeader(account *state.WhatsAppAccount, info waTypes.MessageInfo, isEdited bool) string {
+	var (
+		cfg    = state.State.Config
+		header string
+	)
+
+	if cfg.WhatsApp.SkipChatDetails {
+		if info.IsIncomingBroadcast() {
+			header += "👥: <b>(Broadcast)</b>\n"
+		} else if info.IsFromMe {
+			header += "🧑: <b>You [other device]</b>\n"
+		} else if info.IsGroup {
+			header += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(account, info.MessageSource.Sender)))
+		}
+	} else {
+		if info.IsFromMe {
+			header += "🧑: <b>You [other device]</b>\n"
+		} else {
+			header += fmt.Sprintf("🧑: <b>%s</b>\n", html.EscapeString(utils.WaGetContactName(account, info.MessageSource.Sender)))
+		}
+		if info.IsIncomingBroadcast() {
+			header += "👥: <b>(Broadcast)</b>\n"
+		} else if info.IsGroup {
+			header += fmt.Sprintf("👥: <b>%s</b>\n", html.EscapeString(utils.WaGetGroupName(account, info.Chat)))
+		} else {
+			header += "👥: <b>(PVT)</b>\n"
+		}
+	}
+
+	if isEdited {
+		header += "<i>Edited</i>\n"
+	}
+
+	if time.Since(info.Timestamp).Seconds() > 60 {
+		header += fmt.Sprintf("🕛: <b>%s</b>\n",
+			html.EscapeString(info.Timestamp.In(state.State.LocalLocation).Format(cfg.TimeFormat)))
+	}
+
+	return header
+}
+
+// quoteHtml shows the message being replied to, for when the reply cannot be made to it on
+// Telegram
+func (m *bridgeMessage) quoteHtml(contextInfo *waE2E.ContextInfo) string {
+	sender := "Someone"
+	if participant, err := waTypes.ParseJID(contextInfo.GetParticipant()); contextInfo.GetParticipant() != "" && err == nil {
+		sender = utils.WaGetContactName(m.account, participant)
+	}
+
+	summary := waQuotedSummary(contextInfo.GetQuotedMessage())
+	if len(summary) > 200 {
+		summary = utils.SubString(summary, 0, 200) + "..."
+	}
+
+	return fmt.Sprintf("↩️: <b>%s</b>\n<blockquote>%s</blockquote>\n",
+		html.EscapeString(sender), html.EscapeString(summary))
+}
+
+// waQuotedSummary describes the quoted message in a line of text
+func waQuotedSummary(msg *waE2E.Message) string {
+	withCaption := func(kind, caption string) string {
+		if caption == "" {
+			return kind
+		}
+		return kind + ": " + caption
+	}
+
+	switch {
+	case msg.GetConversation() != "":
+		return msg.GetConversation()
+	case msg.GetExtendedTextMessage().GetText() != "":
+		return msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return withCaption("📷 Photo", msg.GetImageMessage().GetCaption())
+	case msg.GetVideoMessage().GetGifPlayback():
+		return withCaption("🎞 GIF", msg.GetVideoMessage().GetCaption())
+	case msg.GetVideoMessage() != nil:
+		return withCaption("🎥 Video", msg.GetVideoMessage().GetCaption())
+	case msg.GetPtvMessage() != nil:
+		return "🎥 Video note"
+	case msg.GetAudioMessage().GetPTT():
+		return "🎤 Voice note"
+	case msg.GetAudioMessage() != nil:
+		return "🎵 Audio"
+	case msg.GetDocumentMessage() != nil:
+		return withCaption("📄 "+msg.GetDocumentMessage().GetFileName(), msg.GetDocumentMessage().GetCaption())
+	case msg.GetStickerMessage() != nil:
+		return "Sticker"
+	case msg.GetContactMessage() != nil:
+		return "👤 " + msg.GetContactMessage().GetDisplayName()
+	case msg.GetContactsArrayMessage() != nil:
+		return fmt.Sprintf("👤 %d contacts", len(msg.GetContactsArrayMessage().GetContacts()))
+	case msg.GetLocationMessage() != nil:
+		return withCaption("📍 Location", msg.GetLocationMessage().GetName())
+	case msg.GetLiveLocationMessage() != nil:
+		return "📍 Live location"
+	case msg.GetPollCreationMessage() != nil:
+		return "📊 " + msg.GetPollCreationMessage().GetName()
+	case msg.GetPollCreationMessageV2() != nil:
+		return "📊 " + msg.GetPollCreationMessageV2().GetName()
+	case msg.GetPollCreationMessageV3() != nil:
+		return "📊 " + msg.GetPollCreationMessageV3().GetName()
+	}
+	return "Message"
+}
+
+// waMessageContextInfo returns the context info of the message, which holds the replied to
+// message, the mentions and the forwarding details
+func waMessageContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	switch {
+	case msg.GetExtendedTextMessage().GetContextInfo() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetPtvMessage() != nil:
+		return msg.GetPtvMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetContextInfo()
+	case msg.GetContactMessage() != nil:
+		return msg.GetContactMessage().GetContextInfo()
+	case msg.GetContactsArrayMessage() != nil:
+		return msg.GetContactsArrayMessage().GetContextInfo()
+	case msg.GetLocationMessage() != nil:
+		return msg.GetLocationMessage().GetContextInfo()
+	case msg.GetLiveLocationMessage() != nil:
+		return msg.GetLiveLocationMessage().GetContextInfo()
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage().GetContextInfo()
+	case msg.GetPollCreationMessageV2() != nil:
+		return msg.GetPollCreationMessageV2().GetContextInfo()
+	case msg.GetPollCreationMessageV3() != nil:
+		return msg.GetPollCreationMessageV3().GetContextInfo()
+	}
+	return nil
+}
+
+// findThread gets or creates the topic of the WhatsApp chat, failures are reported in the
+// target chat and false is returned
+func (m *bridgeMessage) findThread() bool {
+	var (
+		tgBot       = state.State.TelegramBot
+		info        = m.info
+		threadFound bool
+		err         error
+	)
+
+	if info.Chat.String() == "status@broadcast" {
+		m.threadId, _, err = utils.TgGetOrMakeThreadFromWa_String(m.account, "status@broadcast", m.targetChatId,
+			"Status")
+		if err != nil {
+			utils.TgSendErrorById(tgBot, m.targetChatId, 0, "failed to create/find thread id for 'status@broadcast'", err)
+			return false
+		}
+		return true
+	}
+
+	var waChat waTypes.JID
+	if info.IsIncomingBroadcast() {
+		waChat = info.MessageSource.Sender.ToNonAD()
+		if info.MessageSource.AddressingMode != waTypes.AddressingModePN && !info.MessageSource.SenderAlt.IsEmpty() {
+			waChat = info.MessageSource.SenderAlt.ToNonAD()
+		}
+	} else {
+		waChat = info.Chat.ToNonAD()
+	}
+
+	var threadName string
+	if info.IsGroup && !info.IsIncomingBroadcast() {
+		threadName = utils.WaGetGroupName(m.account, waChat)
+	} else {
+		threadName = utils.WaGetContactName(m.account, waChat)
+	}
+
+	m.threadId, threadFound, err = utils.TgGetOrMakeThreadFromWa(m.account, waChat, m.targetChatId, threadName)
+	if err != nil {
+		utils.TgSendErrorById(tgBot, m.targetChatId, 0, fmt.Sprintf("failed to create/find thread id for '%s'",
+			waChat.String()), err)
+		return false
+	}
+
+	if !info.IsIncomingBroadcast() {
+		SendProfilePictureToNewThread(m.account, m.targetChatId, threadFound, m.threadId, waChat)
+	}
+	return true
+}
+
+// deliver sends the message to Telegram with the given function and maps it to the WhatsApp message
+func (m *bridgeMessage) deliver(send func(ctx context.Context) (*gotgbot.Message, error)) (*gotgbot.Message, error) {
+	sentMsg, err := utils.TgDeliverFromWa(m.account, m.msgId, m.info.MessageSource.Sender.String(), m.info.Chat.String(), send)
+
+	if err == nil && sentMsg != nil && m.version != nil {
+		if err := database.MsgVersionAddNew(m.version); err != nil {
+			state.State.Logger.Error("failed to add message version to database",
+				zap.String("event_id", m.info.ID),
+				zap.Error(err),
+			)
+		}
+		m.version = nil
+	}
+
+	return sentMsg, err
+}
+
+// keepVersion marks the text or caption to be added to the edit history when it is delivered
+func (m *bridgeMessage) keepVersion(text, rendered string, isCaption bool) {
+	if state.State.Config.WhatsApp.SkipEditHistory || text == "" {
+		return
+	}
+
+	m.version = &database.MsgVersion{
+		Account:   m.account.Name,
+		WaMsgId:   m.msgId,
+		WaChatId:  m.info.Chat.String(),
+		Text:      text,
+		Rendered:  rendered,
+		IsCaption: isCaption,
+		IsEdit:    m.isEdited,
+		CreatedAt: m.info.Timestamp,
+	}
+}
+
+func (m *bridgeMessage) replyParameters() *gotgbot.ReplyParameters {
+	return &gotgbot.ReplyParameters{
+		MessageId: m.replyToMsgId,
+	}
+}
+
+// sendText sends the header followed by the given HTML
+func (m *bridgeMessage) sendText(body string) (*gotgbot.Message, error) {
+	tgBot := state.State.TelegramBot
+
+	return m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendMessageWithContext(ctx, m.targetChatId, m.header+body, &gotgbot.SendMessageOpts{
+			ReplyParameters: m.replyParameters(),
+			MessageThreadId: m.threadId,
+		})
+	})
+}
+
+// sendNotice sends a note in place of content which could not be bridged
+func (m *bridgeMessage) sendNotice(notice string) {
+	m.sendText("\n<i>" + notice + "</i>")
+}
+
+// caption returns the header followed by the caption, cut down to fit the limits of Telegram
+func (m *bridgeMessage) caption(caption string) string {
+	rendered := m.header + m.bodyHtml(caption, 1020, nil)
+	m.keepVersion(caption, rendered, true)
+	return rendered
+}
+
+// bodyHtml turns the text and its markup into HTML, cuts it down to the given length and links
+// the mentioned users
+func (m *bridgeMessage) bodyHtml(text string, maxLength int, mentioned []string) string {
+	var body string
+	if len(text) > maxLength {
+		body = utils.WaMarkupToTgHtml(utils.SubString(text, 0, maxLength)) + "..."
+	} else {
+		body = utils.WaMarkupToTgHtml(text)
+	}
+
+	for _, jid := range mentioned {
+		parsedJid, _ := utils.WaParseJID(jid)
+		name := utils.WaGetContactName(m.account, parsedJid)
+		body = strings.ReplaceAll(
+			body, "@"+parsedJid.User,
+			fmt.Sprintf(
+				"<a href=\"https://wa.me/%s\">@%s</a>",
+				parsedJid.User, html.EscapeString(name),
+			),
+		)
+	}
+
+	return body
+}
+
+// downloadMedia downloads the file of the message into a temporary file, which has to be closed
+// once sent. If the file is skipped as configured, too big for Telegram or fails to download, a
+// notice is sent in its place and false is returned.
+func (m *bridgeMessage) downloadMedia(media waMediaMessage, skip bool, skipNoun, skipOption, fileNoun string) (*utils.MediaFile, bool) {
+	cfg := state.State.Config
+
+	if media.GetURL() == "" {
+		return nil, false
+	}
+
+	if skip {
+		m.sendNotice(fmt.Sprintf("Skipping %s because '%s' set in config file", skipNoun, skipOption))
+		return nil, false
+	}
+
+	if sizeLimit := cfg.TgSizeLimit(waSizeLimitType(media)); media.GetFileLength() > sizeLimit {
+		if !m.canCompressVideo(media) && !cfg.MediaStore.Enabled {
+			m.sendNotice(fmt.Sprintf("Couldn't send the %s as it exceeds Telegram size restrictions.", fileNoun))
+			return nil, false
+		}
+
+		// The file is downloaded once, both for compressing it and for keeping it in the media store
+		mediaFile, err := utils.WaDownloadToMediaFile(m.account, media, int64(media.GetFileLength()))
+		if err != nil {
+			m.sendNotice(fmt.Sprintf("Couldn't download the %s due to some errors", fileNoun))
+			return nil, false
+		}
+
+		if m.canCompressVideo(media) && m.compressVideo(media, mediaFile, sizeLimit) {
+			return mediaFile, true
+		}
+		defer mediaFile.Close()
+
+		if cfg.MediaStore.Enabled {
+			m.sendStoreLink(media, mediaFile, fileNoun)
+		} else {
+			m.sendNotice(fmt.Sprintf("Couldn't send the %s as it exceeds Telegram size restrictions.", fileNoun))
+		}
+		return nil, false
+	}
+
+	mediaFile, err := utils.WaDownloadToMediaFile(m.account, media, int64(media.GetFileLength()))
+	if err != nil {
+		m.sendNotice(fmt.Sprintf("Couldn't download the %s due to some errors", fileNoun))
+		return nil, false
+	}
+
+	return mediaFile, true
+}
+
+// canCompressVideo reports whether the media is a video which can be re-encoded to fit Telegram
+func (m *bridgeMessage) canCompressVideo(media waMediaMessage) bool {
+	videoMsg, isVideo := media.(*waE2E.VideoMessage)
+	return state.State.Config.VideoTranscoding.Enabled && isVideo && videoMsg.GetSeconds() != 0
+}
+
+// compressVideo re-encodes the downloaded video which is too big for Telegram to fit, showing the
+// progress in the topic. It returns false if the video cannot be made to fit, the file is then
+// left as it was.
+func (m *bridgeMessage) compressVideo(media waMediaMessage, mediaFile *utils.MediaFile, sizeLimit uint64) bool {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	status := utils.NewTranscodeStatus(m.targetChatId, m.threadId, m.replyToMsgId,
+		fmt.Sprintf("🎞 Compressing a %.1f MB video to fit Telegram", float64(mediaFile.Size)/1024/1024))
+	defer status.Done("")
+
+	err := utils.VideoTranscode(mediaFile, float64(media.(*waE2E.VideoMessage).GetSeconds()), int64(sizeLimit), status.Update)
+	if err != nil {
+		logger.Warn("failed to compress video for Telegram",
+			zap.String("event_id", m.info.ID),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	return true
+}
+
+// sendStoreLink keeps the downloaded file which is too big for Telegram in the media store, and
+// sends a link to download it in its place
+func (m *bridgeMessage) sendStoreLink(media waMediaMessage, mediaFile *utils.MediaFile, fileNoun string) {
+	var (
+		cfg    = state.State.Config
+		logger = state.State.Logger
+	)
+	defer logger.Sync()
+
+	fileName := fileNoun + m.mediaSuffix + "." + mediaExtension(media.GetMimetype(), "bin")
+	if document, ok := media.(interface{ GetFileName() string }); ok && document.GetFileName() != "" {
+		fileName = document.GetFileName()
+	}
+
+	link, expiresAt, err := utils.MediaStoreSave(mediaFile, fileName)
+	if err != nil {
+		logger.Error("failed to keep file in media store",
+			zap.String("event_id", m.info.ID),
+			zap.Error(err),
+		)
+		m.sendNotice(fmt.Sprintf("Couldn't send the %s as it exceeds Telegram size restrictions.", fileNoun))
+		return
+	}
+
+	body := fmt.Sprintf("\n<i>The %s is too big for Telegram (%.1f MB)</i>\n📥 <a href=\"%s\">%s</a>\n<i>The link expires on %s</i>",
+		fileNoun, float64(mediaFile.Size)/1024/1024, html.EscapeString(link), html.EscapeString(fileName),
+		html.EscapeString(expiresAt.In(state.State.LocalLocation).Format(cfg.TimeFormat)))
+	if captioned, ok := media.(interface{ GetCaption() string }); ok && captioned.GetCaption() != "" {
+		body += "\n\n" + m.bodyHtml(captioned.GetCaption(), 3000, nil)
+	}
+
+	m.sendText(body)
+}
+
+// waSizeLimitType returns the media type whose size limit applies to the file
+func waSizeLimitType(media waMediaMessage) string {
+	switch whatsmeow.GetMediaType(media) {
+	case whatsmeow.MediaImage:
+		return "image"
+	case whatsmeow.MediaVideo:
+		return "video"
+	case whatsmeow.MediaAudio:
+		return "audio"
+	}
+	return "document"
+}
+
+// mediaExtension returns the extension for the MIME type of a file, e.g. mp4 for video/mp4
+func mediaExtension(mimetype, fallback string) string {
+	mimetype, _, _ = strings.Cut(mimetype, ";")
+	if _, extension, found := strings.Cut(mimetype, "/"); found && extension != "" {
+		return extension
+	}
+	return fallback
+}
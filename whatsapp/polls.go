@@ -0,0 +1,126 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// renderWaPoll sends the poll with a button for each option, the votes are added to the message
+// as they come in
+func renderWaPoll(m *bridgeMessage, v *events.Message) {
+	var (
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	var pollMsg *waE2E.PollCreationMessage
+	if i := v.Message.GetPollCreationMessage(); i != nil {
+		pollMsg = i
+	} else if i := v.Message.GetPollCreationMessageV2(); i != nil {
+		pollMsg = i
+	} else if i := v.Message.GetPollCreationMessageV3(); i != nil {
+		pollMsg = i
+	}
+
+	var options []string
+	for _, option := range pollMsg.GetOptions() {
+		options = append(options, option.GetOptionName())
+	}
+	optionsJson, _ := json.Marshal(options)
+
+	poll := &database.WaPoll{
+		Account:         m.account.Name,
+		WaMsgId:         m.msgId,
+		WaChatId:        m.info.Chat.String(),
+		SenderId:        m.info.Sender.String(),
+		Question:        pollMsg.GetName(),
+		Options:         string(optionsJson),
+		SelectableCount: pollMsg.GetSelectableOptionsCount(),
+		Header:          m.header,
+	}
+	if err := database.WaPollAddNew(poll); err != nil {
+		logger.Error("failed to add poll to database",
+			zap.String("msg_id", m.msgId),
+			zap.Error(err),
+		)
+	}
+
+	text, keyboard := utils.TgRenderWaPoll(m.account, poll, nil)
+	m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendMessageWithContext(ctx, m.targetChatId, text, &gotgbot.SendMessageOpts{
+			ReplyParameters: m.replyParameters(),
+			MessageThreadId: m.threadId,
+			ReplyMarkup:     keyboard,
+		})
+	})
+}
+
+// PollVoteEventHandler decrypts a vote in a bridged poll and updates the votes shown on Telegram
+func PollVoteEventHandler(account *state.WhatsAppAccount, v *events.Message) {
+	logger := state.State.Logger
+	defer logger.Sync()
+
+	pollKey := v.Message.GetPollUpdateMessage().GetPollCreationMessageKey()
+
+	poll, found, err := database.WaPollGet(account.Name, pollKey.GetID(), v.Info.Chat.String())
+	if err != nil || !found {
+		return
+	}
+
+	vote, err := account.Client.DecryptPollVote(context.Background(), v)
+	if err != nil {
+		logger.Warn("failed to decrypt poll vote",
+			zap.String("poll_id", poll.WaMsgId),
+			zap.String("event_id", v.Info.ID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	options := utils.WaPollOptions(&poll)
+	optionHashes := whatsmeow.HashPollOptions(options)
+
+	selected := []string{}
+	for _, selectedHash := range vote.GetSelectedOptions() {
+		if i := slices.IndexFunc(optionHashes, func(optionHash []byte) bool {
+			return bytes.Equal(optionHash, selectedHash)
+		}); i >= 0 {
+			selected = append(selected, options[i])
+		}
+	}
+	selectedJson, _ := json.Marshal(selected)
+
+	err = database.WaPollVoteSet(account.Name, poll.WaMsgId, poll.WaChatId, v.Info.Sender.ToNonAD().String(), string(selectedJson))
+	if err != nil {
+		logger.Error("failed to save poll vote to database",
+			zap.String("poll_id", poll.WaMsgId),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if poll.FromTelegram {
+		// The votes are shown with /pollresults
+		return
+	}
+
+	if err = utils.TgUpdateWaPoll(account, &poll); err != nil {
+		logger.Warn("failed to update votes of bridged poll",
+			zap.String("poll_id", poll.WaMsgId),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,676 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	goVCard "github.com/emersion/go-vcard"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// waRenderer sends one type of WhatsApp content to Telegram
+type waRenderer struct {
+	name    string
+	matches func(m *bridgeMessage, msg *waE2E.Message) bool
+	render  func(m *bridgeMessage, v *events.Message)
+}
+
+// waRenderers are tried in order and the first one matching the message renders it, so the more
+// specific ones (GIFs before videos, voice notes before audios) have to come first
+var waRenderers = []waRenderer{
+	{
+		name:    "image",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return msg.GetImageMessage() != nil },
+		render:  renderWaImage,
+	},
+	{
+		name: "gif",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool {
+			return msg.GetVideoMessage() != nil && msg.GetVideoMessage().GetGifPlayback()
+		},
+		render: renderWaGif,
+	},
+	{
+		name: "video",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool {
+			return msg.GetVideoMessage() != nil || msg.GetPtvMessage() != nil
+		},
+		render: renderWaVideo,
+	},
+	{
+		name: "voice_note",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool {
+			return msg.GetAudioMessage() != nil && msg.GetAudioMessage().GetPTT()
+		},
+		render: renderWaVoiceNote,
+	},
+	{
+		name:    "audio",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return msg.GetAudioMessage() != nil },
+		render:  renderWaAudio,
+	},
+	{
+		name:    "document",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return msg.GetDocumentMessage() != nil },
+		render:  renderWaDocument,
+	},
+	{
+		name:    "sticker",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return msg.GetStickerMessage() != nil },
+		render:  renderWaSticker,
+	},
+	{
+		name:    "contact",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return msg.GetContactMessage() != nil },
+		render:  renderWaContact,
+	},
+	{
+		name:    "contacts_array",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return msg.GetContactsArrayMessage() != nil },
+		render:  renderWaContactsArray,
+	},
+	{
+		name:    "location",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return msg.GetLocationMessage() != nil },
+		render:  renderWaLocation,
+	},
+	{
+		name:    "live_location",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return msg.GetLiveLocationMessage() != nil },
+		render:  renderWaLiveLocation,
+	},
+	{
+		name: "poll",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool {
+			return msg.GetPollCreationMessage() != nil || msg.GetPollCreationMessageV2() != nil || msg.GetPollCreationMessageV3() != nil
+		},
+		render: renderWaPoll,
+	},
+	{
+		name:    "album",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return msg.GetAlbumMessage() != nil },
+		render:  renderWaAlbum,
+	},
+	{
+		name: "reaction",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool {
+			return m.text == "" && msg.GetReactionMessage() != nil
+		},
+		render: renderWaReaction,
+	},
+	{
+		name:    "text",
+		matches: func(m *bridgeMessage, msg *waE2E.Message) bool { return m.text != "" },
+		render:  renderWaText,
+	},
+}
+
+// renderWaMessage sends the message with the first matching renderer, messages which no
+// renderer knows of are dropped
+func renderWaMessage(m *bridgeMessage, v *events.Message) {
+	logger := state.State.Logger
+
+	for _, renderer := range waRenderers {
+		if renderer.matches(m, v.Message) {
+			logger.Debug("rendering WhatsApp message",
+				zap.String("event_id", v.Info.ID),
+				zap.String("renderer", renderer.name),
+			)
+			renderer.render(m, v)
+			return
+		}
+	}
+}
+
+func renderWaImage(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg      = state.State.Config
+		tgBot    = state.State.TelegramBot
+		imageMsg = v.Message.GetImageMessage()
+	)
+
+	imageFile, ok := m.downloadMedia(imageMsg, cfg.WhatsApp.SkipImages, "image", "skip_images", "photo")
+	if !ok {
+		return
+	}
+
+	caption := m.caption(imageMsg.GetCaption())
+	send := func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendPhotoWithContext(ctx, m.targetChatId, &gotgbot.FileReader{Data: imageFile.Reader()}, &gotgbot.SendPhotoOpts{
+			Caption:         caption,
+			ReplyParameters: m.replyParameters(),
+			HasSpoiler:      imageMsg.GetViewOnce(),
+			MessageThreadId: m.threadId,
+		})
+	}
+
+	if albumId := waAlbumParentId(v.Message); albumId != "" {
+		m.addToAlbum(albumId, waAlbumItem{
+			m:          m,
+			fileName:   "image" + m.mediaSuffix + ".jpg",
+			file:       imageFile,
+			caption:    imageMsg.GetCaption(),
+			hasSpoiler: imageMsg.GetViewOnce(),
+			send:       send,
+		})
+		return
+	}
+
+	defer imageFile.Close()
+	m.deliver(send)
+}
+
+func renderWaGif(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg    = state.State.Config
+		tgBot  = state.State.TelegramBot
+		gifMsg = v.Message.GetVideoMessage()
+	)
+
+	gifFile, ok := m.downloadMedia(gifMsg, cfg.WhatsApp.SkipGIFs, "GIF", "skip_gifs", "GIF")
+	if !ok {
+		return
+	}
+	defer gifFile.Close()
+
+	caption := m.caption(gifMsg.GetCaption())
+	fileToSend := gotgbot.FileReader{
+		Name: "animation" + m.mediaSuffix + ".gif",
+		Data: gifFile.Reader(),
+	}
+
+	m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendAnimationWithContext(ctx, m.targetChatId, &fileToSend, &gotgbot.SendAnimationOpts{
+			Caption:         caption,
+			ReplyParameters: m.replyParameters(),
+			MessageThreadId: m.threadId,
+		})
+	})
+}
+
+func renderWaVideo(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg      = state.State.Config
+		tgBot    = state.State.TelegramBot
+		videoMsg = v.Message.GetVideoMessage()
+		isPtvMsg = false
+	)
+
+	if videoMsg == nil {
+		videoMsg = v.Message.GetPtvMessage()
+		isPtvMsg = true
+	}
+
+	videoFile, ok := m.downloadMedia(videoMsg, cfg.WhatsApp.SkipVideos, "video", "skip_videos", "video")
+	if !ok {
+		return
+	}
+
+	caption := m.caption(videoMsg.GetCaption())
+	fileToSend := gotgbot.FileReader{
+		Name: "video" + m.mediaSuffix + "." + mediaExtension(videoMsg.GetMimetype(), "mp4"),
+		Data: videoFile.Reader(),
+	}
+
+	send := func(ctx context.Context) (*gotgbot.Message, error) {
+		if isPtvMsg {
+			return tgBot.SendVideoNoteWithContext(ctx, m.targetChatId, &fileToSend, &gotgbot.SendVideoNoteOpts{
+				ReplyMarkup:     m.replyMarkup,
+				ReplyParameters: m.replyParameters(),
+				MessageThreadId: m.threadId,
+			})
+		}
+		return tgBot.SendVideoWithContext(ctx, m.targetChatId, &fileToSend, &gotgbot.SendVideoOpts{
+			Caption:         caption,
+			ReplyParameters: m.replyParameters(),
+			HasSpoiler:      videoMsg.GetViewOnce(),
+			MessageThreadId: m.threadId,
+		})
+	}
+
+	if albumId := waAlbumParentId(v.Message); albumId != "" && !isPtvMsg {
+		m.addToAlbum(albumId, waAlbumItem{
+			m:          m,
+			isVideo:    true,
+			fileName:   fileToSend.Name,
+			file:       videoFile,
+			caption:    videoMsg.GetCaption(),
+			hasSpoiler: videoMsg.GetViewOnce(),
+			send:       send,
+		})
+		return
+	}
+
+	defer videoFile.Close()
+	m.deliver(send)
+}
+
+func renderWaVoiceNote(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg      = state.State.Config
+		logger   = state.State.Logger
+		tgBot    = state.State.TelegramBot
+		audioMsg = v.Message.GetAudioMessage()
+	)
+	defer logger.Sync()
+
+	audioFile, ok := m.downloadMedia(audioMsg, cfg.WhatsApp.SkipVoiceNotes, "voice note", "skip_voice_notes", "audio")
+	if !ok {
+		return
+	}
+	defer audioFile.Close()
+
+	// Telegram only shows Opus in OGG as a voice note, anything else would be a music file
+	isOpus := strings.HasPrefix(audioMsg.GetMimetype(), "audio/ogg")
+	if !isOpus && cfg.FfmpegExecutable != "" {
+		if err := utils.VoiceToOpus(audioFile); err != nil {
+			logger.Warn("failed to convert voice note to Opus",
+				zap.String("event_id", v.Info.ID),
+				zap.Error(err),
+			)
+		} else {
+			isOpus = true
+		}
+	}
+
+	fileToSend := gotgbot.FileReader{
+		Name: "audio" + m.mediaSuffix + ".ogg",
+		Data: audioFile.Reader(),
+	}
+
+	if !isOpus {
+		fileToSend.Name = "audio" + m.mediaSuffix + "." + mediaExtension(audioMsg.GetMimetype(), "m4a")
+		m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+			return tgBot.SendAudioWithContext(ctx, m.targetChatId, &fileToSend, &gotgbot.SendAudioOpts{
+				Caption:         m.header,
+				Duration:        int64(audioMsg.GetSeconds()),
+				ReplyParameters: m.replyParameters(),
+				MessageThreadId: m.threadId,
+			})
+		})
+		return
+	}
+
+	m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendVoiceWithContext(ctx, m.targetChatId, &fileToSend, &gotgbot.SendVoiceOpts{
+			Caption:         m.header,
+			Duration:        int64(audioMsg.GetSeconds()),
+			ReplyParameters: m.replyParameters(),
+			MessageThreadId: m.threadId,
+		})
+	})
+}
+
+func renderWaAudio(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg      = state.State.Config
+		tgBot    = state.State.TelegramBot
+		audioMsg = v.Message.GetAudioMessage()
+	)
+
+	audioFile, ok := m.downloadMedia(audioMsg, cfg.WhatsApp.SkipAudios, "audio", "skip_audios", "audio")
+	if !ok {
+		return
+	}
+	defer audioFile.Close()
+
+	fileToSend := gotgbot.FileReader{
+		Name: "audio" + m.mediaSuffix + ".m4a",
+		Data: audioFile.Reader(),
+	}
+
+	m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendAudioWithContext(ctx, m.targetChatId, &fileToSend, &gotgbot.SendAudioOpts{
+			Caption:         m.header,
+			Duration:        int64(audioMsg.GetSeconds()),
+			ReplyParameters: m.replyParameters(),
+			MessageThreadId: m.threadId,
+		})
+	})
+}
+
+func renderWaDocument(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg         = state.State.Config
+		tgBot       = state.State.TelegramBot
+		documentMsg = v.Message.GetDocumentMessage()
+	)
+
+	documentFile, ok := m.downloadMedia(documentMsg, cfg.WhatsApp.SkipDocuments, "document", "skip_documents", "document")
+	if !ok {
+		return
+	}
+	defer documentFile.Close()
+
+	caption := m.caption(documentMsg.GetCaption())
+	fileToSend := gotgbot.FileReader{
+		Name: documentMsg.GetFileName(),
+		Data: documentFile.Reader(),
+	}
+
+	m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendDocumentWithContext(ctx, m.targetChatId, &fileToSend, &gotgbot.SendDocumentOpts{
+			Caption:         caption,
+			ReplyParameters: m.replyParameters(),
+			MessageThreadId: m.threadId,
+		})
+	})
+}
+
+func renderWaSticker(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg        = state.State.Config
+		tgBot      = state.State.TelegramBot
+		stickerMsg = v.Message.GetStickerMessage()
+	)
+
+	stickerFile, ok := m.downloadMedia(stickerMsg, cfg.WhatsApp.SkipStickers, "sticker", "skip_stickers", "sticker")
+	if !ok {
+		return
+	}
+	defer stickerFile.Close()
+
+	// Stickers are small and have to be converted in memory
+	stickerBytes, err := stickerFile.ReadAll()
+	if err != nil {
+		m.sendNotice("Couldn't download the sticker due to some errors")
+		return
+	}
+
+	if stickerMsg.GetIsAnimated() || stickerMsg.GetIsAvatar() {
+		// Telegram does not take animated WebP stickers, so they are sent as GIFs
+		gifBytes, err := utils.AnimatedWebpConvertToGif(stickerBytes, v.Info.ID)
+		if err == nil {
+			fileToSend := gotgbot.FileReader{
+				Name: "animation.gif",
+				Data: bytes.NewReader(gifBytes),
+			}
+
+			m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+				return tgBot.SendAnimationWithContext(ctx, m.targetChatId, &fileToSend, &gotgbot.SendAnimationOpts{
+					Caption:         m.header,
+					ReplyParameters: m.replyParameters(),
+					MessageThreadId: m.threadId,
+					ReplyMarkup:     m.replyMarkup,
+				})
+			})
+			return
+		}
+	}
+
+	m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendStickerWithContext(ctx, m.targetChatId, &gotgbot.FileReader{Data: bytes.NewReader(stickerBytes)}, &gotgbot.SendStickerOpts{
+			ReplyParameters: m.replyParameters(),
+			MessageThreadId: m.threadId,
+			ReplyMarkup:     m.replyMarkup,
+		})
+	})
+}
+
+func renderWaContact(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg        = state.State.Config
+		tgBot      = state.State.TelegramBot
+		contactMsg = v.Message.GetContactMessage()
+	)
+
+	if cfg.WhatsApp.SkipContacts {
+		m.sendNotice("Skipping contact because 'skip_contacts' set in config file")
+		return
+	}
+
+	card, err := goVCard.NewDecoder(bytes.NewReader([]byte(contactMsg.GetVcard()))).Decode()
+	if err != nil {
+		m.sendNotice("Couldn't send the vCard as failed to parse it")
+		return
+	}
+
+	m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendContactWithContext(ctx, m.targetChatId, card.PreferredValue(goVCard.FieldTelephone), contactMsg.GetDisplayName(),
+			&gotgbot.SendContactOpts{
+				Vcard:           contactMsg.GetVcard(),
+				ReplyParameters: m.replyParameters(),
+				MessageThreadId: m.threadId,
+				ReplyMarkup:     m.replyMarkup,
+			})
+	})
+}
+
+func renderWaContactsArray(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg         = state.State.Config
+		tgBot       = state.State.TelegramBot
+		contactsMsg = v.Message.GetContactsArrayMessage()
+	)
+
+	if cfg.WhatsApp.SkipContacts {
+		m.sendNotice("Skipping contact array because 'skip_contacts' set in config file")
+		return
+	}
+
+	for _, contactMsg := range contactsMsg.Contacts {
+		card, err := goVCard.NewDecoder(bytes.NewReader([]byte(contactMsg.GetVcard()))).Decode()
+		if err != nil {
+			tgBot.SendMessage(m.targetChatId, "Couldn't send the vCard as failed to parse it",
+				&gotgbot.SendMessageOpts{
+					ReplyParameters: m.replyParameters(),
+					MessageThreadId: m.threadId,
+				})
+			continue
+		}
+
+		m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+			return tgBot.SendContactWithContext(ctx, m.targetChatId, card.PreferredValue(goVCard.FieldTelephone), contactMsg.GetDisplayName(),
+				&gotgbot.SendContactOpts{
+					Vcard:           contactMsg.GetVcard(),
+					ReplyParameters: m.replyParameters(),
+					MessageThreadId: m.threadId,
+					ReplyMarkup:     m.replyMarkup,
+				})
+		})
+	}
+}
+
+func renderWaLocation(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg         = state.State.Config
+		tgBot       = state.State.TelegramBot
+		locationMsg = v.Message.GetLocationMessage()
+	)
+
+	if cfg.WhatsApp.SkipLocations {
+		m.sendNotice("Skipping location because 'skip_locations' set in config file")
+		return
+	}
+
+	m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendLocationWithContext(ctx, m.targetChatId, locationMsg.GetDegreesLatitude(), locationMsg.GetDegreesLongitude(),
+			&gotgbot.SendLocationOpts{
+				HorizontalAccuracy: float64(locationMsg.GetAccuracyInMeters()),
+				ReplyParameters:    m.replyParameters(),
+				MessageThreadId:    m.threadId,
+			})
+	})
+}
+
+func renderWaReaction(m *bridgeMessage, v *events.Message) {
+	var (
+		cfg         = state.State.Config
+		logger      = state.State.Logger
+		tgBot       = state.State.TelegramBot
+		reactionMsg = v.Message.GetReactionMessage()
+	)
+
+	if !cfg.Telegram.Reactions {
+		return
+	}
+
+	// Resolve LID to PN for private chats using new WhatsApp LID system
+	waChatIdForLookup := v.Info.Chat.String()
+	if v.Info.Chat.Server == waTypes.HiddenUserServer {
+		pn, err := m.account.Client.Store.LIDs.GetPNForLID(context.Background(), v.Info.Chat.ToNonAD())
+		if err != nil {
+			logger.Warn(
+				"failed to get PN for LID when handling reaction",
+				zap.Error(err),
+				zap.String("lid", v.Info.Chat.String()),
+			)
+		} else {
+			waChatIdForLookup = pn.String()
+		}
+	}
+
+	tgChatId, _, tgMsgId, err := database.MsgIdGetTgFromWa(m.account.Name, reactionMsg.Key.GetID(), waChatIdForLookup)
+	if err != nil {
+		logger.Error(
+			"failed to get message ID mapping from database",
+			zap.Error(err),
+			zap.String("stanza_id", reactionMsg.Key.GetID()),
+			zap.String("chat_id", waChatIdForLookup),
+		)
+		return
+	} else if tgChatId != m.targetChatId {
+		return
+	}
+
+	err = database.WaReactionSet(m.account.Name, reactionMsg.Key.GetID(), waChatIdForLookup,
+		v.Info.MessageSource.Sender.ToNonAD().String(), reactionMsg.GetText())
+	if err != nil {
+		logger.Error(
+			"failed to save reaction to database",
+			zap.Error(err),
+			zap.String("stanza_id", reactionMsg.Key.GetID()),
+		)
+	} else if utils.TgSetWaReactions(m.account, reactionMsg.Key.GetID(), waChatIdForLookup, tgChatId, tgMsgId) {
+		return
+	}
+
+	// Telegram did not take the reaction, so it is told with a message instead
+	var reactionText string
+	if reactionMsg.GetText() != "" {
+		reactionText = fmt.Sprintf(
+			"<code>Reacted to this message with %s</code>",
+			html.EscapeString(reactionMsg.GetText()),
+		)
+	} else {
+		reactionText = "<code>Revoked their reaction to this message</code>"
+	}
+
+	utils.TgDeliverFromWa(m.account, m.msgId, v.Info.MessageSource.Sender.String(), waChatIdForLookup, func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendMessageWithContext(ctx, m.targetChatId, m.header+reactionText, &gotgbot.SendMessageOpts{
+			ReplyParameters: &gotgbot.ReplyParameters{
+				MessageId: tgMsgId,
+			},
+			MessageThreadId: m.threadId,
+		})
+	})
+}
+
+func renderWaText(m *bridgeMessage, v *events.Message) {
+	var (
+		tgBot   = state.State.TelegramBot
+		textMsg = v.Message.GetExtendedTextMessage()
+	)
+
+	previewHtml := waLinkPreviewHtml(textMsg)
+	if thumbnail := textMsg.GetJPEGThumbnail(); previewHtml != "" && len(thumbnail) > 0 {
+		// The thumbnail is sent as a photo, which leaves less room for the text
+		caption := m.header + m.bodyHtml(m.text, max(1020-len([]rune(previewHtml)), 100),
+			textMsg.GetContextInfo().GetMentionedJID()) + previewHtml
+		m.keepVersion(m.text, caption, true)
+
+		m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+			return tgBot.SendPhotoWithContext(ctx, m.targetChatId, &gotgbot.FileReader{Name: "preview.jpg", Data: bytes.NewReader(thumbnail)}, &gotgbot.SendPhotoOpts{
+				Caption:         caption,
+				ReplyParameters: m.replyParameters(),
+				MessageThreadId: m.threadId,
+			})
+		})
+		return
+	}
+
+	body := m.bodyHtml(m.text, 4000, textMsg.GetContextInfo().GetMentionedJID()) + previewHtml
+	m.keepVersion(m.text, m.header+body, false)
+
+	linkPreviewOptions := waLinkPreviewOptions(textMsg)
+	if linkPreviewOptions == nil {
+		m.sendText(body)
+		return
+	}
+
+	m.deliver(func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendMessageWithContext(ctx, m.targetChatId, m.header+body, &gotgbot.SendMessageOpts{
+			ReplyParameters:    m.replyParameters(),
+			MessageThreadId:    m.threadId,
+			LinkPreviewOptions: linkPreviewOptions,
+		})
+	})
+}
+
+// waHasLinkPreview reports whether the sender's phone made a preview of the link, WhatsApp only
+// has the details of a link when the sender saw a preview of it
+func waHasLinkPreview(textMsg *waE2E.ExtendedTextMessage) bool {
+	return textMsg.GetMatchedText() != "" &&
+		(textMsg.GetTitle() != "" || textMsg.GetDescription() != "" || len(textMsg.GetJPEGThumbnail()) > 0)
+}
+
+// waLinkPreviewHtml renders the title and description of the WhatsApp preview as a block to put
+// under the text, or returns an empty string when there is no preview. The messages carry no
+// canonical address of the page, so the block links to the address as it was written.
+func waLinkPreviewHtml(textMsg *waE2E.ExtendedTextMessage) string {
+	if !waHasLinkPreview(textMsg) {
+		return ""
+	}
+
+	title := waCutPreviewText(textMsg.GetTitle(), 100)
+	if title == "" {
+		title = textMsg.GetMatchedText()
+	}
+
+	preview := fmt.Sprintf("\n\n<blockquote><a href=\"%s\"><b>%s</b></a>",
+		html.EscapeString(textMsg.GetMatchedText()), html.EscapeString(title))
+	if description := waCutPreviewText(textMsg.GetDescription(), 200); description != "" {
+		preview += "\n" + html.EscapeString(description)
+	}
+	return preview + "</blockquote>"
+}
+
+// waCutPreviewText cuts the text of a preview down to the given number of characters
+func waCutPreviewText(text string, maxLength int) string {
+	text = strings.TrimSpace(text)
+	if asRunes := []rune(text); len(asRunes) > maxLength {
+		return string(asRunes[:maxLength]) + "..."
+	}
+	return text
+}
+
+// waLinkPreviewOptions decides the preview Telegram shows under the WhatsApp text. When WhatsApp
+// has a preview it is rendered from its own details by waLinkPreviewHtml, so the one of Telegram
+// is turned off. Otherwise Telegram is asked for a preview of the link the text had, of the size
+// WhatsApp would show. Nil is returned for texts without a link.
+func waLinkPreviewOptions(textMsg *waE2E.ExtendedTextMessage) *gotgbot.LinkPreviewOptions {
+	if waHasLinkPreview(textMsg) {
+		return &gotgbot.LinkPreviewOptions{IsDisabled: true}
+	}
+	if textMsg.GetMatchedText() == "" {
+		return nil
+	}
+
+	previewType := textMsg.GetPreviewType()
+	return &gotgbot.LinkPreviewOptions{
+		Url:              textMsg.GetMatchedText(),
+		PreferLargeMedia: previewType == waE2E.ExtendedTextMessage_VIDEO || previewType == waE2E.ExtendedTextMessage_IMAGE,
+		PreferSmallMedia: previewType == waE2E.ExtendedTextMessage_NONE,
+	}
+}
@@ -0,0 +1,158 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+)
+
+// waRevokePolicy returns how revoked messages of the chat are handled, chats can be listed in
+// revoke_policy_chats by their number or JID to override revoke_policy
+func waRevokePolicy(account *state.WhatsAppAccount, waChat waTypes.JID) string {
+	cfg := state.State.Config
+
+	if len(cfg.WhatsApp.RevokePolicyChats) == 0 {
+		return cfg.WhatsApp.RevokePolicy
+	}
+
+	waChat = waChat.ToNonAD()
+	chatIds := []string{waChat.User, waChat.String()}
+	if waChat.Server == waTypes.HiddenUserServer {
+		if pn, err := account.Client.Store.LIDs.GetPNForLID(context.Background(), waChat); err == nil && !pn.IsEmpty() {
+			chatIds = append(chatIds, pn.User, pn.String())
+		}
+	}
+
+	for chat, policy := range cfg.WhatsApp.RevokePolicyChats {
+		for _, chatId := range chatIds {
+			if strings.TrimPrefix(chat, "+") == chatId {
+				return policy
+			}
+		}
+	}
+	return cfg.WhatsApp.RevokePolicy
+}
+
+// waRevokeByStrike strikes through the text or caption of the bridged message and appends the
+// note. It returns false if the message has no stored text to strike through or cannot be
+// edited anymore.
+func waRevokeByStrike(account *state.WhatsAppAccount, waMsgId, waChatId string, tgChatId, tgMsgId int64, note string) bool {
+	var (
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	versions, err := database.MsgVersionGetAll(account.Name, waMsgId, waChatId)
+	if err != nil {
+		logger.Error("failed to get versions of revoked message from database",
+			zap.String("msg_id", waMsgId),
+			zap.Error(err),
+		)
+		return false
+	}
+	if len(versions) == 0 {
+		return false
+	}
+
+	latest := versions[len(versions)-1]
+	rendered := "<s>" + latest.Rendered + "</s>\n\n" + note
+	noButtons := gotgbot.InlineKeyboardMarkup{InlineKeyboard: [][]gotgbot.InlineKeyboardButton{}}
+
+	if latest.IsCaption {
+		_, _, err = tgBot.EditMessageCaption(&gotgbot.EditMessageCaptionOpts{
+			ChatId:      tgChatId,
+			MessageId:   tgMsgId,
+			Caption:     rendered,
+			ReplyMarkup: noButtons,
+		})
+	} else {
+		_, _, err = tgBot.EditMessageText(rendered, &gotgbot.EditMessageTextOpts{
+			ChatId:      tgChatId,
+			MessageId:   tgMsgId,
+			ReplyMarkup: noButtons,
+		})
+	}
+
+	if err != nil {
+		logger.Warn("failed to strike through revoked message, replying to it instead",
+			zap.String("msg_id", waMsgId),
+			zap.Int64("tg_msg_id", tgMsgId),
+			zap.Error(err),
+		)
+		return false
+	}
+	return true
+}
+
+// waRevokeByDelete copies the bridged message to the archive topic of the Telegram chat, with
+// the note and the name of the WhatsApp chat as a reply, and then deletes it from the chat
+// topic. Nothing is deleted if the copy could not be made.
+func waRevokeByDelete(account *state.WhatsAppAccount, waChat waTypes.JID, tgChatId, tgMsgId int64, note string) bool {
+	var (
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	archiveThreadId, _, err := utils.TgGetOrMakeThreadFromWa_String(account, "revoked_archive", tgChatId,
+		"Revoked Messages")
+	if err != nil {
+		logger.Error("failed to create/find thread id for revoked messages",
+			zap.Int64("tg_chat_id", tgChatId),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	archivedMsg, err := tgBot.CopyMessage(tgChatId, tgChatId, tgMsgId, &gotgbot.CopyMessageOpts{
+		MessageThreadId: archiveThreadId,
+	})
+	if err != nil {
+		logger.Warn("failed to copy revoked message to archive, replying to it instead",
+			zap.Int64("tg_msg_id", tgMsgId),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	var chatName string
+	if waChat.Server == waTypes.GroupServer {
+		chatName = utils.WaGetGroupName(account, waChat)
+	} else {
+		chatName = utils.WaGetContactName(account, waChat)
+	}
+
+	tgBot.SendMessage(tgChatId, fmt.Sprintf("%s\n👥: <b>%s</b>", note, html.EscapeString(chatName)), &gotgbot.SendMessageOpts{
+		MessageThreadId: archiveThreadId,
+		ReplyParameters: &gotgbot.ReplyParameters{
+			MessageId: archivedMsg.MessageId,
+		},
+	})
+
+	_, err = tgBot.DeleteMessage(tgChatId, tgMsgId, nil)
+	if err != nil {
+		logger.Warn("failed to delete revoked message after archiving it, replying to it instead",
+			zap.Int64("tg_msg_id", tgMsgId),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	if err = database.MsgIdDeletePair(tgChatId, tgMsgId); err != nil {
+		logger.Error("failed to delete message id pair of revoked message",
+			zap.Int64("tg_msg_id", tgMsgId),
+			zap.Error(err),
+		)
+	}
+	return true
+}
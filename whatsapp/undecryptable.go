@@ -0,0 +1,126 @@
+package whatsapp
+
+import (
+	"context"
+
+	"watgbridge/database"
+	"watgbridge/state"
+	"watgbridge/utils"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// sendUndecryptablePlaceholder tells that a message is on its way when WhatsApp could not decrypt
+// it, whatsmeow asks the sender's phone to send it again. The placeholder is not mapped to the
+// message, so the message is not taken for a duplicate when it arrives. A placeholder which
+// goes through the inbound queue is only delivered later and is not replaced, the message is
+// then sent as a message of its own.
+func (m *bridgeMessage) sendUndecryptablePlaceholder() {
+	var (
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	// The event is emitted again for every retry which fails
+	_, found, err := database.WaUndecryptableGet(m.account.Name, m.msgId, m.info.Chat.String())
+	if err != nil || found {
+		return
+	}
+
+	// Sent without the message ID, which would pair the placeholder with the message
+	sentMsg, err := utils.TgDeliverFromWa(m.account, "", m.info.MessageSource.Sender.String(), m.info.Chat.String(), func(ctx context.Context) (*gotgbot.Message, error) {
+		return tgBot.SendMessageWithContext(ctx, m.targetChatId,
+			m.header+"\n<i>⏳ Waiting for this message, WhatsApp could not decrypt it yet</i>",
+			&gotgbot.SendMessageOpts{
+				MessageThreadId: m.threadId,
+				ReplyMarkup:     m.replyMarkup,
+			})
+	})
+	if err != nil || sentMsg == nil {
+		return
+	}
+
+	err = database.WaUndecryptableAddNew(&database.WaUndecryptable{
+		Account:    m.account.Name,
+		WaMsgId:    m.msgId,
+		WaChatId:   m.info.Chat.String(),
+		TgChatId:   sentMsg.Chat.Id,
+		TgThreadId: sentMsg.MessageThreadId,
+		TgMsgId:    sentMsg.MessageId,
+		Header:     m.header,
+		CreatedAt:  m.info.Timestamp,
+	})
+	if err != nil {
+		logger.Error("failed to add undecryptable message to database",
+			zap.String("event_id", m.info.ID),
+			zap.Error(err),
+		)
+	}
+}
+
+// renderWaDecrypted puts a message which was decrypted on a retry in place of its placeholder.
+// Texts are edited into the placeholder, for anything else the placeholder is removed and false
+// is returned to send the message as usual.
+func renderWaDecrypted(m *bridgeMessage, v *events.Message) bool {
+	var (
+		logger = state.State.Logger
+		tgBot  = state.State.TelegramBot
+	)
+	defer logger.Sync()
+
+	placeholder, found, err := database.WaUndecryptableGet(m.account.Name, m.msgId, m.info.Chat.String())
+	if err != nil || !found {
+		return false
+	}
+	database.WaUndecryptableDelete(m.account.Name, m.msgId, m.info.Chat.String())
+
+	textMsg := v.Message.GetExtendedTextMessage()
+	// A text with the thumbnail of a link is sent as a photo, which cannot take the place of a text
+	if m.text != "" && (v.Message.GetConversation() != "" || textMsg != nil) && len(textMsg.GetJPEGThumbnail()) == 0 {
+		body := m.bodyHtml(m.text, 4000, textMsg.GetContextInfo().GetMentionedJID()) + waLinkPreviewHtml(textMsg)
+
+		opts := &gotgbot.EditMessageTextOpts{
+			ChatId:             placeholder.TgChatId,
+			MessageId:          placeholder.TgMsgId,
+			ReplyMarkup:        m.replyMarkup,
+			LinkPreviewOptions: waLinkPreviewOptions(textMsg),
+		}
+
+		_, _, err = tgBot.EditMessageText(m.header+body, opts)
+		if err == nil {
+			database.MsgIdAddNewPair(m.account.Name, m.msgId, m.info.MessageSource.Sender.String(), m.info.Chat.String(),
+				placeholder.TgChatId, placeholder.TgMsgId, placeholder.TgThreadId)
+
+			m.keepVersion(m.text, m.header+body, false)
+			if m.version != nil {
+				if err := database.MsgVersionAddNew(m.version); err != nil {
+					logger.Error("failed to add message version to database",
+						zap.String("event_id", m.info.ID),
+						zap.Error(err),
+					)
+				}
+				m.version = nil
+			}
+			return true
+		}
+
+		logger.Warn("failed to edit placeholder of undecryptable message, sending the message anew",
+			zap.String("event_id", m.info.ID),
+			zap.Int64("tg_msg_id", placeholder.TgMsgId),
+			zap.Error(err),
+		)
+	}
+
+	if _, err := tgBot.DeleteMessage(placeholder.TgChatId, placeholder.TgMsgId, nil); err != nil {
+		logger.Warn("failed to delete placeholder of undecryptable message",
+			zap.String("event_id", m.info.ID),
+			zap.Int64("tg_msg_id", placeholder.TgMsgId),
+			zap.Error(err),
+		)
+	}
+
+	return false
+}